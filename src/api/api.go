@@ -1,48 +1,133 @@
 package api
 
 import (
-	"errors"
+	"context"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
+	"api/apierr"
 	"api/db"
+	"logging"
+	"metrics"
 )
 
 var (
-	ErrInsufficientFunds          = errors.New("Not enough funds")
-	ErrInvalidQueryResult         = errors.New("Invalid query result")
-	ErrTournamentAlreadyAnnounced = errors.New("Another tournament is already announced")
+	ErrInsufficientFunds          = apierr.New(apierr.CodeInsufficientFunds, "Not enough funds")
+	ErrInvalidQueryResult         = apierr.New(apierr.CodeInvalidArgument, "Invalid query result")
+	ErrTournamentAlreadyAnnounced = apierr.New(apierr.CodeTournamentAlreadyActive, "Another tournament is already announced")
+	ErrTournamentExists           = apierr.New(apierr.CodeAlreadyExists, "Tournament already exists")
+	ErrPlayerNotFound             = apierr.New(apierr.CodePlayerNotFound, "Player not found")
+	ErrTournamentNotFound         = apierr.New(apierr.CodeTournamentNotFound, "Tournament not found")
+	ErrSeasonNotFound             = apierr.New(apierr.CodeSeasonNotFound, "Season not found")
+	ErrTooManyBackers             = apierr.New(apierr.CodeInvalidArgument, "Too many backers")
 )
 
+var lockWait = metrics.NewHistogram("backafriend_db_lock_wait_seconds",
+	"Time operations wait for exclusive access to storage.", metrics.DefaultBuckets)
+
+// MaxBackers bounds the number of backers of a single entry.
+const MaxBackers = 10
+
 const noActiveTournament = -1
 
 type Winner struct {
 	PlayerId string
 	Prize    int
+	Payouts  []Payout `json:",omitempty"`
+}
+
+// Payout is the share of a prize credited to the winner or one of its
+// backers.
+type Payout struct {
+	PlayerId string
+	Points   int
 }
 
 type Api interface {
 	Start() error
 	Stop() error
-	Take(playerId string, points int) error
-	Fund(playerId string, points int) error
-	AnnounceTournament(tourId int, deposit int) error
-	JoinTournament(tourId int, playerId string, backers []string) error
-	ResultTournament() (Winner, error)
-	Balance(playerId string) (int, error)
-	Reset() error
+	Take(ctx context.Context, playerId string, points int) error
+	Fund(ctx context.Context, playerId string, points int) error
+	AnnounceTournament(ctx context.Context, tourId int, deposit int) error
+	JoinTournament(ctx context.Context, tourId int, playerId string, backers []string) error
+	ResultTournament(ctx context.Context) (Winner, error)
+	ActiveTournament(ctx context.Context) (int, error)
+	Balance(ctx context.Context, playerId string) (int, error)
+	Reset(ctx context.Context) error
+	Backup(ctx context.Context, path string) error
+	Restore(ctx context.Context, path string) error
+	Export(ctx context.Context, w io.Writer, format string) error
+	Import(ctx context.Context, r io.Reader, format string) error
+	CloseSeason(ctx context.Context, rules CarryOver) (db.Season, error)
+	Leaderboard(ctx context.Context, seasonId int) ([]db.Standing, error)
+	SeasonBalance(ctx context.Context, seasonId int, playerId string) (int, error)
+	RequestBacking(ctx context.Context, tourId int, playerId string, backerId string) error
+	AcceptBacking(ctx context.Context, tourId int, playerId string, backerId string) error
+	AcceptedBackers(ctx context.Context, tourId int, playerId string) ([]string, error)
+	Stats(ctx context.Context) (Stats, error)
+	// Bus returns the bus the Api publishes its domain events to.
+	Bus() *Bus
 }
 
 type api_impl struct {
-	db                 *db.Db
+	db                 db.Storage
 	dbMux              sync.Mutex
 	activeTournamentId int
 	playersFunded      map[string][]string
 	joinedPlayers      []string
+	backingRequests    map[backingRequest]bool
+	bus                *Bus
+}
+
+// lock takes dbMux and records how long it had to wait for it.
+func (a *api_impl) lock() {
+	start := time.Now()
+	a.dbMux.Lock()
+	lockWait.Observe(time.Since(start).Seconds())
+}
+
+// store returns the storage bound to the request of ctx.
+func (a *api_impl) store(ctx context.Context) db.Storage {
+	return db.WithContext(a.db, ctx)
 }
 
 func (a *api_impl) Start() error {
-	a.playersFunded = make(map[string][]string)
+	return a.loadState()
+}
+
+// loadState rebuilds the bookkeeping of the running tournament from storage.
+func (a *api_impl) loadState() error {
 	a.activeTournamentId = noActiveTournament
+	a.playersFunded = make(map[string][]string)
+	a.joinedPlayers = nil
+	a.backingRequests = make(map[backingRequest]bool)
+
+	tournaments, err := a.db.Tournaments()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tournaments {
+		if !t.SettledAt.IsZero() {
+			continue
+		}
+
+		a.activeTournamentId = t.Id
+		for _, e := range t.Entries {
+			a.joinedPlayers = append(a.joinedPlayers, e.PlayerId)
+			if len(e.Backers) == 0 {
+				continue
+			}
+
+			f := []string{}
+			for _, b := range e.Backers {
+				f = append(f, b.BackerId)
+			}
+			a.playersFunded[e.PlayerId] = f
+		}
+	}
 	return nil
 }
 
@@ -50,8 +135,25 @@ func (a *api_impl) Stop() error {
 	return a.db.Stop()
 }
 
-func CreateApi(apiDb *db.Db) (Api, error) {
-	a := &api_impl{}
+func (a *api_impl) Bus() *Bus {
+	return a.bus
+}
+
+// notFound replaces db.ErrorNotFound with the error of the missing resource.
+func notFound(err error, missing error) error {
+	if err == db.ErrorNotFound {
+		return missing
+	}
+	return err
+}
+
+func CreateApi(apiDb db.Storage) (Api, error) {
+	return CreateApiWithBus(apiDb, NewBus())
+}
+
+// CreateApiWithBus creates an Api publishing its domain events to bus.
+func CreateApiWithBus(apiDb db.Storage, bus *Bus) (Api, error) {
+	a := &api_impl{bus: bus}
 	a.db = apiDb
 	if err := a.Start(); err != nil {
 		return nil, err
@@ -59,108 +161,154 @@ func CreateApi(apiDb *db.Db) (Api, error) {
 	return a, nil
 }
 
-func (a *api_impl) Take(playerId string, points int) error {
-	a.dbMux.Lock()
+func (a *api_impl) Take(ctx context.Context, playerId string, points int) error {
+	a.lock()
 	defer a.dbMux.Unlock()
+	store := a.store(ctx)
 
-	ballance, err := a.db.PlayerPoints(playerId)
+	ballance, err := store.PlayerPoints(playerId)
 	if err != nil {
-		return err
+		return notFound(err, ErrPlayerNotFound)
 	}
 	if ballance <= points {
 		return ErrInsufficientFunds
 	}
 
-	return a.db.UpdatePlayer(playerId, ballance-points)
+	if err := store.UpdatePlayer(playerId, ballance-points); err != nil {
+		return err
+	}
+	a.bus.Publish(PointsTaken{playerId, points, ballance - points, time.Now().UTC()})
+	return nil
 }
 
-func (a *api_impl) Fund(playerId string, points int) error {
-	a.dbMux.Lock()
+func (a *api_impl) Fund(ctx context.Context, playerId string, points int) error {
+	a.lock()
 	defer a.dbMux.Unlock()
+	store := a.store(ctx)
 
-	pts, err := a.db.PlayerPoints(playerId)
+	pts, err := store.PlayerPoints(playerId)
 	if err == nil {
 		//update
-		if err := a.db.UpdatePlayer(playerId, pts+points); err != nil {
+		if err := store.UpdatePlayer(playerId, pts+points); err != nil {
 			return err
 		}
-		return nil
 	} else if err == db.ErrorNotFound {
 		//add new
-		if err := a.db.CreatePlayer(playerId, points); err != nil {
+		if err := store.CreatePlayer(playerId, points); err != nil {
 			return err
 		}
-		return nil
+	} else {
+		return err
 	}
-	return err
+	a.bus.Publish(PlayerFunded{playerId, points, pts + points, time.Now().UTC()})
+	return nil
 }
 
-func (a *api_impl) AnnounceTournament(tourId int, deposit int) error {
-	a.dbMux.Lock()
+func (a *api_impl) AnnounceTournament(ctx context.Context, tourId int, deposit int) error {
+	a.lock()
 	defer a.dbMux.Unlock()
+	store := a.store(ctx)
 
 	if a.activeTournamentId != noActiveTournament {
 		return ErrTournamentAlreadyAnnounced
 	}
 
-	info, err := a.db.TournamentInfo(tourId)
+	info, err := store.TournamentInfo(tourId)
 	if err != nil && err != db.ErrorNotFound {
 		return err
 	}
 	if info != nil {
-		return db.ErrAlreadyExists
+		return ErrTournamentExists
 	}
 
-	if err := a.db.CreateTournament(tourId, deposit); err != nil {
-		return nil
+	if err := store.CreateTournament(tourId, deposit); err != nil {
+		return err
 	}
 	a.activeTournamentId = tourId
+	a.bus.Publish(TournamentAnnounced{tourId, deposit, time.Now().UTC()})
 	return nil
 }
 
-func (a *api_impl) JoinTournament(tourId int, playerId string, backers []string) error {
-	a.dbMux.Lock()
+// JoinTournament logs the outcome with the figures it was decided on, so a
+// refused entry can be explained.
+func (a *api_impl) JoinTournament(ctx context.Context, tourId int, playerId string, backers []string) error {
+	a.lock()
 	defer a.dbMux.Unlock()
 
-	info, err := a.db.TournamentInfo(tourId)
-	if err != nil {
+	trace := logging.Fields{"tournamentId": tourId, "playerId": playerId, "backers": backers}
+	if err := a.join(a.store(ctx), tourId, playerId, backers, trace); err != nil {
+		trace["reason"] = err.Error()
+		logging.Info(ctx, "join refused", trace)
 		return err
 	}
+	logging.Info(ctx, "joined tournament", trace)
+	return nil
+}
 
-	balance, err := a.db.PlayerPoints(playerId)
+// join adds the figures it decides on to trace.
+func (a *api_impl) join(store db.Storage, tourId int, playerId string, backers []string, trace logging.Fields) error {
+	if len(backers) > MaxBackers {
+		return ErrTooManyBackers
+	}
+
+	info, err := store.TournamentInfo(tourId)
 	if err != nil {
-		return err
+		return notFound(err, ErrTournamentNotFound)
 	}
+	trace["deposit"] = info.Deposit
+
+	balance, err := store.PlayerPoints(playerId)
+	if err != nil {
+		return notFound(err, ErrPlayerNotFound)
+	}
+	trace["balance"] = balance
 
 	if len(backers) == 0 && info.Deposit > balance {
 		return ErrInsufficientFunds
 	}
 
 	if balance >= info.Deposit && len(backers) == 0 {
-		if err := a.db.UpdatePlayer(playerId, balance-info.Deposit); err != nil {
-			return err
-		}
-		if err := a.db.JoinTournament(tourId, playerId); err != nil {
+		trace["stake"] = info.Deposit
+		err := store.Update(func(s db.Storage) error {
+			if err := s.UpdatePlayer(playerId, balance-info.Deposit); err != nil {
+				return err
+			}
+			return s.JoinTournament(tourId, db.Entry{
+				PlayerId: playerId,
+				JoinedAt: time.Now().UTC(),
+				Stake:    info.Deposit,
+			})
+		})
+		if err != nil {
 			return err
 		}
 		a.joinedPlayers = append(a.joinedPlayers, playerId)
+		a.bus.Publish(PlayerJoined{tourId, playerId, nil, info.Deposit, balance - info.Deposit, time.Now().UTC()})
 		return nil
 	}
 
 	requiredPts := info.Deposit / (len(backers) + 1) // backers + playerId
+	trace["stake"] = requiredPts
 	if balance < requiredPts {
 		return ErrInsufficientFunds
 	}
 
-	backersMap, err := a.db.MultiplePlayerPoints(backers)
+	backersMap, err := store.MultiplePlayerPoints(backers)
 	if err != nil {
 		return err
 	}
 
+	for _, b := range backers {
+		if _, ok := backersMap[b]; !ok {
+			return ErrPlayerNotFound
+		}
+	}
 	if len(backersMap) != len(backers) {
+		// a backer was named twice
 		return ErrInvalidQueryResult
 	}
 
+	trace["backerBalances"] = backersMap
 	for _, pts := range backersMap {
 		if pts <= requiredPts {
 			return ErrInsufficientFunds
@@ -168,89 +316,249 @@ func (a *api_impl) JoinTournament(tourId int, playerId string, backers []string)
 	}
 
 	f := []string{}
-	for b, pts := range backersMap {
-		if err := a.db.UpdatePlayer(b, pts-requiredPts); err != nil {
+	entry := db.Entry{
+		PlayerId: playerId,
+		JoinedAt: time.Now().UTC(),
+		Stake:    requiredPts,
+	}
+	err = store.Update(func(s db.Storage) error {
+		for b, pts := range backersMap {
+			if err := s.UpdatePlayer(b, pts-requiredPts); err != nil {
+				return err
+			}
+			f = append(f, b)
+			entry.Backers = append(entry.Backers, db.Backing{BackerId: b, Stake: requiredPts})
+		}
+		if err := s.UpdatePlayer(playerId, balance-requiredPts); err != nil {
 			return err
 		}
-		f = append(f, b)
-	}
-	a.playersFunded[playerId] = f
-	if err := a.db.UpdatePlayer(playerId, balance-requiredPts); err != nil {
+		return s.JoinTournament(tourId, entry)
+	})
+	if err != nil {
 		return err
 	}
+	a.playersFunded[playerId] = f
+	a.joinedPlayers = append(a.joinedPlayers, playerId)
 
-	if err := a.db.JoinTournament(tourId, playerId); err != nil {
-		return err
+	now := time.Now().UTC()
+	a.bus.Publish(PlayerJoined{tourId, playerId, backers, requiredPts, balance - requiredPts, now})
+	for _, b := range backers {
+		a.bus.Publish(BackingTaken{tourId, playerId, b, requiredPts, backersMap[b] - requiredPts, now})
 	}
-	a.joinedPlayers = append(a.joinedPlayers, playerId)
 	return nil
 }
 
-func (a *api_impl) ResultTournament() (Winner, error) {
-	return a.finishTournament()
+func (a *api_impl) ResultTournament(ctx context.Context) (Winner, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	return a.finishTournament(store)
 }
 
-func (a *api_impl) Balance(playerId string) (int, error) {
-	a.dbMux.Lock()
+// ActiveTournament returns the id of the running tournament or
+// ErrTournamentNotRunning.
+func (a *api_impl) ActiveTournament(ctx context.Context) (int, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+
+	if a.activeTournamentId == noActiveTournament {
+		return 0, ErrTournamentNotRunning
+	}
+	return a.activeTournamentId, nil
+}
+
+func (a *api_impl) Balance(ctx context.Context, playerId string) (int, error) {
+	a.lock()
 	defer a.dbMux.Unlock()
+	store := a.store(ctx)
 
-	return a.db.PlayerPoints(playerId)
+	pts, err := store.PlayerPoints(playerId)
+	return pts, notFound(err, ErrPlayerNotFound)
 }
 
-func (a *api_impl) finishTournament() (Winner, error) {
+func (a *api_impl) finishTournament(store db.Storage) (Winner, error) {
 	if len(a.joinedPlayers) == 0 {
 		return Winner{}, nil
 	}
 
-	score, err := a.db.MultiplePlayerPoints(a.joinedPlayers)
+	score, err := store.MultiplePlayerPoints(a.joinedPlayers)
 	if err != nil {
 		return Winner{}, nil
 	}
 
+	ranking := rankPlayers(score)
 	maxPts := 0
 	winnerId := ""
-	for id, pts := range score {
-		if pts > maxPts {
-			maxPts = pts
-			winnerId = id
-		}
+	if len(ranking) > 0 && score[ranking[0]] > 0 {
+		winnerId = ranking[0]
+		maxPts = score[winnerId]
 	}
 
-	info, err := a.db.TournamentInfo(a.activeTournamentId)
+	info, err := store.TournamentInfo(a.activeTournamentId)
 	if err != nil {
 		return Winner{}, err
 	}
 
 	totalPrize := info.Deposit * len(a.joinedPlayers)
 	sponsors, ok := a.playersFunded[winnerId]
-	if !ok {
-		// player payed it's own points for joining
-		if err := a.db.UpdatePlayer(winnerId, maxPts+totalPrize); err != nil {
-			return Winner{}, err
+	var payouts []Payout
+	balances := make(map[string]int)
+	settledAt := time.Now().UTC()
+	err = store.Update(func(s db.Storage) error {
+		for i, id := range ranking {
+			if err := s.SetEntryPosition(a.activeTournamentId, id, i+1); err != nil {
+				return err
+			}
 		}
-	} else {
+		if err := s.SettleTournament(a.activeTournamentId, settledAt); err != nil {
+			return err
+		}
+
+		if !ok {
+			// player payed it's own points for joining
+			payouts = append(payouts, Payout{winnerId, totalPrize})
+			balances[winnerId] = maxPts + totalPrize
+			return s.UpdatePlayer(winnerId, maxPts+totalPrize)
+		}
+
 		// give part of the prize to backers
 		prize := totalPrize / (len(sponsors) + 1)
-		if err := a.db.UpdatePlayer(winnerId, maxPts+prize); err != nil {
-			return Winner{}, err
+		if err := s.UpdatePlayer(winnerId, maxPts+prize); err != nil {
+			return err
 		}
+		payouts = append(payouts, Payout{winnerId, prize})
+		balances[winnerId] = maxPts + prize
 
-		sponsorsPts, err := a.db.MultiplePlayerPoints(sponsors)
+		sponsorsPts, err := s.MultiplePlayerPoints(sponsors)
 		if err != nil {
-			return Winner{}, nil
+			return err
 		}
 
 		for id, pts := range sponsorsPts {
-			if err := a.db.UpdatePlayer(id, pts+prize); err != nil {
-				return Winner{}, err
+			if err := s.UpdatePlayer(id, pts+prize); err != nil {
+				return err
 			}
+			payouts = append(payouts, Payout{id, prize})
+			balances[id] = pts + prize
 		}
+		return nil
+	})
+	if err != nil {
+		return Winner{}, err
 	}
 
+	tourId := a.activeTournamentId
 	a.activeTournamentId = noActiveTournament
-	return Winner{winnerId, totalPrize}, nil
+	a.playersFunded = make(map[string][]string)
+	a.joinedPlayers = nil
+	a.backingRequests = make(map[backingRequest]bool)
+
+	winner := Winner{winnerId, totalPrize, payouts}
+	a.bus.Publish(TournamentSettled{tourId, winner, ranking, balances, settledAt})
+	return winner, nil
+}
+
+type byScore struct {
+	ids   []string
+	score map[string]int
+}
+
+func (s byScore) Len() int      { return len(s.ids) }
+func (s byScore) Swap(i, j int) { s.ids[i], s.ids[j] = s.ids[j], s.ids[i] }
+func (s byScore) Less(i, j int) bool {
+	pi, pj := s.score[s.ids[i]], s.score[s.ids[j]]
+	if pi != pj {
+		return pi > pj
+	}
+	return s.ids[i] < s.ids[j]
 }
 
-func (a *api_impl) Reset() error {
-	return a.db.Reset()
+// rankPlayers orders players by points, highest first, which is their
+// finishing position in the tournament.
+func rankPlayers(score map[string]int) []string {
+	ids := make([]string, 0, len(score))
+	for id := range score {
+		ids = append(ids, id)
+	}
+	sort.Sort(byScore{ids, score})
+	return ids
+}
+
+func (a *api_impl) Reset(ctx context.Context) error {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	if err := store.Reset(); err != nil {
+		return err
+	}
+	if err := a.loadState(); err != nil {
+		return err
+	}
+	a.bus.Publish(DataReset{time.Now().UTC()})
+	return nil
+}
+
+// Backup holds dbMux while the snapshot is written so that it never sees a
+// half-applied operation of this process.
+func (a *api_impl) Backup(ctx context.Context, path string) error {
+	b, ok := a.db.(db.Backuper)
+	if !ok {
+		return db.ErrBackupNotSupported
+	}
+
+	a.lock()
+	defer a.dbMux.Unlock()
+
+	return b.Backup(path)
+}
+
+func (a *api_impl) Restore(ctx context.Context, path string) error {
+	b, ok := a.db.(db.Backuper)
+	if !ok {
+		return db.ErrBackupNotSupported
+	}
+
+	a.lock()
+	defer a.dbMux.Unlock()
+
+	if err := b.Restore(path); err != nil {
+		return err
+	}
+	if err := a.loadState(); err != nil {
+		return err
+	}
+	a.bus.Publish(BackupRestored{path, time.Now().UTC()})
+	return nil
+}
+
+func (a *api_impl) Export(ctx context.Context, w io.Writer, format string) error {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	return db.Export(store, w, format)
+}
+
+// Import loads an export into storage. An imported running tournament
+// becomes the active one.
+func (a *api_impl) Import(ctx context.Context, r io.Reader, format string) error {
+	records, err := db.ReadRecords(r, format)
+	if err != nil {
+		return err
+	}
+
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	if err := db.ImportRecords(store, records); err != nil {
+		return err
+	}
+	if err := a.loadState(); err != nil {
+		return err
+	}
+	a.bus.Publish(RecordsImported{format, time.Now().UTC()})
+	return nil
 }
@@ -1,52 +1,24 @@
 package api
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
-	"os"
-	"path"
 	"testing"
+	"time"
 
 	"api/db"
 )
 
-func setupApi() (_ Api, _ func(), rerr error) {
-	mydb := &db.Db{}
+var ctx = context.Background()
 
-	tmpDir, err := ioutil.TempDir("", "dbTest")
-	if err != nil {
-		return nil, func() {}, err
-	}
-	defer func() {
-		if rerr != nil {
-			os.Remove(tmpDir)
-		}
-	}()
-
-	dbDir := path.Join(tmpDir, "db")
-	if err := os.MkdirAll(dbDir, 0777); err != nil {
-		return nil, func() {}, err
-	}
-
-	dbPath := path.Join(dbDir, "testdb.db")
-	if err := mydb.Create(dbPath); err != nil {
-		return nil, func() {}, err
-	}
-
-	defer func() {
-		if rerr != nil {
-			mydb.Stop()
-		}
-	}()
-
-	a, err := CreateApi(mydb)
+func setupApi() (Api, func(), error) {
+	a, err := CreateApi(db.CreateMemDb())
 	if err != nil {
 		return nil, func() {}, err
 	}
 
 	return a, func() {
 		a.Stop()
-		os.Remove(tmpDir)
 	}, nil
 }
 
@@ -63,21 +35,21 @@ func TestApi_Fund(t *testing.T) {
 	players["Bob"] = 500
 
 	for player, pts := range players {
-		if err := a.Fund(player, pts); err != nil {
+		if err := a.Fund(ctx, player, pts); err != nil {
 			t.Fatal(err)
 		}
 	}
 
-	if err := a.Fund("Joe", 400); err != nil {
+	if err := a.Fund(ctx, "Joe", 400); err != nil {
 		t.Fatal(err)
 	}
 
-	joePts, err := a.Balance("Joe")
+	joePts, err := a.Balance(ctx, "Joe")
 	if err != nil {
 		t.Error(err)
 	}
 
-	bobPts, err := a.Balance("Joe")
+	bobPts, err := a.Balance(ctx, "Joe")
 	if err != nil {
 		t.Error(err)
 	}
@@ -95,11 +67,11 @@ func TestApi_AnounceTournament(t *testing.T) {
 	defer closer()
 
 	tourId := 42
-	if err := a.AnnounceTournament(tourId, 1000); err != nil {
+	if err := a.AnnounceTournament(ctx, tourId, 1000); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := a.AnnounceTournament(tourId, 1000); err != ErrTournamentAlreadyAnnounced {
+	if err := a.AnnounceTournament(ctx, tourId, 1000); err != ErrTournamentAlreadyAnnounced {
 		t.Error(errors.New("Created tournament duplicate"))
 	}
 }
@@ -117,13 +89,13 @@ func TestApi_JoinTournamentInsufficientFunds(t *testing.T) {
 		}
 		defer closer()
 
-		if err := a.Fund(playerId, 300); err != nil {
+		if err := a.Fund(ctx, playerId, 300); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.AnnounceTournament(tourId, 1000); err != nil {
+		if err := a.AnnounceTournament(ctx, tourId, 1000); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.JoinTournament(tourId, playerId, []string{}); err != ErrInsufficientFunds {
+		if err := a.JoinTournament(ctx, tourId, playerId, []string{}); err != ErrInsufficientFunds {
 			t.Error(err)
 		}
 	})
@@ -135,24 +107,24 @@ func TestApi_JoinTournamentInsufficientFunds(t *testing.T) {
 		}
 		defer closer()
 
-		if err := a.Fund("P1", 10); err != nil {
+		if err := a.Fund(ctx, "P1", 10); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P2", 300); err != nil {
+		if err := a.Fund(ctx, "P2", 300); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P3", 300); err != nil {
+		if err := a.Fund(ctx, "P3", 300); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P4", 500); err != nil {
+		if err := a.Fund(ctx, "P4", 500); err != nil {
 			t.Fatal(err)
 		}
 
-		if err := a.AnnounceTournament(tourId, 1000); err != nil {
+		if err := a.AnnounceTournament(ctx, tourId, 1000); err != nil {
 			t.Fatal(err)
 		}
 
-		if err := a.JoinTournament(tourId, "P1", []string{"P2", "P3", "P4"}); err != ErrInsufficientFunds {
+		if err := a.JoinTournament(ctx, tourId, "P1", []string{"P2", "P3", "P4"}); err != ErrInsufficientFunds {
 			t.Fatal("P1 should have no sufficient funds")
 		}
 	})
@@ -164,24 +136,24 @@ func TestApi_JoinTournamentInsufficientFunds(t *testing.T) {
 		}
 		defer closer()
 
-		if err := a.Fund("P1", 300); err != nil {
+		if err := a.Fund(ctx, "P1", 300); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P2", 30); err != nil {
+		if err := a.Fund(ctx, "P2", 30); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P3", 300); err != nil {
+		if err := a.Fund(ctx, "P3", 300); err != nil {
 			t.Fatal(err)
 		}
-		if err := a.Fund("P4", 500); err != nil {
+		if err := a.Fund(ctx, "P4", 500); err != nil {
 			t.Fatal(err)
 		}
 
-		if err := a.AnnounceTournament(tourId, 1000); err != nil {
+		if err := a.AnnounceTournament(ctx, tourId, 1000); err != nil {
 			t.Fatal(err)
 		}
 
-		if err := a.JoinTournament(tourId, "P1", []string{"P2", "P3", "P4"}); err != ErrInsufficientFunds {
+		if err := a.JoinTournament(ctx, tourId, "P1", []string{"P2", "P3", "P4"}); err != ErrInsufficientFunds {
 			t.Fatal("P2 should have no sufficient funds")
 		}
 	})
@@ -194,56 +166,56 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 	}
 	defer closer()
 
-	if err := a.Fund("P1", 300); err != nil {
+	if err := a.Fund(ctx, "P1", 300); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.Fund("P2", 300); err != nil {
+	if err := a.Fund(ctx, "P2", 300); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.Fund("P3", 300); err != nil {
+	if err := a.Fund(ctx, "P3", 300); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.Fund("P4", 500); err != nil {
+	if err := a.Fund(ctx, "P4", 500); err != nil {
 		t.Fatal(err)
 	}
-	if err := a.Fund("P5", 1000); err != nil {
+	if err := a.Fund(ctx, "P5", 1000); err != nil {
 		t.Fatal(err)
 	}
 
 	const tourId = 1
-	if err := a.AnnounceTournament(tourId, 1000); err != nil {
+	if err := a.AnnounceTournament(ctx, tourId, 1000); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := a.JoinTournament(tourId, "P5", []string{}); err != nil {
+	if err := a.JoinTournament(ctx, tourId, "P5", []string{}); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := a.JoinTournament(tourId, "P1", []string{"P2", "P3", "P4"}); err != nil {
+	if err := a.JoinTournament(ctx, tourId, "P1", []string{"P2", "P3", "P4"}); err != nil {
 		t.Fatal(err)
 	}
 
-	b1, err := a.Balance("P1")
+	b1, err := a.Balance(ctx, "P1")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b2, err := a.Balance("P2")
+	b2, err := a.Balance(ctx, "P2")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b3, err := a.Balance("P3")
+	b3, err := a.Balance(ctx, "P3")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b4, err := a.Balance("P4")
+	b4, err := a.Balance(ctx, "P4")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b5, err := a.Balance("P5")
+	b5, err := a.Balance(ctx, "P5")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -268,7 +240,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("wrong ballance", b5)
 	}
 
-	w, err := a.ResultTournament()
+	w, err := a.ResultTournament(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -279,7 +251,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("Wrong prize", w.Prize)
 	}
 
-	b1, err = a.Balance("P1")
+	b1, err = a.Balance(ctx, "P1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -287,7 +259,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("wrong ballance", b1)
 	}
 
-	b2, err = a.Balance("P2")
+	b2, err = a.Balance(ctx, "P2")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -295,7 +267,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("wrong ballance", b2)
 	}
 
-	b3, err = a.Balance("P3")
+	b3, err = a.Balance(ctx, "P3")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -303,7 +275,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("wrong ballance", b3)
 	}
 
-	b4, err = a.Balance("P4")
+	b4, err = a.Balance(ctx, "P4")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -311,7 +283,7 @@ func TestApi_TournamentFullLifecycle(t *testing.T) {
 		t.Error("wrong ballance", b4)
 	}
 
-	b5, err = a.Balance("P5")
+	b5, err = a.Balance(ctx, "P5")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -327,19 +299,19 @@ func TestApi_Take(t *testing.T) {
 	}
 	defer closer()
 
-	if err := a.Fund("P1", 500); err != nil {
+	if err := a.Fund(ctx, "P1", 500); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := a.Take("P1", 1000); err != ErrInsufficientFunds {
+	if err := a.Take(ctx, "P1", 1000); err != ErrInsufficientFunds {
 		t.Fatal("Player has too many points")
 	}
 
-	if err := a.Take("P1", 300); err != nil {
+	if err := a.Take(ctx, "P1", 300); err != nil {
 		t.Fatal(err)
 	}
 
-	b, err := a.Balance("P1")
+	b, err := a.Balance(ctx, "P1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -347,3 +319,320 @@ func TestApi_Take(t *testing.T) {
 		t.Error("Wrong player ballance ", b)
 	}
 }
+
+func TestApi_StartResumesRunningTournament(t *testing.T) {
+	storage := db.CreateMemDb()
+	a, err := CreateApi(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Fund(ctx, "P1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fund(ctx, "P2", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AnnounceTournament(ctx, 1, 400); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P1", []string{"P2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new instance over the same storage picks the tournament up
+	a, err = CreateApi(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AnnounceTournament(ctx, 2, 400); err != ErrTournamentAlreadyAnnounced {
+		t.Error(err)
+	}
+
+	w, err := a.ResultTournament(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.PlayerId != "P1" || w.Prize != 400 {
+		t.Error(w)
+	}
+	if len(w.Payouts) != 2 || w.Payouts[0] != (Payout{"P1", 200}) || w.Payouts[1] != (Payout{"P2", 200}) {
+		t.Error("wrong payouts", w.Payouts)
+	}
+
+	// backer got its share
+	if b, err := a.Balance(ctx, "P2"); err != nil || b != 500 {
+		t.Error("wrong ballance", b, err)
+	}
+
+	if err := a.AnnounceTournament(ctx, 2, 400); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApi_BackupNotSupported(t *testing.T) {
+	a, closer, err := setupApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := a.Backup(ctx, "backup.db"); err != db.ErrBackupNotSupported {
+		t.Error(err)
+	}
+}
+
+func TestApi_CloseSeason(t *testing.T) {
+	a, closer, err := setupApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := a.Fund(ctx, "P1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fund(ctx, "P2", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AnnounceTournament(ctx, 1, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P1", []string{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P2", []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.CloseSeason(ctx, CarryOver{Percent: 50}); err != ErrTournamentRunning {
+		t.Error(err)
+	}
+	if _, err := a.ResultTournament(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// P1 wins on the id tie break: 700 against 300
+	live, err := a.Leaderboard(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 2 || live[0].PlayerId != "P1" || live[0].Points != 700 || live[0].Won != 1 || live[1].Played != 1 {
+		t.Error(live)
+	}
+
+	season, err := a.CloseSeason(ctx, CarryOver{Percent: 50, Max: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if season.Id != 2 {
+		t.Error(season)
+	}
+
+	if b, err := a.Balance(ctx, "P1"); err != nil || b != 200 {
+		t.Error("wrong carry over", b, err)
+	}
+	if b, err := a.Balance(ctx, "P2"); err != nil || b != 150 {
+		t.Error("wrong carry over", b, err)
+	}
+	if b, err := a.SeasonBalance(ctx, 1, "P1"); err != nil || b != 700 {
+		t.Error("wrong archived ballance", b, err)
+	}
+
+	archived, err := a.Leaderboard(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 2 || archived[0] != live[0] || archived[1] != live[1] {
+		t.Error(archived)
+	}
+
+	current, err := a.Leaderboard(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 2 || current[0].Played != 0 || current[0].Points != 200 {
+		t.Error(current)
+	}
+}
+
+func TestApi_BackingRequests(t *testing.T) {
+	a, closer, err := setupApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := a.Fund(ctx, "P1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fund(ctx, "P2", 500); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.RequestBacking(ctx, 1, "P1", "P2"); err != ErrTournamentNotRunning {
+		t.Error(err)
+	}
+	if err := a.AnnounceTournament(ctx, 1, 400); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.RequestBacking(ctx, 1, "P1", "P3"); err != ErrPlayerNotFound {
+		t.Error("unknown backer", err)
+	}
+	if err := a.AcceptBacking(ctx, 1, "P1", "P2"); err != ErrBackingRequestNotFound {
+		t.Error("accepted without request", err)
+	}
+	if err := a.RequestBacking(ctx, 1, "P1", "P2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RequestBacking(ctx, 1, "P1", "P2"); err != db.ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if accepted, err := a.AcceptedBackers(ctx, 1, "P1"); err != nil || len(accepted) != 0 {
+		t.Error(accepted, err)
+	}
+	if err := a.AcceptBacking(ctx, 1, "P1", "P2"); err != nil {
+		t.Fatal(err)
+	}
+	if accepted, err := a.AcceptedBackers(ctx, 1, "P1"); err != nil || len(accepted) != 1 || accepted[0] != "P2" {
+		t.Error(accepted, err)
+	}
+
+	if err := a.JoinTournament(ctx, 1, "P1", []string{"P2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ResultTournament(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AcceptedBackers(ctx, 1, "P1"); err != ErrTournamentNotRunning {
+		t.Error(err)
+	}
+}
+
+func TestApi_Stats(t *testing.T) {
+	a, closer, err := setupApi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		if err := a.Fund(ctx, p, 500); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.AnnounceTournament(ctx, 1, 400); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P1", []string{"P2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P3", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// stakes still count as points in circulation
+	want := Stats{ActiveTournaments: 1, Points: 1500, BackedEntries: 1, SelfFundedEntries: 1}
+	if stats, err := a.Stats(ctx); err != nil || stats != want {
+		t.Error(stats, err)
+	}
+
+	if _, err := a.ResultTournament(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := a.Stats(ctx)
+	if err != nil || stats.ActiveTournaments != 0 || stats.BackedEntries != 1 || stats.SelfFundedEntries != 1 {
+		t.Error(stats, err)
+	}
+}
+
+func TestApi_DomainEvents(t *testing.T) {
+	bus := NewBus()
+	var events []Event
+	bus.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+	a, err := CreateApiWithBus(db.CreateMemDb(), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Fund(ctx, "P1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fund(ctx, "P2", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AnnounceTournament(ctx, 1, 400); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.JoinTournament(ctx, 1, "P1", []string{"P2"}); err != nil {
+		t.Fatal(err)
+	}
+	// failed calls publish nothing
+	if err := a.Take(ctx, "P1", 1000); err != ErrInsufficientFunds {
+		t.Fatal(err)
+	}
+	if _, err := a.ResultTournament(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, e := range events {
+		names = append(names, e.Name())
+	}
+	want := []string{"player_funded", "player_funded", "tournament_announced", "player_joined", "backing_taken", "tournament_settled"}
+	if len(names) != len(want) {
+		t.Fatal(names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatal(names)
+		}
+	}
+
+	if e := events[1].(PlayerFunded); e.PlayerId != "P2" || e.Points != 500 || e.Balance != 500 {
+		t.Error(e)
+	}
+	if e := events[3].(PlayerJoined); e.Stake != 200 || e.Balance != 300 || len(e.Backers) != 1 {
+		t.Error(e)
+	}
+	if e := events[4].(BackingTaken); e.BackerId != "P2" || e.Stake != 200 || e.Balance != 300 {
+		t.Error(e)
+	}
+	e := events[5].(TournamentSettled)
+	if e.TournamentId != 1 || e.Winner.PlayerId != "P1" || e.Balances["P1"] != 500 || e.Balances["P2"] != 500 {
+		t.Error(e)
+	}
+}
+
+func TestBus_Async(t *testing.T) {
+	bus := NewBus()
+	got := make(chan Event, 10)
+	cancel := bus.SubscribeAsync(func(e Event) {
+		got <- e
+	})
+
+	bus.Publish(DataReset{})
+	bus.Publish(BackupRestored{Path: "b1"})
+	for _, want := range []string{"data_reset", "backup_restored"} {
+		select {
+		case e := <-got:
+			if e.Name() != want {
+				t.Error(e.Name(), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+	}
+
+	cancel()
+	bus.Publish(DataReset{})
+	select {
+	case e := <-got:
+		t.Error("event after cancel", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
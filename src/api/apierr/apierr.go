@@ -0,0 +1,107 @@
+// Package apierr defines the errors the service reports to clients. Every
+// error carries a stable, machine-readable Code that maps to an HTTP status;
+// clients should branch on the code, not on the message.
+package apierr
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type Code string
+
+const (
+	CodeInvalidArgument         Code = "invalid_argument"
+	CodeUnauthenticated         Code = "unauthenticated"
+	CodeForbidden               Code = "forbidden"
+	CodeNotFound                Code = "not_found"
+	CodePlayerNotFound          Code = "player_not_found"
+	CodeTournamentNotFound      Code = "tournament_not_found"
+	CodeSeasonNotFound          Code = "season_not_found"
+	CodeBackingRequestNotFound  Code = "backing_request_not_found"
+	CodeMethodNotAllowed        Code = "method_not_allowed"
+	CodeBodyTooLarge            Code = "body_too_large"
+	CodeAlreadyExists           Code = "already_exists"
+	CodeTournamentAlreadyActive Code = "tournament_already_announced"
+	CodeTournamentNotRunning    Code = "tournament_not_running"
+	CodeTournamentRunning       Code = "tournament_running"
+	CodeInsufficientFunds       Code = "insufficient_funds"
+	CodeRateLimited             Code = "rate_limited"
+	CodeRequestInProgress       Code = "request_in_progress"
+	CodeInternal                Code = "internal"
+	CodeNotImplemented          Code = "not_implemented"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidArgument:         http.StatusBadRequest,
+	CodeUnauthenticated:         http.StatusUnauthorized,
+	CodeForbidden:               http.StatusForbidden,
+	CodeNotFound:                http.StatusNotFound,
+	CodePlayerNotFound:          http.StatusNotFound,
+	CodeTournamentNotFound:      http.StatusNotFound,
+	CodeSeasonNotFound:          http.StatusNotFound,
+	CodeBackingRequestNotFound:  http.StatusNotFound,
+	CodeMethodNotAllowed:        http.StatusMethodNotAllowed,
+	CodeBodyTooLarge:            http.StatusRequestEntityTooLarge,
+	CodeAlreadyExists:           http.StatusConflict,
+	CodeTournamentAlreadyActive: http.StatusConflict,
+	CodeTournamentNotRunning:    http.StatusConflict,
+	CodeTournamentRunning:       http.StatusConflict,
+	CodeInsufficientFunds:       http.StatusUnprocessableEntity,
+	CodeRateLimited:             http.StatusTooManyRequests,
+	CodeRequestInProgress:       http.StatusConflict,
+	CodeInternal:                http.StatusInternalServerError,
+	CodeNotImplemented:          http.StatusNotImplemented,
+}
+
+// Status returns the HTTP status of code.
+func (c Code) Status() int {
+	if s, ok := statuses[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code. Errors are compared by identity, so the
+// package level values of api and db can still be checked with ==.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error // cause, if any
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap gives err a code, keeping its message.
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// Invalid returns an invalid_argument error.
+func Invalid(format string, args ...interface{}) *Error {
+	return New(CodeInvalidArgument, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) ErrorCode() Code {
+	return e.Code
+}
+
+// coder is implemented by errors that know their code without being an
+// *Error, such as db.ImportError.
+type coder interface {
+	ErrorCode() Code
+}
+
+// CodeOf returns the code of err. Errors without one are internal.
+func CodeOf(err error) Code {
+	if c, ok := err.(coder); ok {
+		return c.ErrorCode()
+	}
+	return CodeInternal
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"api/apierr"
+	"api/db"
+)
+
+var (
+	ErrTournamentNotRunning   = apierr.New(apierr.CodeTournamentNotRunning, "Tournament is not running")
+	ErrBackingRequestNotFound = apierr.New(apierr.CodeBackingRequestNotFound, "Backing request not found")
+)
+
+// backingRequest asks backerId to fund part of playerId's entry into the
+// running tournament. Requests live in memory and are dropped when the
+// tournament finishes or the server restarts.
+type backingRequest struct {
+	playerId string
+	backerId string
+}
+
+func (a *api_impl) checkRunning(tourId int) error {
+	if a.activeTournamentId == noActiveTournament || a.activeTournamentId != tourId {
+		return ErrTournamentNotRunning
+	}
+	return nil
+}
+
+// RequestBacking records that playerId wants backerId to back its entry.
+func (a *api_impl) RequestBacking(ctx context.Context, tourId int, playerId string, backerId string) error {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	if err := a.checkRunning(tourId); err != nil {
+		return err
+	}
+	if _, err := store.PlayerPoints(backerId); err != nil {
+		return notFound(err, ErrPlayerNotFound)
+	}
+
+	r := backingRequest{playerId, backerId}
+	if _, ok := a.backingRequests[r]; ok {
+		return db.ErrAlreadyExists
+	}
+	a.backingRequests[r] = false
+	a.bus.Publish(BackingRequested{tourId, playerId, backerId, time.Now().UTC()})
+	return nil
+}
+
+// AcceptBacking lets backerId agree to a request of playerId.
+func (a *api_impl) AcceptBacking(ctx context.Context, tourId int, playerId string, backerId string) error {
+	a.lock()
+	defer a.dbMux.Unlock()
+
+	if err := a.checkRunning(tourId); err != nil {
+		return err
+	}
+
+	r := backingRequest{playerId, backerId}
+	if _, ok := a.backingRequests[r]; !ok {
+		return ErrBackingRequestNotFound
+	}
+	a.backingRequests[r] = true
+	a.bus.Publish(BackingAccepted{tourId, playerId, backerId, time.Now().UTC()})
+	return nil
+}
+
+// AcceptedBackers lists the backers that accepted to back playerId.
+func (a *api_impl) AcceptedBackers(ctx context.Context, tourId int, playerId string) ([]string, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+
+	if err := a.checkRunning(tourId); err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for r, accepted := range a.backingRequests {
+		if accepted && r.playerId == playerId {
+			res = append(res, r.backerId)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
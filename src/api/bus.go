@@ -0,0 +1,236 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"api/db"
+)
+
+// Event is a domain event. The Api publishes one after every change it
+// committed; subscribers tell them apart with a type switch.
+type Event interface {
+	// Name is the type of the event in snake case, e.g. "player_funded".
+	Name() string
+}
+
+// PlayerFunded is published by Fund. Balance is the balance afterwards.
+type PlayerFunded struct {
+	PlayerId string
+	Points   int
+	Balance  int
+	At       time.Time
+}
+
+// PointsTaken is published by Take.
+type PointsTaken struct {
+	PlayerId string
+	Points   int
+	Balance  int
+	At       time.Time
+}
+
+type TournamentAnnounced struct {
+	TournamentId int
+	Deposit      int
+	At           time.Time
+}
+
+// PlayerJoined is published when a player entered a tournament, paying
+// Stake. A backed entry is followed by a BackingTaken for every backer.
+type PlayerJoined struct {
+	TournamentId int
+	PlayerId     string
+	Backers      []string
+	Stake        int
+	Balance      int
+	At           time.Time
+}
+
+// BackingTaken is published for every backer of an entry, which paid
+// Stake of it.
+type BackingTaken struct {
+	TournamentId int
+	PlayerId     string
+	BackerId     string
+	Stake        int
+	Balance      int // of the backer
+	At           time.Time
+}
+
+type BackingRequested struct {
+	TournamentId int
+	PlayerId     string
+	BackerId     string
+	At           time.Time
+}
+
+type BackingAccepted struct {
+	TournamentId int
+	PlayerId     string
+	BackerId     string
+	At           time.Time
+}
+
+// TournamentSettled is published when a tournament was settled. Ranking
+// lists the players by finishing position; Balances holds the balances of
+// the payees afterwards.
+type TournamentSettled struct {
+	TournamentId int
+	Winner       Winner
+	Ranking      []string
+	Balances     map[string]int
+	At           time.Time
+}
+
+// SeasonClosed is published by CloseSeason with the closed season and
+// the one that started.
+type SeasonClosed struct {
+	Closed db.Season
+	Next   db.Season
+	Rules  CarryOver
+	At     time.Time
+}
+
+// DataReset is published by Reset.
+type DataReset struct {
+	At time.Time
+}
+
+// BackupRestored is published by Restore.
+type BackupRestored struct {
+	Path string
+	At   time.Time
+}
+
+// RecordsImported is published by Import.
+type RecordsImported struct {
+	Format string
+	At     time.Time
+}
+
+func (PlayerFunded) Name() string        { return "player_funded" }
+func (PointsTaken) Name() string         { return "points_taken" }
+func (TournamentAnnounced) Name() string { return "tournament_announced" }
+func (PlayerJoined) Name() string        { return "player_joined" }
+func (BackingTaken) Name() string        { return "backing_taken" }
+func (BackingRequested) Name() string    { return "backing_requested" }
+func (BackingAccepted) Name() string     { return "backing_accepted" }
+func (TournamentSettled) Name() string   { return "tournament_settled" }
+func (SeasonClosed) Name() string        { return "season_closed" }
+func (DataReset) Name() string           { return "data_reset" }
+func (BackupRestored) Name() string      { return "backup_restored" }
+func (RecordsImported) Name() string     { return "records_imported" }
+
+// Bus delivers domain events to subscribers.
+//
+// Synchronous subscribers are called in commit order while the Api still
+// holds its lock, so they see every event exactly in the order the
+// changes were made; they must be quick and must not call the Api.
+// Asynchronous subscribers get the events in the same order on a
+// goroutine of their own and may call the Api; their queue is not
+// bounded, publishing never waits for them.
+type Bus struct {
+	mux   sync.RWMutex
+	sync  map[int]func(Event)
+	async map[int]*asyncSubscriber
+	next  int
+}
+
+func NewBus() *Bus {
+	return &Bus{sync: make(map[int]func(Event)), async: make(map[int]*asyncSubscriber)}
+}
+
+// Subscribe adds a synchronous subscriber and returns a function removing
+// it.
+func (b *Bus) Subscribe(fn func(Event)) func() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	id := b.next
+	b.next++
+	b.sync[id] = fn
+	return func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		delete(b.sync, id)
+	}
+}
+
+// SubscribeAsync adds an asynchronous subscriber and returns a function
+// removing it. Events still queued when it is removed are dropped.
+func (b *Bus) SubscribeAsync(fn func(Event)) func() {
+	s := &asyncSubscriber{fn: fn, ready: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.run()
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	id := b.next
+	b.next++
+	b.async[id] = s
+	return func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		if _, ok := b.async[id]; ok {
+			delete(b.async, id)
+			close(s.done)
+		}
+	}
+}
+
+// Publish hands e to every subscriber.
+func (b *Bus) Publish(e Event) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	for _, fn := range b.sync {
+		fn(e)
+	}
+	for _, s := range b.async {
+		s.push(e)
+	}
+}
+
+type asyncSubscriber struct {
+	fn    func(Event)
+	mux   sync.Mutex
+	queue []Event
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func (s *asyncSubscriber) push(e Event) {
+	s.mux.Lock()
+	s.queue = append(s.queue, e)
+	s.mux.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *asyncSubscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+
+		s.mux.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mux.Unlock()
+
+		for _, e := range queue {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.fn(e)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"time"
+)
+
+const (
+	insertApiKeyQuery  = "insert into ApiKeys (KeyId, Hash, Role, CreatedAt) values (?, ?, ?, ?)"
+	selectApiKeyQuery  = "select KeyId, Hash, Role, CreatedAt, RevokedAt from ApiKeys where KeyId=?"
+	selectApiKeysQuery = "select KeyId, Hash, Role, CreatedAt, RevokedAt from ApiKeys order by CreatedAt, KeyId"
+	revokeApiKeyQuery  = "update ApiKeys set RevokedAt=? where KeyId=? and RevokedAt is null"
+)
+
+// ApiKey is an issued API key. Only a hash of the secret part is stored,
+// the key itself is shown once when it is issued.
+type ApiKey struct {
+	Id        string
+	Hash      string
+	Role      string
+	CreatedAt time.Time
+	RevokedAt time.Time // zero while the key is valid
+}
+
+func scanApiKey(scan func(dest ...interface{}) error) (*ApiKey, error) {
+	var k ApiKey
+	var revokedAt *time.Time
+	if err := scan(&k.Id, &k.Hash, &k.Role, &k.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.RevokedAt = settledTime(revokedAt)
+	return &k, nil
+}
+
+func (d *Db) CreateApiKey(key ApiKey) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectApiKeyQuery, key.Id)
+	if err != nil {
+		return err
+	}
+	found := rows.Next()
+	rows.Close()
+	if found {
+		return ErrAlreadyExists
+	}
+
+	if _, err := tx.Exec(insertApiKeyQuery, key.Id, key.Hash, key.Role, key.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Db) ApiKey(id string) (_ *ApiKey, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectApiKeyQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorNotFound
+	}
+	k, err := scanApiKey(rows.Scan)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return k, tx.Commit()
+}
+
+func (d *Db) ApiKeys() (_ []*ApiKey, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectApiKeysQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*ApiKey{}
+	for rows.Next() {
+		k, err := scanApiKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
+
+// RevokeApiKey invalidates a key. Revoking a revoked key keeps its original
+// revocation time.
+func (d *Db) RevokeApiKey(id string, revokedAt time.Time) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(revokeApiKeyQuery, revokedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		rows, err := tx.Query(selectApiKeyQuery, id)
+		if err != nil {
+			return err
+		}
+		found := rows.Next()
+		rows.Close()
+		if !found {
+			return ErrorNotFound
+		}
+	}
+
+	return tx.Commit()
+}
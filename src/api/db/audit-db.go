@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"api/apierr"
+)
+
+const (
+	insertAuditEntryQuery   = "insert into AuditLog (Seq, At, Role, KeyId, PlayerId, Addr, Method, Endpoint, Query, Body, Status, Outcome, PrevHash, Hash) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	selectAuditColumns      = "select Seq, At, Role, KeyId, PlayerId, Addr, Method, Endpoint, Query, Body, Status, Outcome, PrevHash, Hash from AuditLog"
+	selectLastAuditQuery    = selectAuditColumns + " order by Seq desc limit 1"
+	selectAuditEntriesQuery = selectAuditColumns + " where Seq>? and (?='' or KeyId=?) and (?='' or PlayerId=?) and (?='' or Endpoint=?) order by Seq limit ?"
+	selectMaxAuditSeqQuery  = "select coalesce(max(Seq), 0) from AuditLog"
+	auditTableExistsQuery   = "select count(*) from sqlite_master where type='table' and name='AuditLog'"
+)
+
+var ErrAuditMismatch = apierr.New(apierr.CodeInvalidArgument, "The audit log of the backup is not part of the current one")
+
+// AuditEntry is one recorded call of the API. Entries are numbered from 1
+// without gaps; Hash covers the entry and PrevHash, the hash of the entry
+// before it, so changing or dropping an entry breaks the chain.
+type AuditEntry struct {
+	Seq      int64
+	At       time.Time
+	Role     string // of the caller
+	KeyId    string // of the API key the caller used
+	PlayerId string // the player token was issued for
+	Addr     string // remote address of the caller
+	Method   string // HTTP method, or "GRPC"
+	Endpoint string // path, or full gRPC method
+	Query    string
+	Body     string // the request body, truncated
+	Status   int    // HTTP status of the response
+	Outcome  string // "ok" or the error code
+	PrevHash string
+	Hash     string
+}
+
+// AuditQuery selects audit entries. Empty fields match all; Limit <= 0
+// means no limit.
+type AuditQuery struct {
+	After    int64 // only entries with a greater Seq
+	Limit    int
+	KeyId    string
+	PlayerId string
+	Endpoint string
+}
+
+// Matches reports whether e is selected by q, ignoring After and Limit.
+func (q AuditQuery) Matches(e *AuditEntry) bool {
+	return (q.KeyId == "" || e.KeyId == q.KeyId) &&
+		(q.PlayerId == "" || e.PlayerId == q.PlayerId) &&
+		(q.Endpoint == "" || e.Endpoint == q.Endpoint)
+}
+
+// MissingAuditEntries returns the entries of live that backup, the audit
+// log of a backup, lacks. Restores append them again, so a restore never
+// drops recorded calls. backup has to be the start of live, otherwise the
+// error is ErrAuditMismatch.
+func MissingAuditEntries(live, backup []*AuditEntry) ([]*AuditEntry, error) {
+	if len(backup) > len(live) {
+		return nil, ErrAuditMismatch
+	}
+	for i, e := range backup {
+		if e.Hash != live[i].Hash {
+			return nil, ErrAuditMismatch
+		}
+	}
+	return live[len(backup):], nil
+}
+
+// backupAudit reads the audit log of the SQLite backup at path without
+// changing the file. Backups older than the audit log have an empty one.
+func backupAudit(path string) ([]*AuditEntry, error) {
+	b, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	var tables int
+	if err := b.QueryRow(auditTableExistsQuery).Scan(&tables); err != nil {
+		return nil, ErrInvalidBackup
+	}
+	if tables == 0 {
+		return nil, nil
+	}
+	return (&Db{conn: &conn{db: b}}).AuditEntries(AuditQuery{})
+}
+
+func scanAuditEntry(scan func(dest ...interface{}) error) (*AuditEntry, error) {
+	var e AuditEntry
+	err := scan(&e.Seq, &e.At, &e.Role, &e.KeyId, &e.PlayerId, &e.Addr, &e.Method, &e.Endpoint, &e.Query, &e.Body,
+		&e.Status, &e.Outcome, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// AppendAuditEntry adds e at the end of the audit log. Its Seq must follow
+// the last one, anything else is ErrAlreadyExists.
+func (d *Db) AppendAuditEntry(e AuditEntry) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var last int64
+	if err := tx.QueryRow(selectMaxAuditSeqQuery).Scan(&last); err != nil {
+		return err
+	}
+	if e.Seq != last+1 {
+		return ErrAlreadyExists
+	}
+
+	_, err = tx.Exec(insertAuditEntryQuery, e.Seq, e.At, e.Role, e.KeyId, e.PlayerId, e.Addr, e.Method, e.Endpoint, e.Query, e.Body,
+		e.Status, e.Outcome, e.PrevHash, e.Hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Db) LastAuditEntry() (_ *AuditEntry, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectLastAuditQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorNotFound
+	}
+	e, err := scanAuditEntry(rows.Scan)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return e, tx.Commit()
+}
+
+// AuditEntries lists the entries selected by q in order.
+func (d *Db) AuditEntries(q AuditQuery) (_ []*AuditEntry, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := tx.Query(selectAuditEntriesQuery, q.After, q.KeyId, q.KeyId, q.PlayerId, q.PlayerId, q.Endpoint, q.Endpoint, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"api/apierr"
+)
+
+const (
+	backupQuery     = "VACUUM INTO ?"
+	quickCheckQuery = "PRAGMA quick_check"
+)
+
+var (
+	ErrBackupNotSupported = apierr.New(apierr.CodeNotImplemented, "Storage does not support backups")
+	ErrInvalidBackup      = apierr.New(apierr.CodeInvalidArgument, "Invalid backup file")
+)
+
+// Backuper is implemented by storages kept in a single file.
+type Backuper interface {
+	// Backup writes a consistent snapshot to path while the storage stays
+	// available for reads and writes. path must not exist.
+	Backup(path string) error
+
+	// Restore replaces the storage content with the backup at path. Uses
+	// of the storage from other goroutines wait for it. The audit log is
+	// kept: the entries recorded after the backup was taken are appended
+	// to the one of the backup again.
+	Restore(path string) error
+}
+
+// ReplaceFile atomically replaces dst with a copy of src.
+func ReplaceFile(dst, src string) (rerr error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".restore"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (d *Db) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrAlreadyExists
+	}
+	d.conn.mux.RLock()
+	defer d.conn.mux.RUnlock()
+	_, err := d.conn.db.Exec(backupQuery, path)
+	return err
+}
+
+// Snapshot writes a consistent copy of the SQLite database at src to dst.
+// src is opened read-only: it is neither created nor migrated.
+func Snapshot(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	s, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	_, err = s.Exec(backupQuery, dst)
+	return err
+}
+
+func checkBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	b, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	var res string
+	if err := b.QueryRow(quickCheckQuery).Scan(&res); err != nil {
+		return ErrInvalidBackup
+	}
+	if res != "ok" {
+		return ErrInvalidBackup
+	}
+	return nil
+}
+
+// Restore reopens the database from a copy of the backup. Backups taken
+// before a schema upgrade are migrated like any other database. Other users
+// of the connection wait until it is reopened.
+func (d *Db) Restore(path string) error {
+	if d.tx != nil {
+		return errors.New("Restore is not allowed inside Update")
+	}
+	if err := checkBackup(path); err != nil {
+		return err
+	}
+	restored, err := backupAudit(path)
+	if err != nil {
+		return err
+	}
+
+	d.conn.mux.Lock()
+	defer d.conn.mux.Unlock()
+	live, err := d.unlocked().AuditEntries(AuditQuery{})
+	if err != nil {
+		return err
+	}
+	missing, err := MissingAuditEntries(live, restored)
+	if err != nil {
+		return err
+	}
+
+	if err := d.conn.db.Close(); err != nil {
+		return err
+	}
+	if err := ReplaceFile(d.conn.path, path); err != nil {
+		// the original file is untouched, reopen it
+		if oerr := d.open(d.conn.path); oerr != nil {
+			return fmt.Errorf("%v; reopening %s: %v", err, d.conn.path, oerr)
+		}
+		return err
+	}
+	if err := d.open(d.conn.path); err != nil {
+		return err
+	}
+	return d.unlocked().Update(func(s Storage) error {
+		for _, e := range missing {
+			if err := s.AppendAuditEntry(*e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unlocked returns a Db on the connection of d that does not lock it, for
+// use while holding its lock.
+func (d *Db) unlocked() *Db {
+	return &Db{conn: &conn{db: d.conn.db, path: d.conn.path}}
+}
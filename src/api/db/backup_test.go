@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDb_BackupRestore(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	backupDir, err := ioutil.TempDir("", "dbBackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	if err := myDb.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := path.Join(backupDir, "backup.db")
+	if err := myDb.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.Backup(backupPath); err != ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if err := myDb.UpdatePlayer("P1", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.CreatePlayer("P2", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := myDb.Restore(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if pts, err := myDb.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error(pts, err)
+	}
+	if _, err := myDb.PlayerPoints("P2"); err != ErrorNotFound {
+		t.Error(err)
+	}
+}
+
+func TestDb_RestoreInvalidBackup(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := myDb.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "dbBackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("definitely not a database")
+	f.Close()
+
+	if err := myDb.Restore(f.Name()); err != ErrInvalidBackup {
+		t.Error(err)
+	}
+
+	if pts, err := myDb.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error("database changed by failed restore", pts, err)
+	}
+}
+
+func TestDb_RestoreWhileInUse(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	backupDir, err := ioutil.TempDir("", "dbBackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	if err := myDb.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := path.Join(backupDir, "backup.db")
+	if err := myDb.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers sharing the connection, like the authenticator and the
+	// webhook workers, wait for the restore instead of failing
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(s Storage) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := s.PlayerPoints("P1"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(myDb.WithContext(context.Background()))
+	}
+	for i := 0; i < 5; i++ {
+		if err := myDb.Restore(backupPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestDb_RestoreKeepsAuditLog(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	backupDir, err := ioutil.TempDir("", "dbBackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backupDir)
+
+	entry := func(seq int64, hash string) AuditEntry {
+		return AuditEntry{Seq: seq, At: time.Now().UTC(), Method: "POST", Endpoint: "/v2/reset", Hash: hash}
+	}
+	if err := myDb.AppendAuditEntry(entry(1, "h1")); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := path.Join(backupDir, "backup.db")
+	if err := myDb.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	for seq, hash := range []string{"h2", "h3"} {
+		if err := myDb.AppendAuditEntry(entry(int64(seq+2), hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := myDb.Restore(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := myDb.AuditEntries(AuditQuery{})
+	if err != nil || len(entries) != 3 || entries[2].Hash != "h3" {
+		t.Fatal("entries recorded after the backup dropped", entries, err)
+	}
+
+	// the audit log of another database would rewrite the recorded calls
+	other, otherCloser, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherCloser()
+	if err := other.AppendAuditEntry(entry(1, "other")); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := path.Join(backupDir, "other.db")
+	if err := other.Backup(otherPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.Restore(otherPath); err != ErrAuditMismatch {
+		t.Error(err)
+	}
+	if pts, err := myDb.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error("refused restore changed the database", pts, err)
+	}
+}
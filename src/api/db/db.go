@@ -1,66 +1,190 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"api/apierr"
+	"logging"
+	"metrics"
 )
 
 const (
-	createTournamentsTable = "CREATE TABLE 'Tournaments' (`TourId`	INTEGER NOT NULL UNIQUE, `Deposit`	INTEGER NOT NULL, `Players`	TEXT, PRIMARY KEY(TourId));"
-	createPlayersTable     = "CREATE TABLE `Players` (`PlayerId` TEXT NOT NULL UNIQUE, `Points`	INTEGER, PRIMARY KEY(PlayerId));"
-
 	deleteTournamentsQuery = "DELETE FROM Tournaments;"
 	deletePlayersQuery     = "DELETE FROM Players;"
+	deleteWinnersQuery     = "DELETE FROM Winners;"
+	deleteEntriesQuery     = "DELETE FROM TournamentEntries;"
+	deleteBackersQuery     = "DELETE FROM EntryBackers;"
 )
 
 var (
-	ErrorNotFound    = errors.New("Not found")
-	ErrAlreadyExists = errors.New("Already exists")
+	ErrorNotFound    = apierr.New(apierr.CodeNotFound, "Not found")
+	ErrAlreadyExists = apierr.New(apierr.CodeAlreadyExists, "Already exists")
 )
 
+var queryDuration = metrics.NewHistogram("backafriend_sqlite_query_duration_seconds",
+	"Time SQLite takes to run a statement, by its kind: select, insert, update or delete.",
+	metrics.DefaultBuckets, "statement")
+
 type Db struct {
-	db *sql.DB
+	conn *conn
+	tx   *sql.Tx
+	ctx  context.Context // of the request statements are run for, nil outside requests
 }
 
-func (d *Db) Create(dbPath string) error {
-	var err error
-	d.db, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
+// conn is the connection a Db shares with the copies made for transactions
+// and requests. Restore swaps it holding mux, everything else holds mux for
+// reading while it uses the connection.
+type conn struct {
+	mux  sync.RWMutex
+	db   *sql.DB
+	path string
+}
+
+// WithContext returns a Db logging failed statements with the request id
+// of ctx. It shares the connection with d.
+func (d *Db) WithContext(ctx context.Context) Storage {
+	c := *d
+	c.ctx = ctx
+	return &c
+}
+
+// dbTx lets operations called from Update share the outer transaction:
+// Commit and Rollback are left to Update itself.
+type dbTx struct {
+	*sql.Tx
+	nested  bool
+	ctx     context.Context
+	release func() // unlocks the connection once the transaction ends
+}
+
+func (t dbTx) Commit() error {
+	if t.nested {
+		return nil
 	}
+	defer t.release()
+	return t.Tx.Commit()
+}
 
-	tx, err := d.db.Begin()
+func (t dbTx) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	defer t.release()
+	return t.Tx.Rollback()
+}
+
+func (t dbTx) Exec(query string, args ...interface{}) (_ sql.Result, err error) {
+	defer t.observe(query, time.Now(), &err)
+	return t.Tx.Exec(query, args...)
+}
+
+func (t dbTx) Query(query string, args ...interface{}) (_ *sql.Rows, err error) {
+	defer t.observe(query, time.Now(), &err)
+	return t.Tx.Query(query, args...)
+}
+
+// QueryRow leaves errors to Scan, they are not logged.
+func (t dbTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer t.observe(query, time.Now(), nil)
+	return t.Tx.QueryRow(query, args...)
+}
+
+// observe records the latency of a statement started at start and logs
+// it if it failed.
+func (t dbTx) observe(query string, start time.Time, err *error) {
+	statement := ""
+	if f := strings.Fields(query); len(f) > 0 {
+		statement = strings.ToLower(f[0])
+	}
+	took := time.Since(start)
+	queryDuration.Observe(took.Seconds(), statement)
+	if err != nil && *err != nil {
+		ctx := t.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		logging.Error(ctx, "sqlite statement failed", *err, logging.Fields{"query": query, "took": took.String()})
+	}
+}
+
+// begin starts a transaction, or joins the one of Update. The connection
+// stays locked for reading until the transaction is committed or rolled
+// back.
+func (d *Db) begin() (dbTx, error) {
+	if d.tx != nil {
+		return dbTx{Tx: d.tx, nested: true, ctx: d.ctx}, nil
+	}
+	d.conn.mux.RLock()
+	tx, err := d.conn.db.Begin()
 	if err != nil {
-		return err
+		d.conn.mux.RUnlock()
+		return dbTx{}, err
 	}
+	var once sync.Once
+	release := func() { once.Do(d.conn.mux.RUnlock) }
+	return dbTx{Tx: tx, ctx: d.ctx, release: release}, nil
+}
 
-	var statement *sql.Stmt
-	statement, err = tx.Prepare(createTournamentsTable)
+// Create opens the database at dbPath, creating it if needed, and upgrades
+// its schema to LatestSchemaVersion.
+func (d *Db) Create(dbPath string) error {
+	d.conn = &conn{}
+	return d.open(dbPath)
+}
+
+// open connects to dbPath and migrates it. The caller owns the connection.
+func (d *Db) open(dbPath string) error {
+	var err error
+	d.conn.db, err = sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return err
 	}
-	if _, err = statement.Exec(); err != nil {
+	d.conn.path = dbPath
+
+	if err := d.migrate(); err != nil {
+		d.conn.db.Close()
 		return err
 	}
+	return nil
+}
 
-	statement, err = tx.Prepare(createPlayersTable)
+func (d *Db) Stop() error {
+	d.conn.mux.Lock()
+	defer d.conn.mux.Unlock()
+	return d.conn.db.Close()
+}
+
+func (d *Db) Update(fn func(s Storage) error) (rerr error) {
+	if d.tx != nil {
+		return fn(d)
+	}
+
+	d.conn.mux.RLock()
+	defer d.conn.mux.RUnlock()
+	tx, err := d.conn.db.Begin()
 	if err != nil {
 		return err
 	}
-	if _, err = statement.Exec(); err != nil {
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(&Db{conn: d.conn, tx: tx, ctx: d.ctx}); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func (d *Db) Stop() error {
-	return d.db.Close()
-}
-
 func (d *Db) Reset() (rerr error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return err
 	}
@@ -77,5 +201,18 @@ func (d *Db) Reset() (rerr error) {
 	if _, err := tx.Exec(deletePlayersQuery); err != nil {
 		return err
 	}
+
+	if _, err := tx.Exec(deleteWinnersQuery); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(deleteEntriesQuery); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(deleteBackersQuery); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
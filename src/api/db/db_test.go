@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 )
 
 func setupMyDb() (_ *Db, _ func(), rerr error) {
@@ -53,20 +54,19 @@ func TestDb_CreateTournament(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	rows, err := myDb.db.Query("select * from Tournaments where TourId=?", tournamentId)
+	rows, err := myDb.conn.db.Query("select TourId, Deposit from Tournaments where TourId=?", tournamentId)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	var tourId, depo int
-	var p string
 
 	i := 0
 	for rows.Next() {
 		if i > 0 {
 			t.Fatal("Too many records")
 		}
-		if err := rows.Scan(&tourId, &depo, &p); err != nil {
+		if err := rows.Scan(&tourId, &depo); err != nil {
 			t.Fatal(err)
 		}
 
@@ -74,10 +74,6 @@ func TestDb_CreateTournament(t *testing.T) {
 			t.Error(tourId)
 		}
 
-		if p != "" {
-			t.Error(p)
-		}
-
 		if depo != deposit {
 			t.Error(depo)
 		}
@@ -107,14 +103,14 @@ func TestDb_JoinTournament(t *testing.T) {
 	if err := myDb.CreateTournament(tournamentId, deposit); err != nil {
 		t.Fatal(err)
 	}
-	if err := myDb.JoinTournament(tournamentId+1, playerId1); err != ErrorNotFound {
+	if err := myDb.JoinTournament(tournamentId+1, Entry{PlayerId: playerId1, Stake: deposit}); err != ErrorNotFound {
 		t.Error(errors.New("Unexpected tournament found"))
 	}
 
-	if err := myDb.JoinTournament(tournamentId, playerId1); err != nil {
+	if err := myDb.JoinTournament(tournamentId, Entry{PlayerId: playerId1, Stake: deposit}); err != nil {
 		t.Fatal(err)
 	}
-	if err := myDb.JoinTournament(tournamentId, playerId2); err != nil {
+	if err := myDb.JoinTournament(tournamentId, Entry{PlayerId: playerId2, Stake: deposit}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -143,11 +139,71 @@ func TestDb_JoinTournament(t *testing.T) {
 		t.Error(tournament.Deposit)
 	}
 
-	if err := myDb.JoinTournament(tournamentId, playerId1); err != ErrAlreadyExists {
+	if err := myDb.JoinTournament(tournamentId, Entry{PlayerId: playerId1, Stake: deposit}); err != ErrAlreadyExists {
 		t.Error(err)
 	}
 }
 
+func TestDb_TournamentEntries(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	const (
+		tourId  = 7
+		deposit = 900
+	)
+	joinedAt := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := myDb.CreateTournament(tourId, deposit); err != nil {
+		t.Fatal(err)
+	}
+
+	// commas used to corrupt the comma-joined Players column
+	entry := Entry{
+		PlayerId: "Smith, John",
+		JoinedAt: joinedAt,
+		Stake:    300,
+		Backers:  []Backing{{"B1", 300}, {"B2", 300}},
+	}
+	if err := myDb.JoinTournament(tourId, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.JoinTournament(tourId, Entry{PlayerId: "P2", JoinedAt: joinedAt, Stake: deposit}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := myDb.SetEntryPosition(tourId, "P2", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.SetEntryPosition(tourId, "P3", 2); err != ErrorNotFound {
+		t.Error(err)
+	}
+
+	tournament, err := myDb.TournamentInfo(tourId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tournament.Entries) != 2 {
+		t.Fatal(tournament.Entries)
+	}
+
+	e := tournament.Entries[0]
+	if e.PlayerId != "Smith, John" || e.Stake != 300 || !e.JoinedAt.Equal(joinedAt) || e.Position != 0 {
+		t.Error(e)
+	}
+	if len(e.Backers) != 2 || e.Backers[0].BackerId != "B1" || e.Backers[1].Stake != 300 {
+		t.Error(e.Backers)
+	}
+
+	e = tournament.Entries[1]
+	if e.PlayerId != "P2" || e.Stake != deposit || e.Position != 1 || len(e.Backers) != 0 {
+		t.Error(e)
+	}
+}
+
 func TestDb_PlayerPointsNegative(t *testing.T) {
 	myDb, closer, err := setupMyDb()
 	if err != nil {
@@ -228,10 +284,10 @@ func TestDb_Reset(t *testing.T) {
 	if err := myDb.CreateTournament(tourId, deposit); err != nil {
 		t.Fatal(err)
 	}
-	if err := myDb.JoinTournament(tourId, playerId1); err != nil {
+	if err := myDb.JoinTournament(tourId, Entry{PlayerId: playerId1, Stake: deposit}); err != nil {
 		t.Fatal(err)
 	}
-	if err := myDb.JoinTournament(tourId, playerId2); err != nil {
+	if err := myDb.JoinTournament(tourId, Entry{PlayerId: playerId2, Stake: deposit}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -251,3 +307,314 @@ func TestDb_Reset(t *testing.T) {
 		t.Fatal("tournament was not removed")
 	}
 }
+
+func TestDb_UpdateRollback(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := myDb.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	errAbort := errors.New("abort")
+	err = myDb.Update(func(s Storage) error {
+		if err := s.UpdatePlayer("P1", 50); err != nil {
+			return err
+		}
+		if err := s.CreatePlayer("P2", 100); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+
+	if pts, err := myDb.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error("P1 update was not rolled back", pts, err)
+	}
+	if _, err := myDb.PlayerPoints("P2"); err != ErrorNotFound {
+		t.Error("P2 creation was not rolled back", err)
+	}
+}
+
+func TestDb_CloseSeason(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	first, err := myDb.CurrentSeason()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id != 1 || !first.ClosedAt.IsZero() {
+		t.Fatal(first)
+	}
+
+	if err := myDb.CreateTournament(1, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	closedAt := time.Now().UTC()
+	standings := []Standing{{PlayerId: "P1", Rank: 1, Points: 300, Played: 1, Won: 1}}
+	next, err := myDb.CloseSeason(closedAt, map[string]int{"P1": 300}, standings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Id != 2 || !next.StartedAt.Equal(closedAt) {
+		t.Error(next)
+	}
+
+	if err := myDb.CreateTournament(2, 100); err != nil {
+		t.Fatal(err)
+	}
+	for tourId, seasonId := range map[int]int{1: 1, 2: 2} {
+		info, err := myDb.TournamentInfo(tourId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.SeasonId != seasonId {
+			t.Error("wrong season of tournament", tourId, info.SeasonId)
+		}
+	}
+
+	seasons, err := myDb.Seasons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seasons) != 2 || seasons[0].ClosedAt.IsZero() || !seasons[1].ClosedAt.IsZero() {
+		t.Error(seasons)
+	}
+
+	if balances, err := myDb.SeasonBalances(1); err != nil || balances["P1"] != 300 {
+		t.Error(balances, err)
+	}
+	if s, err := myDb.SeasonStandings(1); err != nil || len(s) != 1 || s[0] != standings[0] {
+		t.Error(s, err)
+	}
+	if _, err := myDb.SeasonStandings(3); err != ErrorNotFound {
+		t.Error(err)
+	}
+
+	if err := myDb.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := myDb.CurrentSeason(); err != nil || s.Id != 2 {
+		t.Error("reset restarted seasons", s, err)
+	}
+	if balances, err := myDb.SeasonBalances(1); err != nil || balances["P1"] != 300 {
+		t.Error("archived balances did not survive reset", balances, err)
+	}
+	if s, err := myDb.SeasonStandings(1); err != nil || len(s) != 1 || s[0] != standings[0] {
+		t.Error("archived standings did not survive reset", s, err)
+	}
+}
+
+func TestDb_ApiKeys(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	key := ApiKey{Id: "k1", Hash: "h1", Role: "operator", CreatedAt: time.Now().UTC()}
+	if err := myDb.CreateApiKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.CreateApiKey(key); err != ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if err := myDb.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := myDb.ApiKey("k1")
+	if err != nil {
+		t.Fatal("key did not survive reset", err)
+	}
+	if k.Hash != "h1" || k.Role != "operator" || !k.RevokedAt.IsZero() {
+		t.Error(k)
+	}
+
+	revokedAt := time.Now().UTC()
+	if err := myDb.RevokeApiKey("k1", revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.RevokeApiKey("k1", revokedAt.Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if err := myDb.RevokeApiKey("k2", revokedAt); err != ErrorNotFound {
+		t.Error(err)
+	}
+
+	keys, err := myDb.ApiKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].RevokedAt.Equal(revokedAt) {
+		t.Error(keys)
+	}
+}
+
+func TestDb_Webhooks(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	now := time.Now().UTC()
+	hook := Webhook{Id: "w1", URL: "http://partner/hook", Secret: "s1", Events: []string{"settled", "funded"}, CreatedAt: now}
+	if err := myDb.CreateWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.CreateWebhook(hook); err != ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	for i, state := range []string{DeliveryPending, DeliveryDead} {
+		d := Delivery{Id: fmt.Sprint("d", i), WebhookId: "w1", EventType: "settled", Payload: "{}", State: state, NextAttempt: now, CreatedAt: now.Add(time.Duration(i))}
+		if err := myDb.CreateDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := myDb.CreateDelivery(Delivery{Id: "d9", WebhookId: "w2", CreatedAt: now, NextAttempt: now}); err != ErrorNotFound {
+		t.Error("delivery for unknown webhook", err)
+	}
+
+	if err := myDb.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := myDb.Webhook("w1")
+	if err != nil {
+		t.Fatal("webhook did not survive reset", err)
+	}
+	if w.URL != hook.URL || len(w.Events) != 2 || !w.Wants("funded") || w.Wants("joined") {
+		t.Error(w)
+	}
+
+	pending, err := myDb.Deliveries("w1", DeliveryPending)
+	if err != nil || len(pending) != 1 || pending[0].Id != "d0" {
+		t.Fatal(pending, err)
+	}
+	d := *pending[0]
+	d.State = DeliveryDelivered
+	d.Attempts = 2
+	d.LastStatus = 204
+	d.DeliveredAt = now
+	if err := myDb.UpdateDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := myDb.Delivery("d0")
+	if err != nil || stored.State != DeliveryDelivered || stored.Attempts != 2 || !stored.DeliveredAt.Equal(now) {
+		t.Error(stored, err)
+	}
+	if all, err := myDb.Deliveries("", ""); err != nil || len(all) != 2 || all[0].Id != "d0" {
+		t.Error(all, err)
+	}
+
+	if err := myDb.DeleteWebhook("w1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.DeleteWebhook("w1"); err != ErrorNotFound {
+		t.Error(err)
+	}
+	if all, err := myDb.Deliveries("", ""); err != nil || len(all) != 0 {
+		t.Error("deliveries of deleted webhook kept", all, err)
+	}
+}
+
+func TestDb_AuditLog(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if _, err := myDb.LastAuditEntry(); err != ErrorNotFound {
+		t.Error(err)
+	}
+
+	now := time.Now().UTC()
+	for i, ep := range []string{"/fund", "/reset", "/fund"} {
+		e := AuditEntry{Seq: int64(i + 1), At: now, Role: "operator", KeyId: "k1", Endpoint: ep, Query: "playerId=P1", Status: 200, Outcome: "ok", Hash: fmt.Sprint("h", i)}
+		if err := myDb.AppendAuditEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := myDb.AppendAuditEntry(AuditEntry{Seq: 2, At: now}); err != ErrAlreadyExists {
+		t.Error("overwrote an entry", err)
+	}
+	if err := myDb.AppendAuditEntry(AuditEntry{Seq: 5, At: now}); err != ErrAlreadyExists {
+		t.Error("left a gap", err)
+	}
+
+	if err := myDb.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := myDb.LastAuditEntry()
+	if err != nil || last.Seq != 3 || last.Hash != "h2" || !last.At.Equal(now) {
+		t.Fatal("audit log did not survive reset", last, err)
+	}
+	if fund, err := myDb.AuditEntries(AuditQuery{Endpoint: "/fund"}); err != nil || len(fund) != 2 || fund[1].Seq != 3 {
+		t.Error(fund, err)
+	}
+	if page, err := myDb.AuditEntries(AuditQuery{After: 1, Limit: 1}); err != nil || len(page) != 1 || page[0].Seq != 2 {
+		t.Error(page, err)
+	}
+
+	if _, err := myDb.conn.db.Exec("update AuditLog set Outcome='forbidden' where Seq=1"); err == nil {
+		t.Error("changed an entry")
+	}
+	if _, err := myDb.conn.db.Exec("delete from AuditLog"); err == nil {
+		t.Error("deleted entries")
+	}
+}
+
+func TestDb_Stats(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if s, err := myDb.Stats(); err != nil || *s != (Stats{}) {
+		t.Error("empty", s, err)
+	}
+
+	for p, pts := range map[string]int{"P1": 100, "P2": 200, "P3": 300} {
+		if err := myDb.CreatePlayer(p, pts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := myDb.CreateTournament(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.JoinTournament(1, Entry{PlayerId: "P1", JoinedAt: time.Now().UTC(), Stake: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.SettleTournament(1, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if err := myDb.CreateTournament(2, 400); err != nil {
+		t.Fatal(err)
+	}
+	backed := Entry{PlayerId: "P2", JoinedAt: time.Now().UTC(), Stake: 200, Backers: []Backing{{"P3", 100}, {"P1", 100}}}
+	if err := myDb.JoinTournament(2, backed); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the stakes of the running tournament are in circulation
+	want := Stats{ActiveTournaments: 1, Points: 600 + 400, BackedEntries: 1, SelfFundedEntries: 1}
+	if s, err := myDb.Stats(); err != nil || *s != want {
+		t.Error(s, err)
+	}
+}
@@ -0,0 +1,431 @@
+package db
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"api/apierr"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Record types of an export.
+const (
+	RecordPlayer     = "player"
+	RecordTournament = "tournament"
+	RecordEntry      = "entry"
+	RecordBacking    = "backing"
+)
+
+var (
+	ErrUnknownFormat      = apierr.New(apierr.CodeInvalidArgument, "Unknown export format")
+	ErrTooManyRunning     = apierr.New(apierr.CodeInvalidArgument, "More than one running tournament")
+	ErrStoreNotEmpty      = apierr.New(apierr.CodeAlreadyExists, "Storage already holds players or tournaments, imports need an empty one")
+	errUnknownRecord      = apierr.New(apierr.CodeInvalidArgument, "unknown record type")
+	errMissingPlayerId    = apierr.New(apierr.CodeInvalidArgument, "missing PlayerId")
+	errMissingBackerId    = apierr.New(apierr.CodeInvalidArgument, "missing BackerId")
+	errInvalidPoints      = apierr.New(apierr.CodeInvalidArgument, "points must not be negative")
+	errInvalidDeposit     = apierr.New(apierr.CodeInvalidArgument, "deposit must be positive")
+	errInvalidStake       = apierr.New(apierr.CodeInvalidArgument, "stake must not be negative")
+	errUnknownPlayer      = apierr.New(apierr.CodeInvalidArgument, "player is not part of the import")
+	errUnknownTournament  = apierr.New(apierr.CodeInvalidArgument, "tournament is not part of the import")
+	errUnknownEntry       = apierr.New(apierr.CodeInvalidArgument, "entry is not part of the import")
+	errDuplicateRecord    = apierr.New(apierr.CodeInvalidArgument, "duplicate record")
+	errMalformedCSVRecord = apierr.New(apierr.CodeInvalidArgument, "wrong number of fields")
+)
+
+// Record is one line of an export. Which fields are set depends on Type:
+//
+//	player:     PlayerId, Points
+//	tournament: TourId, Deposit, SettledAt
+//	entry:      TourId, PlayerId, JoinedAt, Stake, Position
+//	backing:    TourId, PlayerId, BackerId, Stake
+type Record struct {
+	Type      string
+	TourId    int    `json:",omitempty"`
+	PlayerId  string `json:",omitempty"`
+	BackerId  string `json:",omitempty"`
+	Points    int    `json:",omitempty"`
+	Deposit   int    `json:",omitempty"`
+	Stake     int    `json:",omitempty"`
+	Position  int    `json:",omitempty"`
+	JoinedAt  time.Time
+	SettledAt time.Time
+}
+
+var csvHeader = []string{"Type", "TourId", "PlayerId", "BackerId", "Points", "Deposit", "Stake", "Position", "JoinedAt", "SettledAt"}
+
+// ImportError points at the record an import was rejected for, counting
+// from 1 in the order records appear in the file.
+type ImportError struct {
+	Record int
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.Record, e.Err)
+}
+
+// ErrorCode returns the code of the reason the record was rejected for.
+func (e *ImportError) ErrorCode() apierr.Code {
+	return apierr.CodeOf(e.Err)
+}
+
+// annotate prefixes the message of err, keeping its code.
+func annotate(err error, format string, args ...interface{}) error {
+	return &apierr.Error{Code: apierr.CodeOf(err), Message: fmt.Sprintf(format, args...) + ": " + err.Error(), Err: err}
+}
+
+// ExportRecords lists every player, tournament, entry and backing of s.
+func ExportRecords(s Storage) ([]Record, error) {
+	players, err := s.Players()
+	if err != nil {
+		return nil, err
+	}
+
+	tournaments, err := s.Tournaments()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(players))
+	for id := range players {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	res := []Record{}
+	for _, id := range ids {
+		res = append(res, Record{Type: RecordPlayer, PlayerId: id, Points: players[id]})
+	}
+
+	for _, t := range tournaments {
+		res = append(res, Record{Type: RecordTournament, TourId: t.Id, Deposit: t.Deposit, SettledAt: t.SettledAt})
+		for _, e := range t.Entries {
+			res = append(res, Record{Type: RecordEntry, TourId: t.Id, PlayerId: e.PlayerId, JoinedAt: e.JoinedAt, Stake: e.Stake, Position: e.Position})
+			for _, b := range e.Backers {
+				res = append(res, Record{Type: RecordBacking, TourId: t.Id, PlayerId: e.PlayerId, BackerId: b.BackerId, Stake: b.Stake})
+			}
+		}
+	}
+	return res, nil
+}
+
+// Export writes the content of s to w as JSON Lines or CSV. Season archives
+// are not exported, imported tournaments join the current season.
+func Export(s Storage, w io.Writer, format string) error {
+	if format != FormatJSONL && format != FormatCSV {
+		return ErrUnknownFormat
+	}
+
+	records, err := ExportRecords(s)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatJSONL {
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write(r.csvFields()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func (r Record) csvFields() []string {
+	return []string{
+		r.Type,
+		strconv.Itoa(r.TourId),
+		r.PlayerId,
+		r.BackerId,
+		strconv.Itoa(r.Points),
+		strconv.Itoa(r.Deposit),
+		strconv.Itoa(r.Stake),
+		strconv.Itoa(r.Position),
+		formatTime(r.JoinedAt),
+		formatTime(r.SettledAt),
+	}
+}
+
+func recordFromCSV(f []string) (Record, error) {
+	if len(f) != len(csvHeader) {
+		return Record{}, errMalformedCSVRecord
+	}
+
+	r := Record{Type: f[0], PlayerId: f[2], BackerId: f[3]}
+	ints := map[int]*int{1: &r.TourId, 4: &r.Points, 5: &r.Deposit, 6: &r.Stake, 7: &r.Position}
+	for i, dst := range ints {
+		v, err := strconv.Atoi(f[i])
+		if err != nil {
+			return Record{}, err
+		}
+		*dst = v
+	}
+
+	var err error
+	if r.JoinedAt, err = parseTime(f[8]); err != nil {
+		return Record{}, err
+	}
+	if r.SettledAt, err = parseTime(f[9]); err != nil {
+		return Record{}, err
+	}
+	return r, nil
+}
+
+// ReadRecords parses an export. Line numbers in errors count the CSV
+// header as line 1.
+func ReadRecords(r io.Reader, format string) ([]Record, error) {
+	res := []Record{}
+	switch format {
+	case FormatJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			if len(sc.Bytes()) == 0 {
+				continue
+			}
+			var rec Record
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				return nil, apierr.Invalid("line %d: %v", line, err)
+			}
+			res = append(res, rec)
+		}
+		return res, sc.Err()
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			f, err := cr.Read()
+			if err == io.EOF {
+				return res, nil
+			}
+			if err != nil {
+				return nil, apierr.Invalid("line %d: %v", line, err)
+			}
+			if line == 1 && len(f) > 0 && f[0] == csvHeader[0] {
+				continue
+			}
+			rec, err := recordFromCSV(f)
+			if err != nil {
+				return nil, apierr.Invalid("line %d: %v", line, err)
+			}
+			res = append(res, rec)
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+type entryKey struct {
+	tourId   int
+	playerId string
+}
+
+// validateRecords checks records on their own and against each other:
+// entries and backings have to follow the tournament and entry they
+// belong to and the players they name.
+func validateRecords(records []Record) error {
+	players := make(map[string]bool)
+	tournaments := make(map[int]bool)
+	entries := make(map[entryKey]bool)
+	backings := make(map[entryKey]map[string]bool)
+
+	for i, r := range records {
+		var err error
+		switch r.Type {
+		case RecordPlayer:
+			switch {
+			case r.PlayerId == "":
+				err = errMissingPlayerId
+			case r.Points < 0:
+				err = errInvalidPoints
+			case players[r.PlayerId]:
+				err = errDuplicateRecord
+			}
+			players[r.PlayerId] = true
+		case RecordTournament:
+			switch {
+			case r.Deposit <= 0:
+				err = errInvalidDeposit
+			case tournaments[r.TourId]:
+				err = errDuplicateRecord
+			}
+			tournaments[r.TourId] = true
+		case RecordEntry:
+			k := entryKey{r.TourId, r.PlayerId}
+			switch {
+			case r.PlayerId == "":
+				err = errMissingPlayerId
+			case r.Stake < 0:
+				err = errInvalidStake
+			case !tournaments[r.TourId]:
+				err = errUnknownTournament
+			case !players[r.PlayerId]:
+				err = errUnknownPlayer
+			case entries[k]:
+				err = errDuplicateRecord
+			}
+			entries[k] = true
+		case RecordBacking:
+			k := entryKey{r.TourId, r.PlayerId}
+			switch {
+			case r.BackerId == "":
+				err = errMissingBackerId
+			case r.Stake < 0:
+				err = errInvalidStake
+			case !entries[k]:
+				err = errUnknownEntry
+			case !players[r.BackerId]:
+				err = errUnknownPlayer
+			case backings[k][r.BackerId]:
+				err = errDuplicateRecord
+			}
+			if backings[k] == nil {
+				backings[k] = make(map[string]bool)
+			}
+			backings[k][r.BackerId] = true
+		default:
+			err = errUnknownRecord
+		}
+
+		if err != nil {
+			return &ImportError{i + 1, err}
+		}
+	}
+	return nil
+}
+
+// ImportRecords validates records and applies them to s in a single
+// transaction. s must not hold players or tournaments yet.
+func ImportRecords(s Storage, records []Record) error {
+	if err := validateRecords(records); err != nil {
+		return err
+	}
+
+	var tournaments []*Tournament
+	idx := make(map[int]*Tournament)
+	for _, r := range records {
+		switch r.Type {
+		case RecordTournament:
+			t := &Tournament{Id: r.TourId, Deposit: r.Deposit, SettledAt: r.SettledAt}
+			tournaments = append(tournaments, t)
+			idx[r.TourId] = t
+		case RecordEntry:
+			t := idx[r.TourId]
+			t.Entries = append(t.Entries, Entry{PlayerId: r.PlayerId, JoinedAt: r.JoinedAt, Stake: r.Stake, Position: r.Position})
+		case RecordBacking:
+			t := idx[r.TourId]
+			for i := range t.Entries {
+				if t.Entries[i].PlayerId == r.PlayerId {
+					t.Entries[i].Backers = append(t.Entries[i].Backers, Backing{r.BackerId, r.Stake})
+				}
+			}
+		}
+	}
+
+	return s.Update(func(s Storage) error {
+		if err := checkEmpty(s); err != nil {
+			return err
+		}
+		for i, r := range records {
+			if r.Type != RecordPlayer {
+				continue
+			}
+			if err := s.CreatePlayer(r.PlayerId, r.Points); err != nil {
+				return &ImportError{i + 1, err}
+			}
+		}
+
+		for _, t := range tournaments {
+			if err := s.CreateTournament(t.Id, t.Deposit); err != nil {
+				return annotate(err, "tournament %d", t.Id)
+			}
+			for _, e := range t.Entries {
+				if err := s.JoinTournament(t.Id, e); err != nil {
+					return annotate(err, "tournament %d, entry %s", t.Id, e.PlayerId)
+				}
+			}
+			if !t.SettledAt.IsZero() {
+				if err := s.SettleTournament(t.Id, t.SettledAt); err != nil {
+					return annotate(err, "tournament %d", t.Id)
+				}
+			}
+		}
+
+		all, err := s.Tournaments()
+		if err != nil {
+			return err
+		}
+		running := 0
+		for _, t := range all {
+			if t.SettledAt.IsZero() {
+				running++
+			}
+		}
+		if running > 1 {
+			return ErrTooManyRunning
+		}
+		return nil
+	})
+}
+
+// checkEmpty fails with ErrStoreNotEmpty if s holds players or tournaments.
+func checkEmpty(s Storage) error {
+	players, err := s.Players()
+	if err != nil {
+		return err
+	}
+	tournaments, err := s.Tournaments()
+	if err != nil {
+		return err
+	}
+	if len(players) > 0 || len(tournaments) > 0 {
+		return ErrStoreNotEmpty
+	}
+	return nil
+}
+
+// Import reads an export from r and applies it to s, see ImportRecords.
+func Import(s Storage, r io.Reader, format string) error {
+	records, err := ReadRecords(r, format)
+	if err != nil {
+		return err
+	}
+	return ImportRecords(s, records)
+}
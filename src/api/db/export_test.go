@@ -0,0 +1,136 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fillStorage(t *testing.T, s Storage) {
+	joinedAt := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	for id, pts := range map[string]int{"P1": 100, "P2": 200, "Smith, John": 300} {
+		if err := s.CreatePlayer(id, pts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.CreateTournament(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{PlayerId: "Smith, John", JoinedAt: joinedAt, Stake: 500, Position: 1, Backers: []Backing{{"P1", 500}}}
+	if err := s.JoinTournament(1, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.JoinTournament(1, Entry{PlayerId: "P2", JoinedAt: joinedAt, Stake: 1000, Position: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SettleTournament(1, joinedAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateTournament(2, 300); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			src := CreateMemDb()
+			fillStorage(t, src)
+
+			var buf bytes.Buffer
+			if err := Export(src, &buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			dst := CreateMemDb()
+			if err := Import(dst, bytes.NewReader(buf.Bytes()), format); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := ExportRecords(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ExportRecords(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatal(got, want)
+			}
+			for i := range want {
+				if !got[i].JoinedAt.Equal(want[i].JoinedAt) || !got[i].SettledAt.Equal(want[i].SettledAt) {
+					t.Error(got[i], want[i])
+				}
+				got[i].JoinedAt, want[i].JoinedAt = time.Time{}, time.Time{}
+				got[i].SettledAt, want[i].SettledAt = time.Time{}, time.Time{}
+				if got[i] != want[i] {
+					t.Error(got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	cases := map[string]string{
+		"unknown type":        `{"Type":"ledger"}`,
+		"missing player id":   `{"Type":"player","Points":10}`,
+		"entry before tour":   `{"Type":"entry","TourId":1,"PlayerId":"P1"}`,
+		"backing of no entry": `{"Type":"tournament","TourId":1,"Deposit":10}` + "\n" + `{"Type":"backing","TourId":1,"PlayerId":"P1","BackerId":"P2"}`,
+		"two running":         `{"Type":"tournament","TourId":1,"Deposit":10}` + "\n" + `{"Type":"tournament","TourId":2,"Deposit":10}`,
+		"duplicate player":    `{"Type":"player","PlayerId":"P1"}` + "\n" + `{"Type":"player","PlayerId":"P1"}`,
+		"negative points":     `{"Type":"player","PlayerId":"P1","Points":-10}`,
+		"unknown entrant":     `{"Type":"tournament","TourId":1,"Deposit":10}` + "\n" + `{"Type":"entry","TourId":1,"PlayerId":"P1"}`,
+		"unknown backer": `{"Type":"player","PlayerId":"P1"}` + "\n" + `{"Type":"tournament","TourId":1,"Deposit":10}` + "\n" +
+			`{"Type":"entry","TourId":1,"PlayerId":"P1"}` + "\n" + `{"Type":"backing","TourId":1,"PlayerId":"P1","BackerId":"P2"}`,
+	}
+
+	for name, in := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := CreateMemDb()
+			// a valid record first, the import must be applied all or nothing
+			in = `{"Type":"player","PlayerId":"P0","Points":10}` + "\n" + in
+			if err := Import(m, strings.NewReader(in), FormatJSONL); err == nil {
+				t.Fatal("import succeeded")
+			}
+			if _, err := m.PlayerPoints("P0"); err != ErrorNotFound {
+				t.Error("failed import was partially applied")
+			}
+		})
+	}
+}
+
+func TestImportIntoSqlite(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	src := CreateMemDb()
+	fillStorage(t, src)
+
+	var buf bytes.Buffer
+	if err := Export(src, &buf, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	if err := Import(myDb, bytes.NewReader(buf.Bytes()), FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+
+	// importing the same data again conflicts and changes nothing
+	if err := Import(myDb, bytes.NewReader(buf.Bytes()), FormatCSV); err != ErrStoreNotEmpty {
+		t.Error("duplicate import", err)
+	}
+
+	tournament, err := myDb.TournamentInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tournament.Entries) != 2 || len(tournament.Entries[0].Backers) != 1 || tournament.SettledAt.IsZero() {
+		t.Error(tournament)
+	}
+}
@@ -0,0 +1,919 @@
+// Package kvdb implements db.Storage on top of bbolt, an embedded pure-Go
+// key-value store. Unlike the SQLite backend it does not need cgo, so the
+// server can be cross-compiled with CGO_ENABLED=0 when this backend is used.
+package kvdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"api/db"
+)
+
+var (
+	playersBucket     = []byte("Players")
+	tournamentsBucket = []byte("Tournaments")
+	seasonsBucket     = []byte("Seasons")
+	apiKeysBucket     = []byte("ApiKeys")
+	webhooksBucket    = []byte("Webhooks")
+	deliveriesBucket  = []byte("WebhookDeliveries")
+	auditBucket       = []byte("AuditLog")
+)
+
+type KvDb struct {
+	conn *conn
+	tx   *bolt.Tx
+}
+
+// conn is the database a KvDb shares with the copies made for Update.
+// Restore swaps it holding mux, everything else holds mux for reading.
+type conn struct {
+	mux sync.RWMutex
+	db  *bolt.DB
+}
+
+// tournament is the value stored under the tournament id key.
+type tournament struct {
+	Deposit   int
+	SeasonId  int `json:",omitempty"` // 0 in values written before seasons, read as season 1
+	Entries   []db.Entry
+	SettledAt time.Time
+
+	// Players is only set in values written before entries carried
+	// metadata; Create converts them to Entries.
+	Players []string `json:",omitempty"`
+}
+
+func (t *tournament) toDb(id int) *db.Tournament {
+	players := make([]string, 0, len(t.Entries))
+	for _, e := range t.Entries {
+		players = append(players, e.PlayerId)
+	}
+	seasonId := t.SeasonId
+	if seasonId == 0 {
+		seasonId = 1
+	}
+	return &db.Tournament{Id: id, Deposit: t.Deposit, SeasonId: seasonId, Players: players, Entries: t.Entries, SettledAt: t.SettledAt}
+}
+
+// season is the value stored under the season id key. Balances and
+// Standings are filled in when the season is closed.
+type season struct {
+	StartedAt time.Time
+	ClosedAt  time.Time
+	Balances  map[string]int `json:",omitempty"`
+	Standings []db.Standing  `json:",omitempty"`
+}
+
+func (k *KvDb) Create(dbPath string) error {
+	k.conn = &conn{}
+	return k.open(dbPath)
+}
+
+// open opens dbPath and creates the missing buckets. The caller owns the
+// connection.
+func (k *KvDb) open(dbPath string) error {
+	var err error
+	k.conn.db, err = bolt.Open(dbPath, 0666, nil)
+	if err != nil {
+		return err
+	}
+
+	return k.conn.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(playersBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(tournamentsBucket); err != nil {
+			return err
+		}
+		if err := initSeasons(tx); err != nil {
+			return err
+		}
+		for _, b := range [][]byte{apiKeysBucket, webhooksBucket, deliveriesBucket, auditBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return migrateEntries(tx)
+	})
+}
+
+// migrateEntries converts tournaments stored with a bare Players list,
+// recognized by their missing Entries. Like the SQLite migrations it
+// records them as settled tournaments, with or without players, with
+// self-funded entries joined at migration time.
+func migrateEntries(tx *bolt.Tx) error {
+	legacy := make(map[int]*tournament)
+	err := tx.Bucket(tournamentsBucket).ForEach(func(key, v []byte) error {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(v, &fields); err != nil {
+			return err
+		}
+		if _, ok := fields["Entries"]; ok {
+			return nil
+		}
+		t := &tournament{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return err
+		}
+		legacy[keyTourId(key)] = t
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for id, t := range legacy {
+		for _, p := range t.Players {
+			t.Entries = append(t.Entries, db.Entry{PlayerId: p, JoinedAt: now, Stake: t.Deposit})
+		}
+		t.Players = nil
+		t.SettledAt = now
+		if err := putTournament(tx, id, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KvDb) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return db.ErrAlreadyExists
+	}
+	return k.view(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+func (k *KvDb) Restore(path string) error {
+	if k.tx != nil {
+		return errors.New("Restore is not allowed inside Update")
+	}
+
+	// bolt validates the file while opening it
+	b, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return db.ErrInvalidBackup
+	}
+	var restored []*db.AuditEntry
+	err = b.View(func(tx *bolt.Tx) error {
+		// backups older than the audit log have an empty one
+		if tx.Bucket(auditBucket) == nil {
+			return nil
+		}
+		var err error
+		restored, err = auditEntries(tx, db.AuditQuery{})
+		return err
+	})
+	b.Close()
+	if err != nil {
+		return err
+	}
+
+	k.conn.mux.Lock()
+	defer k.conn.mux.Unlock()
+	live, err := k.unlocked().AuditEntries(db.AuditQuery{})
+	if err != nil {
+		return err
+	}
+	missing, err := db.MissingAuditEntries(live, restored)
+	if err != nil {
+		return err
+	}
+
+	dbPath := k.conn.db.Path()
+	if err := k.conn.db.Close(); err != nil {
+		return err
+	}
+	if err := db.ReplaceFile(dbPath, path); err != nil {
+		// the original file is untouched, reopen it
+		if oerr := k.open(dbPath); oerr != nil {
+			return fmt.Errorf("%v; reopening %s: %v", err, dbPath, oerr)
+		}
+		return err
+	}
+	if err := k.open(dbPath); err != nil {
+		return err
+	}
+	return k.unlocked().Update(func(s db.Storage) error {
+		for _, e := range missing {
+			if err := s.AppendAuditEntry(*e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unlocked returns a KvDb on the connection of k that does not lock it,
+// for use while holding its lock.
+func (k *KvDb) unlocked() *KvDb {
+	return &KvDb{conn: &conn{db: k.conn.db}}
+}
+
+func (k *KvDb) Stop() error {
+	k.conn.mux.Lock()
+	defer k.conn.mux.Unlock()
+	return k.conn.db.Close()
+}
+
+func (k *KvDb) view(fn func(tx *bolt.Tx) error) error {
+	if k.tx != nil {
+		return fn(k.tx)
+	}
+	k.conn.mux.RLock()
+	defer k.conn.mux.RUnlock()
+	return k.conn.db.View(fn)
+}
+
+func (k *KvDb) update(fn func(tx *bolt.Tx) error) error {
+	if k.tx != nil {
+		return fn(k.tx)
+	}
+	k.conn.mux.RLock()
+	defer k.conn.mux.RUnlock()
+	return k.conn.db.Update(fn)
+}
+
+func (k *KvDb) Update(fn func(s db.Storage) error) error {
+	if k.tx != nil {
+		return fn(k)
+	}
+
+	return k.update(func(tx *bolt.Tx) error {
+		return fn(&KvDb{conn: k.conn, tx: tx})
+	})
+}
+
+func (k *KvDb) Reset() error {
+	return k.update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{playersBucket, tournamentsBucket} {
+			if err := tx.DeleteBucket(b); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (k *KvDb) PlayerPoints(playerId string) (int, error) {
+	var pts int
+	err := k.view(func(tx *bolt.Tx) error {
+		v := tx.Bucket(playersBucket).Get([]byte(playerId))
+		if v == nil {
+			return db.ErrorNotFound
+		}
+
+		var err error
+		pts, err = strconv.Atoi(string(v))
+		return err
+	})
+	return pts, err
+}
+
+func (k *KvDb) MultiplePlayerPoints(playerIds []string) (map[string]int, error) {
+	res := make(map[string]int)
+	err := k.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playersBucket)
+		for _, id := range playerIds {
+			v := b.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+
+			pts, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			res[id] = pts
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (k *KvDb) UpdatePlayer(pid string, pts int) error {
+	return k.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playersBucket)
+		if b.Get([]byte(pid)) == nil {
+			// same as SQL UPDATE: unknown players are silently ignored
+			return nil
+		}
+		return b.Put([]byte(pid), []byte(strconv.Itoa(pts)))
+	})
+}
+
+func (k *KvDb) CreatePlayer(pid string, pts int) error {
+	return k.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playersBucket)
+		if b.Get([]byte(pid)) != nil {
+			return db.ErrAlreadyExists
+		}
+		return b.Put([]byte(pid), []byte(strconv.Itoa(pts)))
+	})
+}
+
+func (k *KvDb) Players() (map[string]int, error) {
+	res := make(map[string]int)
+	err := k.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(playersBucket).ForEach(func(key, v []byte) error {
+			pts, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			res[string(key)] = pts
+			return nil
+		})
+	})
+	return res, err
+}
+
+// tourKey keeps tournaments ordered by id when iterating the bucket.
+func tourKey(id int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id)^(1<<63))
+	return k
+}
+
+func keyTourId(k []byte) int {
+	return int(binary.BigEndian.Uint64(k) ^ (1 << 63))
+}
+
+func getTournament(tx *bolt.Tx, id int) (*tournament, error) {
+	v := tx.Bucket(tournamentsBucket).Get(tourKey(id))
+	if v == nil {
+		return nil, db.ErrorNotFound
+	}
+
+	t := &tournament{}
+	if err := json.Unmarshal(v, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func putTournament(tx *bolt.Tx, id int, t *tournament) error {
+	v, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(tournamentsBucket).Put(tourKey(id), v)
+}
+
+func (k *KvDb) CreateTournament(id int, deposit int) error {
+	return k.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(tournamentsBucket).Get(tourKey(id)) != nil {
+			return db.ErrAlreadyExists
+		}
+		seasonId, _, err := currentSeason(tx)
+		if err != nil {
+			return err
+		}
+		return putTournament(tx, id, &tournament{Deposit: deposit, SeasonId: seasonId, Entries: []db.Entry{}})
+	})
+}
+
+func (k *KvDb) TournamentInfo(tourId int) (*db.Tournament, error) {
+	var res *db.Tournament
+	err := k.view(func(tx *bolt.Tx) error {
+		t, err := getTournament(tx, tourId)
+		if err != nil {
+			return err
+		}
+		res = t.toDb(tourId)
+		return nil
+	})
+	return res, err
+}
+
+func (k *KvDb) JoinTournament(tourId int, entry db.Entry) error {
+	return k.update(func(tx *bolt.Tx) error {
+		t, err := getTournament(tx, tourId)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range t.Entries {
+			if e.PlayerId == entry.PlayerId {
+				return db.ErrAlreadyExists
+			}
+		}
+		t.Entries = append(t.Entries, entry)
+		return putTournament(tx, tourId, t)
+	})
+}
+
+func (k *KvDb) SetEntryPosition(tourId int, playerId string, position int) error {
+	return k.update(func(tx *bolt.Tx) error {
+		t, err := getTournament(tx, tourId)
+		if err != nil {
+			return err
+		}
+
+		for i := range t.Entries {
+			if t.Entries[i].PlayerId == playerId {
+				t.Entries[i].Position = position
+				return putTournament(tx, tourId, t)
+			}
+		}
+		return db.ErrorNotFound
+	})
+}
+
+func (k *KvDb) SettleTournament(tourId int, settledAt time.Time) error {
+	return k.update(func(tx *bolt.Tx) error {
+		t, err := getTournament(tx, tourId)
+		if err != nil {
+			return err
+		}
+		if !t.SettledAt.IsZero() {
+			return db.ErrAlreadyExists
+		}
+		t.SettledAt = settledAt
+		return putTournament(tx, tourId, t)
+	})
+}
+
+func (k *KvDb) Tournaments() ([]*db.Tournament, error) {
+	res := []*db.Tournament{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(tournamentsBucket).ForEach(func(key, v []byte) error {
+			t := &tournament{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			res = append(res, t.toDb(keyTourId(key)))
+			return nil
+		})
+	})
+	return res, err
+}
+
+// Stats reads players and tournaments in one read transaction, which does
+// not block writers.
+func (k *KvDb) Stats() (*db.Stats, error) {
+	var s db.Stats
+	err := k.view(func(tx *bolt.Tx) error {
+		err := tx.Bucket(playersBucket).ForEach(func(key, v []byte) error {
+			pts, err := strconv.Atoi(string(v))
+			s.Points += pts
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(tournamentsBucket).ForEach(func(key, v []byte) error {
+			t := &tournament{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			s.AddTournament(t.toDb(keyTourId(key)))
+			return nil
+		})
+	})
+	return &s, err
+}
+
+// initSeasons opens season 1 unless the bucket already holds seasons.
+// Tournaments stored before seasons existed belong to it.
+func initSeasons(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists(seasonsBucket)
+	if err != nil {
+		return err
+	}
+	if k, _ := b.Cursor().First(); k != nil {
+		return nil
+	}
+	return putSeason(tx, 1, &season{StartedAt: time.Now().UTC()})
+}
+
+// putSeason stores s under a key encoded like tournament ids, so seasons
+// iterate in order too.
+func putSeason(tx *bolt.Tx, id int, s *season) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(seasonsBucket).Put(tourKey(id), v)
+}
+
+func getSeason(tx *bolt.Tx, id int) (*season, error) {
+	v := tx.Bucket(seasonsBucket).Get(tourKey(id))
+	if v == nil {
+		return nil, db.ErrorNotFound
+	}
+
+	s := &season{}
+	if err := json.Unmarshal(v, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// currentSeason returns the last season, which is the open one.
+func currentSeason(tx *bolt.Tx) (int, *season, error) {
+	k, v := tx.Bucket(seasonsBucket).Cursor().Last()
+	if k == nil {
+		return 0, nil, db.ErrorNotFound
+	}
+
+	s := &season{}
+	if err := json.Unmarshal(v, s); err != nil {
+		return 0, nil, err
+	}
+	return keyTourId(k), s, nil
+}
+
+func (k *KvDb) CurrentSeason() (*db.Season, error) {
+	var res *db.Season
+	err := k.view(func(tx *bolt.Tx) error {
+		id, s, err := currentSeason(tx)
+		if err != nil {
+			return err
+		}
+		res = &db.Season{Id: id, StartedAt: s.StartedAt, ClosedAt: s.ClosedAt}
+		return nil
+	})
+	return res, err
+}
+
+func (k *KvDb) Seasons() ([]*db.Season, error) {
+	res := []*db.Season{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(seasonsBucket).ForEach(func(key, v []byte) error {
+			s := &season{}
+			if err := json.Unmarshal(v, s); err != nil {
+				return err
+			}
+			res = append(res, &db.Season{Id: keyTourId(key), StartedAt: s.StartedAt, ClosedAt: s.ClosedAt})
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (k *KvDb) CloseSeason(closedAt time.Time, balances map[string]int, standings []db.Standing) (*db.Season, error) {
+	var res *db.Season
+	err := k.update(func(tx *bolt.Tx) error {
+		id, s, err := currentSeason(tx)
+		if err != nil {
+			return err
+		}
+
+		s.ClosedAt = closedAt
+		s.Balances = balances
+		s.Standings = standings
+		if err := putSeason(tx, id, s); err != nil {
+			return err
+		}
+
+		if err := putSeason(tx, id+1, &season{StartedAt: closedAt}); err != nil {
+			return err
+		}
+		res = &db.Season{Id: id + 1, StartedAt: closedAt}
+		return nil
+	})
+	return res, err
+}
+
+func (k *KvDb) SeasonBalances(seasonId int) (map[string]int, error) {
+	res := make(map[string]int)
+	err := k.view(func(tx *bolt.Tx) error {
+		s, err := getSeason(tx, seasonId)
+		if err != nil {
+			return err
+		}
+		for id, pts := range s.Balances {
+			res[id] = pts
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (k *KvDb) SeasonStandings(seasonId int) ([]db.Standing, error) {
+	res := []db.Standing{}
+	err := k.view(func(tx *bolt.Tx) error {
+		s, err := getSeason(tx, seasonId)
+		if err != nil {
+			return err
+		}
+		res = append(res, s.Standings...)
+		return nil
+	})
+	return res, err
+}
+
+func getApiKey(tx *bolt.Tx, id string) (*db.ApiKey, error) {
+	v := tx.Bucket(apiKeysBucket).Get([]byte(id))
+	if v == nil {
+		return nil, db.ErrorNotFound
+	}
+
+	k := &db.ApiKey{}
+	if err := json.Unmarshal(v, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func putApiKey(tx *bolt.Tx, k *db.ApiKey) error {
+	v, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(apiKeysBucket).Put([]byte(k.Id), v)
+}
+
+func (k *KvDb) CreateApiKey(key db.ApiKey) error {
+	return k.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(apiKeysBucket).Get([]byte(key.Id)) != nil {
+			return db.ErrAlreadyExists
+		}
+		return putApiKey(tx, &key)
+	})
+}
+
+func (k *KvDb) ApiKey(id string) (*db.ApiKey, error) {
+	var res *db.ApiKey
+	err := k.view(func(tx *bolt.Tx) error {
+		var err error
+		res, err = getApiKey(tx, id)
+		return err
+	})
+	return res, err
+}
+
+// ApiKeys lists keys ordered by id, not by creation time like the other
+// backends.
+func (k *KvDb) ApiKeys() ([]*db.ApiKey, error) {
+	res := []*db.ApiKey{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(_, v []byte) error {
+			key := &db.ApiKey{}
+			if err := json.Unmarshal(v, key); err != nil {
+				return err
+			}
+			res = append(res, key)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (k *KvDb) RevokeApiKey(id string, revokedAt time.Time) error {
+	return k.update(func(tx *bolt.Tx) error {
+		key, err := getApiKey(tx, id)
+		if err != nil {
+			return err
+		}
+		if !key.RevokedAt.IsZero() {
+			return nil
+		}
+		key.RevokedAt = revokedAt
+		return putApiKey(tx, key)
+	})
+}
+
+func getJSON(tx *bolt.Tx, bucket []byte, id string, v interface{}) error {
+	data := tx.Bucket(bucket).Get([]byte(id))
+	if data == nil {
+		return db.ErrorNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func putJSON(tx *bolt.Tx, bucket []byte, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(id), data)
+}
+
+func (k *KvDb) CreateWebhook(hook db.Webhook) error {
+	return k.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(webhooksBucket).Get([]byte(hook.Id)) != nil {
+			return db.ErrAlreadyExists
+		}
+		return putJSON(tx, webhooksBucket, hook.Id, &hook)
+	})
+}
+
+func (k *KvDb) Webhook(id string) (*db.Webhook, error) {
+	res := &db.Webhook{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return getJSON(tx, webhooksBucket, id, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Webhooks lists webhooks ordered by id.
+func (k *KvDb) Webhooks() ([]*db.Webhook, error) {
+	res := []*db.Webhook{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(webhooksBucket).ForEach(func(_, v []byte) error {
+			hook := &db.Webhook{}
+			if err := json.Unmarshal(v, hook); err != nil {
+				return err
+			}
+			res = append(res, hook)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func (k *KvDb) DeleteWebhook(id string) error {
+	return k.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(webhooksBucket)
+		if b.Get([]byte(id)) == nil {
+			return db.ErrorNotFound
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		deliveries, err := listDeliveries(tx, id, "")
+		if err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			if err := tx.Bucket(deliveriesBucket).Delete([]byte(d.Id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (k *KvDb) CreateDelivery(d db.Delivery) error {
+	return k.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(webhooksBucket).Get([]byte(d.WebhookId)) == nil {
+			return db.ErrorNotFound
+		}
+		if tx.Bucket(deliveriesBucket).Get([]byte(d.Id)) != nil {
+			return db.ErrAlreadyExists
+		}
+		return putJSON(tx, deliveriesBucket, d.Id, &d)
+	})
+}
+
+func (k *KvDb) UpdateDelivery(d db.Delivery) error {
+	return k.update(func(tx *bolt.Tx) error {
+		stored := &db.Delivery{}
+		if err := getJSON(tx, deliveriesBucket, d.Id, stored); err != nil {
+			return err
+		}
+		stored.State = d.State
+		stored.Attempts = d.Attempts
+		stored.NextAttempt = d.NextAttempt
+		stored.LastStatus = d.LastStatus
+		stored.LastError = d.LastError
+		stored.DeliveredAt = d.DeliveredAt
+		return putJSON(tx, deliveriesBucket, d.Id, stored)
+	})
+}
+
+func (k *KvDb) Delivery(id string) (*db.Delivery, error) {
+	res := &db.Delivery{}
+	err := k.view(func(tx *bolt.Tx) error {
+		return getJSON(tx, deliveriesBucket, id, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (k *KvDb) Deliveries(webhookId string, state string) ([]*db.Delivery, error) {
+	var res []*db.Delivery
+	err := k.view(func(tx *bolt.Tx) error {
+		var err error
+		res, err = listDeliveries(tx, webhookId, state)
+		return err
+	})
+	return res, err
+}
+
+// listDeliveries returns the matching deliveries oldest first, like the
+// other backends.
+func listDeliveries(tx *bolt.Tx, webhookId string, state string) ([]*db.Delivery, error) {
+	res := []*db.Delivery{}
+	err := tx.Bucket(deliveriesBucket).ForEach(func(_, v []byte) error {
+		d := &db.Delivery{}
+		if err := json.Unmarshal(v, d); err != nil {
+			return err
+		}
+		if (webhookId == "" || d.WebhookId == webhookId) && (state == "" || d.State == state) {
+			res = append(res, d)
+		}
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res, err
+}
+
+// auditKey keeps audit entries ordered by Seq.
+func auditKey(seq int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(seq))
+	return k
+}
+
+func lastAuditEntry(tx *bolt.Tx) (*db.AuditEntry, error) {
+	_, v := tx.Bucket(auditBucket).Cursor().Last()
+	if v == nil {
+		return nil, db.ErrorNotFound
+	}
+	e := &db.AuditEntry{}
+	if err := json.Unmarshal(v, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (k *KvDb) AppendAuditEntry(e db.AuditEntry) error {
+	return k.update(func(tx *bolt.Tx) error {
+		var last int64
+		prev, err := lastAuditEntry(tx)
+		switch err {
+		case nil:
+			last = prev.Seq
+		case db.ErrorNotFound:
+		default:
+			return err
+		}
+		if e.Seq != last+1 {
+			return db.ErrAlreadyExists
+		}
+
+		v, err := json.Marshal(&e)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(auditBucket).Put(auditKey(e.Seq), v)
+	})
+}
+
+func (k *KvDb) LastAuditEntry() (*db.AuditEntry, error) {
+	var res *db.AuditEntry
+	err := k.view(func(tx *bolt.Tx) error {
+		var err error
+		res, err = lastAuditEntry(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (k *KvDb) AuditEntries(q db.AuditQuery) ([]*db.AuditEntry, error) {
+	var res []*db.AuditEntry
+	err := k.view(func(tx *bolt.Tx) error {
+		var err error
+		res, err = auditEntries(tx, q)
+		return err
+	})
+	return res, err
+}
+
+func auditEntries(tx *bolt.Tx, q db.AuditQuery) ([]*db.AuditEntry, error) {
+	res := []*db.AuditEntry{}
+	c := tx.Bucket(auditBucket).Cursor()
+	for key, v := c.Seek(auditKey(q.After + 1)); key != nil; key, v = c.Next() {
+		if q.Limit > 0 && len(res) == q.Limit {
+			break
+		}
+		e := &db.AuditEntry{}
+		if err := json.Unmarshal(v, e); err != nil {
+			return nil, err
+		}
+		if q.Matches(e) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,375 @@
+package kvdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"api/db"
+)
+
+func setupKvDb() (_ *KvDb, _ func(), rerr error) {
+	kv := &KvDb{}
+
+	tmpDir, err := ioutil.TempDir("", "kvdbTest")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	defer func() {
+		if rerr != nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	if err := kv.Create(path.Join(tmpDir, "testdb.kv")); err != nil {
+		return nil, func() {}, err
+	}
+
+	closer := func() {
+		kv.Stop()
+		os.RemoveAll(tmpDir)
+	}
+
+	return kv, closer, nil
+}
+
+func TestKvDb_Players(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if _, err := kv.PlayerPoints("P1"); err != db.ErrorNotFound {
+		t.Error(err)
+	}
+
+	if err := kv.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.CreatePlayer("P1", 100); err != db.ErrAlreadyExists {
+		t.Error(err)
+	}
+	if err := kv.CreatePlayer("P2", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.UpdatePlayer("P1", 50); err != nil {
+		t.Fatal(err)
+	}
+
+	players, err := kv.MultiplePlayerPoints([]string{"P1", "P2", "P3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 2 || players["P1"] != 50 || players["P2"] != 200 {
+		t.Error(players)
+	}
+}
+
+func TestKvDb_Tournaments(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	for _, id := range []int{42, -1, 7} {
+		if err := kv.CreateTournament(id, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.CreateTournament(42, 1000); err != db.ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if err := kv.JoinTournament(43, db.Entry{PlayerId: "P1"}); err != db.ErrorNotFound {
+		t.Error(err)
+	}
+	if err := kv.JoinTournament(42, db.Entry{PlayerId: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.JoinTournament(42, db.Entry{PlayerId: "P1"}); err != db.ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	tournament, err := kv.TournamentInfo(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tournament.Deposit != 1000 || len(tournament.Players) != 1 || tournament.Players[0] != "P1" {
+		t.Error(tournament)
+	}
+
+	all, err := kv.Tournaments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Id != -1 || all[1].Id != 7 || all[2].Id != 42 {
+		t.Error(all)
+	}
+}
+
+func TestKvDb_CopyFromMemDb(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	src := db.CreateMemDb()
+	if err := src.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CreateTournament(1, 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.JoinTournament(1, db.Entry{PlayerId: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Copy(kv, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if pts, err := kv.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error(pts, err)
+	}
+	tournament, err := kv.TournamentInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tournament.Players) != 1 || tournament.Players[0] != "P1" {
+		t.Error(tournament.Players)
+	}
+}
+
+func TestKvDb_MigrateLegacyPlayers(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	err = kv.conn.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tournamentsBucket)
+		if err := b.Put(tourKey(1), []byte(`{"Deposit":500,"Players":["P1","P2"]}`)); err != nil {
+			return err
+		}
+		// a tournament nobody joined is settled too
+		return b.Put(tourKey(2), []byte(`{"Deposit":300,"Players":null}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := kv.conn.db.Path()
+	kv.Stop()
+	if err := kv.Create(dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tournament, err := kv.TournamentInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tournament.Entries) != 2 || tournament.Entries[1].PlayerId != "P2" || tournament.Entries[1].Stake != 500 || tournament.SettledAt.IsZero() {
+		t.Error(tournament)
+	}
+	if empty, err := kv.TournamentInfo(2); err != nil || len(empty.Entries) != 0 || empty.SettledAt.IsZero() {
+		t.Error("empty legacy tournament not settled", empty, err)
+	}
+
+	// tournaments written since are left alone
+	if err := kv.CreateTournament(3, 100); err != nil {
+		t.Fatal(err)
+	}
+	kv.Stop()
+	if err := kv.Create(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if active, err := kv.TournamentInfo(3); err != nil || !active.SettledAt.IsZero() {
+		t.Error("new tournament settled", active, err)
+	}
+}
+
+func TestKvDb_BackupRestore(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if err := kv.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := kv.conn.db.Path() + ".backup"
+	defer os.Remove(backupPath)
+	if err := kv.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.UpdatePlayer("P1", 500); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Restore(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	if pts, err := kv.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error(pts, err)
+	}
+}
+
+func TestKvDb_CopySeasons(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	src := db.CreateMemDb()
+	if err := src.CreateTournament(1, 500); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CloseSeason(time.Now().UTC(), map[string]int{"P1": 100}, []db.Standing{{PlayerId: "P1", Rank: 1, Points: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CreateTournament(2, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Copy(kv, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := kv.CurrentSeason(); err != nil || s.Id != 2 {
+		t.Error(s, err)
+	}
+	if info, err := kv.TournamentInfo(1); err != nil || info.SeasonId != 1 {
+		t.Error(info, err)
+	}
+	if info, err := kv.TournamentInfo(2); err != nil || info.SeasonId != 2 {
+		t.Error(info, err)
+	}
+	if balances, err := kv.SeasonBalances(1); err != nil || balances["P1"] != 100 {
+		t.Error(balances, err)
+	}
+	if standings, err := kv.SeasonStandings(1); err != nil || len(standings) != 1 || standings[0].Points != 100 {
+		t.Error(standings, err)
+	}
+
+	if err := kv.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := kv.CurrentSeason(); err != nil || s.Id != 2 {
+		t.Error("reset restarted seasons", s, err)
+	}
+	if standings, err := kv.SeasonStandings(1); err != nil || len(standings) != 1 {
+		t.Error("archive did not survive reset", standings, err)
+	}
+	if _, err := kv.TournamentInfo(1); err != db.ErrorNotFound {
+		t.Error("tournament survived reset", err)
+	}
+}
+
+func TestKvDb_CopyWebhooks(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	now := time.Now().UTC()
+	src := db.CreateMemDb()
+	if err := src.CreateWebhook(db.Webhook{Id: "w1", URL: "http://partner/hook", Secret: "s1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CreateDelivery(db.Delivery{Id: "d1", WebhookId: "w1", State: db.DeliveryDead, Attempts: 8, CreatedAt: now, NextAttempt: now}); err != nil {
+		t.Fatal(err)
+	}
+	for seq := int64(1); seq <= 2; seq++ {
+		if err := src.AppendAuditEntry(db.AuditEntry{Seq: seq, At: now, KeyId: "k1", Endpoint: "/v2/webhooks"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Copy(kv, src); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err := kv.Webhook("w1"); err != nil || w.Secret != "s1" || !w.Wants("settled") {
+		t.Error(w, err)
+	}
+	dead, err := kv.Deliveries("w1", db.DeliveryDead)
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 8 {
+		t.Error(dead, err)
+	}
+
+	if audit, err := kv.AuditEntries(db.AuditQuery{After: 1}); err != nil || len(audit) != 1 || audit[0].Seq != 2 || !audit[0].At.Equal(now) {
+		t.Error(audit, err)
+	}
+	if err := kv.AppendAuditEntry(db.AuditEntry{Seq: 2, At: now}); err != db.ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if err := kv.DeleteWebhook("w1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Delivery("d1"); err != db.ErrorNotFound {
+		t.Error(err)
+	}
+}
+
+func TestKvDb_RestoreKeepsAuditLog(t *testing.T) {
+	kv, closer, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	entry := func(seq int64, hash string) db.AuditEntry {
+		return db.AuditEntry{Seq: seq, At: time.Now().UTC(), Method: "POST", Endpoint: "/v2/reset", Hash: hash}
+	}
+	if err := kv.AppendAuditEntry(entry(1, "h1")); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := kv.conn.db.Path() + ".backup"
+	defer os.Remove(backupPath)
+	if err := kv.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.AppendAuditEntry(entry(2, "h2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Restore(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := kv.AuditEntries(db.AuditQuery{})
+	if err != nil || len(entries) != 2 || entries[1].Hash != "h2" {
+		t.Fatal("entries recorded after the backup dropped", entries, err)
+	}
+
+	// the audit log of another database would rewrite the recorded calls
+	other, otherCloser, err := setupKvDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherCloser()
+	if err := other.AppendAuditEntry(entry(1, "other")); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := other.conn.db.Path() + ".backup"
+	defer os.Remove(otherPath)
+	if err := other.Backup(otherPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Restore(otherPath); err != db.ErrAuditMismatch {
+		t.Error(err)
+	}
+}
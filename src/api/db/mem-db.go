@@ -0,0 +1,583 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemDb keeps players and tournaments in process memory. It is meant for
+// embedding the service and for tests; nothing survives Stop.
+type MemDb struct {
+	mux sync.Mutex
+	memState
+}
+
+// memState is everything MemDb stores, Update snapshots it as a whole.
+type memState struct {
+	players         map[string]int
+	tournaments     map[int]*Tournament
+	seasons         []*Season
+	seasonBalances  map[int]map[string]int
+	seasonStandings map[int][]Standing
+	apiKeys         map[string]ApiKey
+	webhooks        map[string]Webhook
+	deliveries      map[string]Delivery
+	audit           []AuditEntry
+}
+
+func CreateMemDb() *MemDb {
+	m := &MemDb{}
+	m.reset()
+	return m
+}
+
+// reset drops the players and tournaments and keeps the seasons, API
+// keys, webhooks and audit log.
+func (m *MemDb) reset() {
+	seasons, balances, standings := m.seasons, m.seasonBalances, m.seasonStandings
+	keys, hooks, deliveries, audit := m.apiKeys, m.webhooks, m.deliveries, m.audit
+	if keys == nil {
+		seasons = []*Season{{Id: 1, StartedAt: time.Now().UTC()}}
+		balances = make(map[int]map[string]int)
+		standings = make(map[int][]Standing)
+		keys = make(map[string]ApiKey)
+		hooks = make(map[string]Webhook)
+		deliveries = make(map[string]Delivery)
+	}
+	m.memState = memState{
+		players:         make(map[string]int),
+		tournaments:     make(map[int]*Tournament),
+		seasons:         seasons,
+		seasonBalances:  balances,
+		seasonStandings: standings,
+		apiKeys:         keys,
+		webhooks:        hooks,
+		deliveries:      deliveries,
+		audit:           audit,
+	}
+}
+
+func (st *memState) clone() memState {
+	c := memState{
+		players:         make(map[string]int, len(st.players)),
+		tournaments:     make(map[int]*Tournament, len(st.tournaments)),
+		seasons:         make([]*Season, len(st.seasons)),
+		seasonBalances:  make(map[int]map[string]int, len(st.seasonBalances)),
+		seasonStandings: make(map[int][]Standing, len(st.seasonStandings)),
+		apiKeys:         make(map[string]ApiKey, len(st.apiKeys)),
+		webhooks:        make(map[string]Webhook, len(st.webhooks)),
+		deliveries:      make(map[string]Delivery, len(st.deliveries)),
+		audit:           append([]AuditEntry(nil), st.audit...),
+	}
+	for id, k := range st.apiKeys {
+		c.apiKeys[id] = k
+	}
+	for id, w := range st.webhooks {
+		c.webhooks[id] = w
+	}
+	for id, d := range st.deliveries {
+		c.deliveries[id] = d
+	}
+	for id, pts := range st.players {
+		c.players[id] = pts
+	}
+	for id, t := range st.tournaments {
+		c.tournaments[id] = cloneTournament(t)
+	}
+	for i, s := range st.seasons {
+		season := *s
+		c.seasons[i] = &season
+	}
+	for id, balances := range st.seasonBalances {
+		c.seasonBalances[id] = copyBalances(balances)
+	}
+	for id, standings := range st.seasonStandings {
+		c.seasonStandings[id] = append([]Standing(nil), standings...)
+	}
+	return c
+}
+
+func copyBalances(b map[string]int) map[string]int {
+	res := make(map[string]int, len(b))
+	for id, pts := range b {
+		res[id] = pts
+	}
+	return res
+}
+
+func (m *MemDb) PlayerPoints(playerId string) (int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	pts, ok := m.players[playerId]
+	if !ok {
+		return 0, ErrorNotFound
+	}
+	return pts, nil
+}
+
+func (m *MemDb) MultiplePlayerPoints(playerIds []string) (map[string]int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := make(map[string]int)
+	for _, id := range playerIds {
+		if pts, ok := m.players[id]; ok {
+			res[id] = pts
+		}
+	}
+	return res, nil
+}
+
+func (m *MemDb) UpdatePlayer(pid string, pts int) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// same as SQL UPDATE: unknown players are silently ignored
+	if _, ok := m.players[pid]; ok {
+		m.players[pid] = pts
+	}
+	return nil
+}
+
+func (m *MemDb) CreatePlayer(pid string, pts int) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.players[pid]; ok {
+		return ErrAlreadyExists
+	}
+	m.players[pid] = pts
+	return nil
+}
+
+func (m *MemDb) Players() (map[string]int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return copyBalances(m.players), nil
+}
+
+func (m *MemDb) CreateTournament(id int, deposit int) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.tournaments[id]; ok {
+		return ErrAlreadyExists
+	}
+	season := m.seasons[len(m.seasons)-1]
+	m.tournaments[id] = withEntries(Tournament{Id: id, Deposit: deposit, SeasonId: season.Id}, []Entry{})
+	return nil
+}
+
+func (m *MemDb) TournamentInfo(tourId int) (*Tournament, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	t, ok := m.tournaments[tourId]
+	if !ok {
+		return nil, ErrorNotFound
+	}
+
+	return cloneTournament(t), nil
+}
+
+func cloneTournament(t *Tournament) *Tournament {
+	entries := make([]Entry, len(t.Entries))
+	for i, e := range t.Entries {
+		entries[i] = e
+		entries[i].Backers = append([]Backing(nil), e.Backers...)
+	}
+	return withEntries(*t, entries)
+}
+
+func (m *MemDb) JoinTournament(tourId int, entry Entry) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	t, ok := m.tournaments[tourId]
+	if !ok {
+		return ErrorNotFound
+	}
+
+	for _, p := range t.Players {
+		if p == entry.PlayerId {
+			return ErrAlreadyExists
+		}
+	}
+	entry.Backers = append([]Backing(nil), entry.Backers...)
+	t.Entries = append(t.Entries, entry)
+	t.Players = append(t.Players, entry.PlayerId)
+	return nil
+}
+
+func (m *MemDb) SetEntryPosition(tourId int, playerId string, position int) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	t, ok := m.tournaments[tourId]
+	if !ok {
+		return ErrorNotFound
+	}
+
+	for i := range t.Entries {
+		if t.Entries[i].PlayerId == playerId {
+			t.Entries[i].Position = position
+			return nil
+		}
+	}
+	return ErrorNotFound
+}
+
+func (m *MemDb) SettleTournament(tourId int, settledAt time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	t, ok := m.tournaments[tourId]
+	if !ok {
+		return ErrorNotFound
+	}
+	if !t.SettledAt.IsZero() {
+		return ErrAlreadyExists
+	}
+	t.SettledAt = settledAt
+	return nil
+}
+
+func (m *MemDb) Tournaments() ([]*Tournament, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := make([]*Tournament, 0, len(m.tournaments))
+	for _, t := range m.tournaments {
+		res = append(res, cloneTournament(t))
+	}
+	sort.Sort(byTourId(res))
+	return res, nil
+}
+
+func (m *MemDb) Stats() (*Stats, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	var s Stats
+	for _, pts := range m.players {
+		s.Points += pts
+	}
+	for _, t := range m.tournaments {
+		s.AddTournament(t)
+	}
+	return &s, nil
+}
+
+type byTourId []*Tournament
+
+func (t byTourId) Len() int           { return len(t) }
+func (t byTourId) Less(i, j int) bool { return t[i].Id < t[j].Id }
+func (t byTourId) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+func (m *MemDb) CurrentSeason() (*Season, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	s := *m.seasons[len(m.seasons)-1]
+	return &s, nil
+}
+
+func (m *MemDb) Seasons() ([]*Season, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := make([]*Season, len(m.seasons))
+	for i, s := range m.seasons {
+		season := *s
+		res[i] = &season
+	}
+	return res, nil
+}
+
+func (m *MemDb) CloseSeason(closedAt time.Time, balances map[string]int, standings []Standing) (*Season, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	current := m.seasons[len(m.seasons)-1]
+	current.ClosedAt = closedAt
+	m.seasonBalances[current.Id] = copyBalances(balances)
+	m.seasonStandings[current.Id] = append([]Standing{}, standings...)
+
+	next := &Season{Id: current.Id + 1, StartedAt: closedAt}
+	m.seasons = append(m.seasons, next)
+
+	res := *next
+	return &res, nil
+}
+
+func (m *MemDb) SeasonBalances(seasonId int) (map[string]int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if seasonId < 1 || seasonId > len(m.seasons) {
+		return nil, ErrorNotFound
+	}
+	return copyBalances(m.seasonBalances[seasonId]), nil
+}
+
+func (m *MemDb) SeasonStandings(seasonId int) ([]Standing, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if seasonId < 1 || seasonId > len(m.seasons) {
+		return nil, ErrorNotFound
+	}
+	return append([]Standing{}, m.seasonStandings[seasonId]...), nil
+}
+
+// Update takes a snapshot before running fn and restores it if fn fails.
+// Unlike a SQLite transaction it does not isolate fn from concurrent
+// writers; the api package serializes access itself.
+func (m *MemDb) Update(fn func(s Storage) error) error {
+	m.mux.Lock()
+	snapshot := m.memState.clone()
+	m.mux.Unlock()
+
+	if err := fn(m); err != nil {
+		m.mux.Lock()
+		m.memState = snapshot
+		m.mux.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (m *MemDb) CreateApiKey(key ApiKey) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.apiKeys[key.Id]; ok {
+		return ErrAlreadyExists
+	}
+	m.apiKeys[key.Id] = key
+	return nil
+}
+
+func (m *MemDb) ApiKey(id string) (*ApiKey, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return nil, ErrorNotFound
+	}
+	return &k, nil
+}
+
+func (m *MemDb) ApiKeys() ([]*ApiKey, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := make([]*ApiKey, 0, len(m.apiKeys))
+	for _, k := range m.apiKeys {
+		key := k
+		res = append(res, &key)
+	}
+	sort.Sort(byCreatedAt(res))
+	return res, nil
+}
+
+type byCreatedAt []*ApiKey
+
+func (s byCreatedAt) Len() int      { return len(s) }
+func (s byCreatedAt) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreatedAt) Less(i, j int) bool {
+	if !s[i].CreatedAt.Equal(s[j].CreatedAt) {
+		return s[i].CreatedAt.Before(s[j].CreatedAt)
+	}
+	return s[i].Id < s[j].Id
+}
+
+func (m *MemDb) RevokeApiKey(id string, revokedAt time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return ErrorNotFound
+	}
+	if k.RevokedAt.IsZero() {
+		k.RevokedAt = revokedAt
+		m.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (m *MemDb) CreateWebhook(hook Webhook) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.webhooks[hook.Id]; ok {
+		return ErrAlreadyExists
+	}
+	hook.Events = append([]string(nil), hook.Events...)
+	m.webhooks[hook.Id] = hook
+	return nil
+}
+
+func (m *MemDb) Webhook(id string) (*Webhook, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrorNotFound
+	}
+	return &w, nil
+}
+
+func (m *MemDb) Webhooks() ([]*Webhook, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := make([]*Webhook, 0, len(m.webhooks))
+	for _, w := range m.webhooks {
+		hook := w
+		res = append(res, &hook)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res, nil
+}
+
+func (m *MemDb) DeleteWebhook(id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrorNotFound
+	}
+	delete(m.webhooks, id)
+	for did, d := range m.deliveries {
+		if d.WebhookId == id {
+			delete(m.deliveries, did)
+		}
+	}
+	return nil
+}
+
+func (m *MemDb) CreateDelivery(d Delivery) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.webhooks[d.WebhookId]; !ok {
+		return ErrorNotFound
+	}
+	if _, ok := m.deliveries[d.Id]; ok {
+		return ErrAlreadyExists
+	}
+	m.deliveries[d.Id] = d
+	return nil
+}
+
+func (m *MemDb) UpdateDelivery(d Delivery) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	stored, ok := m.deliveries[d.Id]
+	if !ok {
+		return ErrorNotFound
+	}
+	stored.State = d.State
+	stored.Attempts = d.Attempts
+	stored.NextAttempt = d.NextAttempt
+	stored.LastStatus = d.LastStatus
+	stored.LastError = d.LastError
+	stored.DeliveredAt = d.DeliveredAt
+	m.deliveries[d.Id] = stored
+	return nil
+}
+
+func (m *MemDb) Delivery(id string) (*Delivery, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	d, ok := m.deliveries[id]
+	if !ok {
+		return nil, ErrorNotFound
+	}
+	return &d, nil
+}
+
+func (m *MemDb) Deliveries(webhookId string, state string) ([]*Delivery, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := []*Delivery{}
+	for _, d := range m.deliveries {
+		if (webhookId == "" || d.WebhookId == webhookId) && (state == "" || d.State == state) {
+			delivery := d
+			res = append(res, &delivery)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res, nil
+}
+
+func (m *MemDb) AppendAuditEntry(e AuditEntry) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if e.Seq != int64(len(m.audit))+1 {
+		return ErrAlreadyExists
+	}
+	m.audit = append(m.audit, e)
+	return nil
+}
+
+func (m *MemDb) LastAuditEntry() (*AuditEntry, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if len(m.audit) == 0 {
+		return nil, ErrorNotFound
+	}
+	e := m.audit[len(m.audit)-1]
+	return &e, nil
+}
+
+func (m *MemDb) AuditEntries(q AuditQuery) ([]*AuditEntry, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	res := []*AuditEntry{}
+	for i := range m.audit {
+		if q.Limit > 0 && len(res) == q.Limit {
+			break
+		}
+		e := m.audit[i]
+		if e.Seq > q.After && q.Matches(&e) {
+			res = append(res, &e)
+		}
+	}
+	return res, nil
+}
+
+func (m *MemDb) Reset() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.reset()
+	return nil
+}
+
+func (m *MemDb) Stop() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemDb_JoinTournament(t *testing.T) {
+	m := CreateMemDb()
+
+	const tournamentId = 42
+	if err := m.CreateTournament(tournamentId, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateTournament(tournamentId, 1000); err != ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	if err := m.JoinTournament(tournamentId+1, Entry{PlayerId: "P1"}); err != ErrorNotFound {
+		t.Error(err)
+	}
+	if err := m.JoinTournament(tournamentId, Entry{PlayerId: "P1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.JoinTournament(tournamentId, Entry{PlayerId: "P1"}); err != ErrAlreadyExists {
+		t.Error(err)
+	}
+
+	tournament, err := m.TournamentInfo(tournamentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tournament.Players) != 1 || tournament.Players[0] != "P1" {
+		t.Error(tournament.Players)
+	}
+}
+
+func TestMemDb_UpdateRollback(t *testing.T) {
+	m := CreateMemDb()
+
+	if err := m.CreatePlayer("P1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	errAbort := errors.New("abort")
+	err := m.Update(func(s Storage) error {
+		if err := s.UpdatePlayer("P1", 50); err != nil {
+			return err
+		}
+		if err := s.CreatePlayer("P2", 100); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatal(err)
+	}
+
+	if pts, err := m.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error("P1 update was not rolled back", pts, err)
+	}
+	if _, err := m.PlayerPoints("P2"); err != ErrorNotFound {
+		t.Error("P2 creation was not rolled back", err)
+	}
+}
+
+func TestMemDb_CloseSeason(t *testing.T) {
+	m := CreateMemDb()
+
+	if err := m.CreateTournament(1, 100); err != nil {
+		t.Fatal(err)
+	}
+	next, err := m.CloseSeason(time.Now().UTC(), map[string]int{"P1": 300}, []Standing{{PlayerId: "P1", Rank: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Id != 2 {
+		t.Error(next)
+	}
+
+	if err := m.CreateTournament(2, 100); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := m.TournamentInfo(2); err != nil || info.SeasonId != 2 {
+		t.Error(info, err)
+	}
+	if balances, err := m.SeasonBalances(1); err != nil || balances["P1"] != 300 {
+		t.Error(balances, err)
+	}
+	if _, err := m.SeasonBalances(3); err != ErrorNotFound {
+		t.Error(err)
+	}
+
+	if err := m.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := m.CurrentSeason(); err != nil || s.Id != 2 {
+		t.Error("reset restarted seasons", s, err)
+	}
+	if balances, err := m.SeasonBalances(1); err != nil || balances["P1"] != 300 {
+		t.Error("archive did not survive reset", balances, err)
+	}
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	createSchemaVersionTable = "CREATE TABLE IF NOT EXISTS `SchemaVersion` (`Version` INTEGER NOT NULL UNIQUE, `Name` TEXT NOT NULL, `AppliedAt` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY(Version));"
+	selectSchemaVersionQuery = "SELECT COALESCE(MAX(Version), 0) FROM SchemaVersion"
+	insertSchemaVersionQuery = "INSERT INTO SchemaVersion (Version, Name) VALUES (?, ?)"
+	tableColumnsQuery        = "SELECT name FROM pragma_table_info(?)"
+)
+
+var ErrSchemaTooNew = errors.New("Database schema is newer than this binary supports")
+
+// migration upgrades the schema from version-1 to version. Migrations are
+// applied in order, each one in its own transaction together with the
+// SchemaVersion record, so a failed upgrade leaves the previous version intact.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "drop legacy Tournaments.Winners column, normalize Winners table", migrateWinners},
+	{3, "move Tournaments.Players into TournamentEntries", migrateEntries},
+	{4, "add Tournaments.SettledAt", migrateSettledAt},
+	{5, "add seasons and season archives", migrateSeasons},
+	{6, "add ApiKeys", migrateApiKeys},
+	{7, "add Webhooks and WebhookDeliveries", migrateWebhooks},
+	{8, "add AuditLog", migrateAuditLog},
+}
+
+// LatestSchemaVersion is the schema version Create upgrades databases to.
+var LatestSchemaVersion = migrations[len(migrations)-1].version
+
+// Versioned is implemented by storages with a versioned schema.
+type Versioned interface {
+	// SchemaVersion returns the version of the last applied migration,
+	// LatestSchemaVersion once the storage is upgraded.
+	SchemaVersion() (int, error)
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(tableColumnsQuery, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]bool)
+	var name string
+	for rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res[name] = true
+	}
+	return res, rows.Err()
+}
+
+// migrateInitialSchema creates the tables db.Create used to create before
+// versioning existed. Databases created by older builds already have them.
+func migrateInitialSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		"CREATE TABLE IF NOT EXISTS `Tournaments` (`TourId` INTEGER NOT NULL UNIQUE, `Deposit` INTEGER NOT NULL, `Players` TEXT, PRIMARY KEY(TourId));",
+		"CREATE TABLE IF NOT EXISTS `Players` (`PlayerId` TEXT NOT NULL UNIQUE, `Points` INTEGER, PRIMARY KEY(PlayerId));",
+	)
+}
+
+// migrateWinners converges databases created from the hand-made
+// back-a-friend.db, whose Tournaments table carries an unused Winners column
+// and whose Winners table uses dashed column names.
+func migrateWinners(tx *sql.Tx) error {
+	cols, err := tableColumns(tx, "Tournaments")
+	if err != nil {
+		return err
+	}
+	if cols["Winners"] {
+		err := execAll(tx,
+			"CREATE TABLE `Tournaments_new` (`TourId` INTEGER NOT NULL UNIQUE, `Deposit` INTEGER NOT NULL, `Players` TEXT, PRIMARY KEY(TourId));",
+			"INSERT INTO Tournaments_new (TourId, Deposit, Players) SELECT TourId, Deposit, COALESCE(Players, '') FROM Tournaments;",
+			"DROP TABLE Tournaments;",
+			"ALTER TABLE Tournaments_new RENAME TO Tournaments;",
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	cols, err = tableColumns(tx, "Winners")
+	if err != nil {
+		return err
+	}
+	if cols["Player-id"] {
+		if err := execAll(tx, "ALTER TABLE Winners RENAME TO Winners_legacy;"); err != nil {
+			return err
+		}
+	}
+
+	if err := execAll(tx, "CREATE TABLE IF NOT EXISTS `Winners` (`TourId` INTEGER NOT NULL, `PlayerId` TEXT NOT NULL, `Prize` INTEGER NOT NULL, PRIMARY KEY(TourId, PlayerId));"); err != nil {
+		return err
+	}
+
+	if cols["Player-id"] {
+		return execAll(tx,
+			"INSERT OR IGNORE INTO Winners (TourId, PlayerId, Prize) SELECT TourId, `Player-id`, Prize FROM Winners_legacy;",
+			"DROP TABLE Winners_legacy;",
+		)
+	}
+	return nil
+}
+
+// migrateEntries replaces the comma-joined Tournaments.Players column with
+// one TournamentEntries row per player. The join time and backers of
+// existing entries are unknown: they are recorded as joined at migration
+// time with the whole deposit as their own stake.
+func migrateEntries(tx *sql.Tx) error {
+	err := execAll(tx,
+		"CREATE TABLE `TournamentEntries` (`TourId` INTEGER NOT NULL, `PlayerId` TEXT NOT NULL, `JoinedAt` DATETIME NOT NULL, `Stake` INTEGER NOT NULL, `Position` INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(TourId, PlayerId));",
+		"CREATE TABLE `EntryBackers` (`TourId` INTEGER NOT NULL, `PlayerId` TEXT NOT NULL, `BackerId` TEXT NOT NULL, `Stake` INTEGER NOT NULL, PRIMARY KEY(TourId, PlayerId, BackerId));",
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT TourId, Deposit, COALESCE(Players, '') FROM Tournaments ORDER BY TourId")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type legacyTournament struct {
+		id, deposit int
+		players     string
+	}
+	legacy := []legacyTournament{}
+	for rows.Next() {
+		var t legacyTournament
+		if err := rows.Scan(&t.id, &t.deposit, &t.players); err != nil {
+			return err
+		}
+		legacy = append(legacy, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	for _, t := range legacy {
+		if t.players == "" {
+			continue
+		}
+		for _, p := range strings.Split(t.players, ",") {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO TournamentEntries (TourId, PlayerId, JoinedAt, Stake) VALUES (?, ?, ?, ?)", t.id, p, now, t.deposit); err != nil {
+				return err
+			}
+		}
+	}
+
+	return execAll(tx,
+		"CREATE TABLE `Tournaments_new` (`TourId` INTEGER NOT NULL UNIQUE, `Deposit` INTEGER NOT NULL, PRIMARY KEY(TourId));",
+		"INSERT INTO Tournaments_new (TourId, Deposit) SELECT TourId, Deposit FROM Tournaments;",
+		"DROP TABLE Tournaments;",
+		"ALTER TABLE Tournaments_new RENAME TO Tournaments;",
+	)
+}
+
+// migrateSettledAt records which tournaments are finished. The running
+// tournament used to live only in server memory, so existing tournaments
+// are all considered settled.
+func migrateSettledAt(tx *sql.Tx) error {
+	if err := execAll(tx, "ALTER TABLE Tournaments ADD COLUMN `SettledAt` DATETIME;"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE Tournaments SET SettledAt = ?", time.Now().UTC())
+	return err
+}
+
+// migrateSeasons puts all existing data into season 1.
+func migrateSeasons(tx *sql.Tx) error {
+	err := execAll(tx,
+		"CREATE TABLE `Seasons` (`SeasonId` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, `StartedAt` DATETIME NOT NULL, `ClosedAt` DATETIME);",
+		"CREATE TABLE `SeasonBalances` (`SeasonId` INTEGER NOT NULL, `PlayerId` TEXT NOT NULL, `Points` INTEGER NOT NULL, PRIMARY KEY(SeasonId, PlayerId));",
+		"CREATE TABLE `SeasonStandings` (`SeasonId` INTEGER NOT NULL, `PlayerId` TEXT NOT NULL, `Rank` INTEGER NOT NULL, `Points` INTEGER NOT NULL, `Played` INTEGER NOT NULL, `Won` INTEGER NOT NULL, PRIMARY KEY(SeasonId, PlayerId));",
+		"ALTER TABLE Tournaments ADD COLUMN `SeasonId` INTEGER NOT NULL DEFAULT 1;",
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(insertFirstSeasonQuery, time.Now().UTC())
+	return err
+}
+
+func migrateApiKeys(tx *sql.Tx) error {
+	return execAll(tx, "CREATE TABLE `ApiKeys` (`KeyId` TEXT NOT NULL PRIMARY KEY, `Hash` TEXT NOT NULL, `Role` TEXT NOT NULL, `CreatedAt` DATETIME NOT NULL, `RevokedAt` DATETIME);")
+}
+
+func migrateWebhooks(tx *sql.Tx) error {
+	return execAll(tx,
+		"CREATE TABLE `Webhooks` (`WebhookId` TEXT NOT NULL PRIMARY KEY, `URL` TEXT NOT NULL, `Secret` TEXT NOT NULL, `Events` TEXT NOT NULL, `CreatedAt` DATETIME NOT NULL);",
+		"CREATE TABLE `WebhookDeliveries` (`DeliveryId` TEXT NOT NULL PRIMARY KEY, `WebhookId` TEXT NOT NULL, `EventType` TEXT NOT NULL, `Payload` TEXT NOT NULL, `State` TEXT NOT NULL, `Attempts` INTEGER NOT NULL, `NextAttempt` DATETIME NOT NULL, `LastStatus` INTEGER NOT NULL, `LastError` TEXT NOT NULL, `CreatedAt` DATETIME NOT NULL, `DeliveredAt` DATETIME);",
+		"CREATE INDEX `WebhookDeliveriesByState` ON `WebhookDeliveries` (`State`, `NextAttempt`);",
+	)
+}
+
+// migrateAuditLog creates the audit log. Triggers reject changing or
+// deleting entries, so only appending works through SQL.
+func migrateAuditLog(tx *sql.Tx) error {
+	return execAll(tx,
+		"CREATE TABLE `AuditLog` (`Seq` INTEGER NOT NULL PRIMARY KEY, `At` DATETIME NOT NULL, `Role` TEXT NOT NULL, `KeyId` TEXT NOT NULL, `PlayerId` TEXT NOT NULL, `Addr` TEXT NOT NULL, `Method` TEXT NOT NULL, `Endpoint` TEXT NOT NULL, `Query` TEXT NOT NULL, `Body` TEXT NOT NULL, `Status` INTEGER NOT NULL, `Outcome` TEXT NOT NULL, `PrevHash` TEXT NOT NULL, `Hash` TEXT NOT NULL);",
+		"CREATE TRIGGER `AuditLogNoUpdate` BEFORE UPDATE ON `AuditLog` BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;",
+		"CREATE TRIGGER `AuditLogNoDelete` BEFORE DELETE ON `AuditLog` BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;",
+	)
+}
+
+// SchemaVersion returns the version of the last applied migration.
+func (d *Db) SchemaVersion() (int, error) {
+	d.conn.mux.RLock()
+	defer d.conn.mux.RUnlock()
+	return d.schemaVersion()
+}
+
+func (d *Db) schemaVersion() (int, error) {
+	var v int
+	err := d.conn.db.QueryRow(selectSchemaVersionQuery).Scan(&v)
+	return v, err
+}
+
+func (d *Db) migrate() error {
+	if _, err := d.conn.db.Exec(createSchemaVersionTable); err != nil {
+		return err
+	}
+
+	current, err := d.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion {
+		return ErrSchemaTooNew
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Db) applyMigration(m migration) (rerr error) {
+	tx, err := d.conn.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(insertSchemaVersionQuery, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
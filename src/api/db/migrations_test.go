@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// legacySchema is the layout of the committed db/back-a-friend.db.
+var legacySchema = []string{
+	"CREATE TABLE `Tournaments` (`TourId` INTEGER NOT NULL UNIQUE, `Winners` TEXT, `Deposit` INTEGER NOT NULL, `Players` TEXT, PRIMARY KEY(TourId));",
+	"CREATE TABLE `Winners` (`Winner-id` INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT UNIQUE, `TourId` INTEGER NOT NULL, `Player-id` TEXT NOT NULL, `Prize` INTEGER NOT NULL);",
+	"CREATE TABLE `Players` (`PlayerId` TEXT NOT NULL UNIQUE, `Points` INTEGER, PRIMARY KEY(PlayerId));",
+	"INSERT INTO Tournaments VALUES (1, '', 1000, 'P1,P2');",
+	"INSERT INTO Winners (TourId, `Player-id`, Prize) VALUES (1, 'P1', 2000);",
+	"INSERT INTO Players VALUES ('P1', 2500);",
+}
+
+func TestDb_MigrateLegacySchema(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "dbTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := path.Join(tmpDir, "legacy.db")
+	raw, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range legacySchema {
+		if _, err := raw.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw.Close()
+
+	myDb := &Db{}
+	if err := myDb.Create(dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := myDb.SchemaVersion(); err != nil || v != LatestSchemaVersion {
+		t.Error("wrong schema version", v, err)
+	}
+
+	tournament, err := myDb.TournamentInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tournament.Deposit != 1000 || len(tournament.Players) != 2 {
+		t.Error(tournament)
+	}
+	for _, e := range tournament.Entries {
+		if e.Stake != 1000 || e.JoinedAt.IsZero() {
+			t.Error("wrong migrated entry", e)
+		}
+	}
+
+	if pts, err := myDb.PlayerPoints("P1"); err != nil || pts != 2500 {
+		t.Error(pts, err)
+	}
+
+	var prize int
+	if err := myDb.conn.db.QueryRow("select Prize from Winners where TourId=1 and PlayerId='P1'").Scan(&prize); err != nil || prize != 2000 {
+		t.Error("legacy winner was not migrated", prize, err)
+	}
+	myDb.Stop()
+
+	// opening an up to date database is a no-op
+	if err := myDb.Create(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	defer myDb.Stop()
+
+	if err := myDb.CreateTournament(2, 500); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDb_MigrateSchemaTooNew(t *testing.T) {
+	myDb, closer, err := setupMyDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if _, err := myDb.conn.db.Exec(insertSchemaVersionQuery, LatestSchemaVersion+1, "from the future"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := myDb.migrate(); err != ErrSchemaTooNew {
+		t.Error(err)
+	}
+}
@@ -4,16 +4,17 @@ import "strings"
 
 const (
 	playerPtsGetQuery = "Select Points from Players Where PlayerId = ?"
-	playerCreateQuery = "Insert into Players values (?, ?)"
+	playerCreateQuery = "Insert into Players (PlayerId, Points) values (?, ?)"
 	playerUpdateQuery = "Update Players SET Points = ? WHERE PlayerId = ?"
+	playersAllQuery   = "Select PlayerId, Points from Players"
 )
 
 func getMultiplePlayersQuery(ids []string) string {
-	return "select * from Players where PlayerId in (?" + strings.Repeat(",?", len(ids)-1) + ") order by PlayerId"
+	return "select PlayerId, Points from Players where PlayerId in (?" + strings.Repeat(",?", len(ids)-1) + ") order by PlayerId"
 }
 
 func (d *Db) PlayerPoints(playerId string) (_ int, rerr error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return 0, err
 	}
@@ -27,6 +28,7 @@ func (d *Db) PlayerPoints(playerId string) (_ int, rerr error) {
 	if err != nil {
 		return 0, err
 	}
+	defer rows.Close()
 
 	var pts int
 	if !rows.Next() {
@@ -41,32 +43,51 @@ func (d *Db) PlayerPoints(playerId string) (_ int, rerr error) {
 }
 
 func (d *Db) MultiplePlayerPoints(playerIds []string) (_ map[string]int, rerr error) {
+	res := make(map[string]int)
+	if len(playerIds) == 0 {
+		return res, nil
+	}
+
 	qry := getMultiplePlayersQuery(playerIds)
 	args := []interface{}{}
 	for _, id := range playerIds {
 		args = append(args, id)
 	}
 
-	rows, err := d.db.Query(qry, args...)
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(qry, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var pid string
 	var pts int
-	res := make(map[string]int)
 	for rows.Next() {
 		if err := rows.Scan(&pid, &pts); err != nil {
 			return nil, err
 		}
 		res[pid] = pts
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-	return res, nil
+	return res, tx.Commit()
 }
 
 func (d *Db) UpdatePlayer(pid string, pts int) (rerr error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return err
 	}
@@ -77,36 +98,60 @@ func (d *Db) UpdatePlayer(pid string, pts int) (rerr error) {
 		}
 	}()
 
-	stmt, err := tx.Prepare(playerUpdateQuery)
+	if _, err := tx.Exec(playerUpdateQuery, pts, pid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Db) CreatePlayer(pid string, pts int) (rerr error) {
+	tx, err := d.begin()
 	if err != nil {
 		return err
 	}
 
-	if _, err := stmt.Exec(pts, pid); err != nil {
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.Exec(playerCreateQuery, pid, pts); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func (d *Db) CreatePlayer(pid string, pts int) (rerr error) {
-	tx, err := d.db.Begin()
+func (d *Db) Players() (_ map[string]int, rerr error) {
+	tx, err := d.begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	defer func() {
 		if rerr != nil {
 			tx.Rollback()
 		}
 	}()
 
-	stmt, err := tx.Prepare(playerCreateQuery)
+	rows, err := tx.Query(playersAllQuery)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if _, err := stmt.Exec(pid, pts); err != nil {
-		return err
+	var pid string
+	var pts int
+	res := make(map[string]int)
+	for rows.Next() {
+		if err := rows.Scan(&pid, &pts); err != nil {
+			return nil, err
+		}
+		res[pid] = pts
 	}
-	return tx.Commit()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
 }
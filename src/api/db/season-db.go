@@ -0,0 +1,246 @@
+package db
+
+import (
+	"time"
+)
+
+const (
+	insertFirstSeasonQuery     = "insert into Seasons (SeasonId, StartedAt) values (1, ?)"
+	selectCurrentSeasonQuery   = "select SeasonId, StartedAt, ClosedAt from Seasons where ClosedAt is null"
+	selectSeasonsQuery         = "select SeasonId, StartedAt, ClosedAt from Seasons order by SeasonId"
+	closeSeasonQuery           = "update Seasons set ClosedAt=? where SeasonId=?"
+	startSeasonQuery           = "insert into Seasons (StartedAt) values (?)"
+	insertSeasonBalanceQuery   = "insert into SeasonBalances (SeasonId, PlayerId, Points) values (?, ?, ?)"
+	insertSeasonStandingQuery  = "insert into SeasonStandings (SeasonId, PlayerId, Rank, Points, Played, Won) values (?, ?, ?, ?, ?, ?)"
+	selectSeasonBalancesQuery  = "select PlayerId, Points from SeasonBalances where SeasonId=?"
+	selectSeasonStandingsQuery = "select PlayerId, Rank, Points, Played, Won from SeasonStandings where SeasonId=? order by Rank, PlayerId"
+	selectSeasonExistsQuery    = "select 1 from Seasons where SeasonId=?"
+)
+
+type Season struct {
+	Id        int
+	StartedAt time.Time
+	ClosedAt  time.Time // zero for the current season
+}
+
+// Standing is a player's place in a season leaderboard.
+type Standing struct {
+	PlayerId string
+	Rank     int
+	Points   int
+	Played   int // tournaments entered
+	Won      int // tournaments finished in first position
+}
+
+func scanSeason(scan func(dest ...interface{}) error) (*Season, error) {
+	var s Season
+	var closedAt *time.Time
+	if err := scan(&s.Id, &s.StartedAt, &closedAt); err != nil {
+		return nil, err
+	}
+	s.ClosedAt = settledTime(closedAt)
+	return &s, nil
+}
+
+func (d *Db) CurrentSeason() (_ *Season, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectCurrentSeasonQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorNotFound
+	}
+	s, err := scanSeason(rows.Scan)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return s, tx.Commit()
+}
+
+func (d *Db) Seasons() (_ []*Season, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectSeasonsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Season{}
+	for rows.Next() {
+		s, err := scanSeason(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
+
+func (d *Db) CloseSeason(closedAt time.Time, balances map[string]int, standings []Standing) (_ *Season, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectCurrentSeasonQuery)
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		rows.Close()
+		return nil, ErrorNotFound
+	}
+	current, err := scanSeason(rows.Scan)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, pts := range balances {
+		if _, err := tx.Exec(insertSeasonBalanceQuery, current.Id, id, pts); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range standings {
+		if _, err := tx.Exec(insertSeasonStandingQuery, current.Id, s.PlayerId, s.Rank, s.Points, s.Played, s.Won); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(closeSeasonQuery, closedAt, current.Id); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(startSeasonQuery, closedAt)
+	if err != nil {
+		return nil, err
+	}
+	next, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Season{Id: int(next), StartedAt: closedAt}, tx.Commit()
+}
+
+func seasonExists(tx dbTx, seasonId int) (bool, error) {
+	rows, err := tx.Query(selectSeasonExistsQuery, seasonId)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// SeasonBalances returns the balances archived when the season was closed.
+func (d *Db) SeasonBalances(seasonId int) (_ map[string]int, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if ok, err := seasonExists(tx, seasonId); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrorNotFound
+	}
+
+	rows, err := tx.Query(selectSeasonBalancesQuery, seasonId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pid string
+	var pts int
+	res := make(map[string]int)
+	for rows.Next() {
+		if err := rows.Scan(&pid, &pts); err != nil {
+			return nil, err
+		}
+		res[pid] = pts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
+
+// SeasonStandings returns the leaderboard archived when the season was closed.
+func (d *Db) SeasonStandings(seasonId int) (_ []Standing, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if ok, err := seasonExists(tx, seasonId); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrorNotFound
+	}
+
+	rows, err := tx.Query(selectSeasonStandingsQuery, seasonId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Standing{}
+	for rows.Next() {
+		var s Standing
+		if err := rows.Scan(&s.PlayerId, &s.Rank, &s.Points, &s.Played, &s.Won); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,65 @@
+package db
+
+const selectStatsQuery = `select
+	(select count(*) from Tournaments where SettledAt is null),
+	(select coalesce(sum(Points), 0) from Players),
+	(select coalesce(sum(e.Stake), 0) from TournamentEntries e join Tournaments t on t.TourId = e.TourId where t.SettledAt is null),
+	(select coalesce(sum(b.Stake), 0) from EntryBackers b join Tournaments t on t.TourId = b.TourId where t.SettledAt is null),
+	(select count(*) from TournamentEntries),
+	(select count(*) from TournamentEntries e where exists (select 1 from EntryBackers b where b.TourId = e.TourId and b.PlayerId = e.PlayerId))`
+
+// Stats are totals of the stored data, for monitoring.
+type Stats struct {
+	ActiveTournaments int
+	// Points are the balances of all players and the stakes held by
+	// tournaments not settled yet.
+	Points            int
+	BackedEntries     int
+	SelfFundedEntries int
+}
+
+// AddTournament counts the entries of t and, unless it is settled, t
+// itself and its stakes.
+func (s *Stats) AddTournament(t *Tournament) {
+	settled := !t.SettledAt.IsZero()
+	if !settled {
+		s.ActiveTournaments++
+	}
+	for _, e := range t.Entries {
+		if len(e.Backers) == 0 {
+			s.SelfFundedEntries++
+		} else {
+			s.BackedEntries++
+		}
+		if settled {
+			continue
+		}
+		s.Points += e.Stake
+		for _, b := range e.Backers {
+			s.Points += b.Stake
+		}
+	}
+}
+
+// Stats sums up the tables in a single query.
+func (d *Db) Stats() (_ *Stats, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var s Stats
+	var balances, stakes, backerStakes, entries int
+	err = tx.QueryRow(selectStatsQuery).Scan(&s.ActiveTournaments, &balances, &stakes, &backerStakes, &entries, &s.BackedEntries)
+	if err != nil {
+		return nil, err
+	}
+	s.Points = balances + stakes + backerStakes
+	s.SelfFundedEntries = entries - s.BackedEntries
+	return &s, tx.Commit()
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Storage is the persistence layer used by the api package.
+// Db (SQLite), MemDb (in-memory) and kvdb.KvDb (bbolt) are the available
+// implementations.
+type Storage interface {
+	PlayerPoints(playerId string) (int, error)
+	MultiplePlayerPoints(playerIds []string) (map[string]int, error)
+	UpdatePlayer(pid string, pts int) error
+	CreatePlayer(pid string, pts int) error
+	Players() (map[string]int, error)
+
+	CreateTournament(id int, deposit int) error
+	TournamentInfo(tourId int) (*Tournament, error)
+	JoinTournament(tourId int, entry Entry) error
+	SetEntryPosition(tourId int, playerId string, position int) error
+	SettleTournament(tourId int, settledAt time.Time) error
+	Tournaments() ([]*Tournament, error)
+	// Stats sums up players and tournaments without loading them where
+	// the storage can.
+	Stats() (*Stats, error)
+
+	CurrentSeason() (*Season, error)
+	Seasons() ([]*Season, error)
+	// CloseSeason archives balances and standings of the current season,
+	// closes it and starts the next one, which it returns.
+	CloseSeason(closedAt time.Time, balances map[string]int, standings []Standing) (*Season, error)
+	SeasonBalances(seasonId int) (map[string]int, error)
+	SeasonStandings(seasonId int) ([]Standing, error)
+
+	CreateApiKey(key ApiKey) error
+	ApiKey(id string) (*ApiKey, error)
+	ApiKeys() ([]*ApiKey, error)
+	RevokeApiKey(id string, revokedAt time.Time) error
+
+	CreateWebhook(hook Webhook) error
+	Webhook(id string) (*Webhook, error)
+	Webhooks() ([]*Webhook, error)
+	DeleteWebhook(id string) error
+	// CreateDelivery queues a delivery for an existing webhook.
+	CreateDelivery(d Delivery) error
+	UpdateDelivery(d Delivery) error
+	Delivery(id string) (*Delivery, error)
+	Deliveries(webhookId string, state string) ([]*Delivery, error)
+
+	// AppendAuditEntry adds an entry at the end of the audit log, which is
+	// never changed otherwise.
+	AppendAuditEntry(e AuditEntry) error
+	LastAuditEntry() (*AuditEntry, error)
+	AuditEntries(q AuditQuery) ([]*AuditEntry, error)
+
+	// Update runs fn in a single transaction: every change fn makes through
+	// the given Storage is applied if fn returns nil and discarded otherwise.
+	Update(fn func(s Storage) error) error
+
+	// Reset deletes players and tournaments. Seasons with their archived
+	// balances and standings, API keys, webhooks and the audit log are
+	// kept.
+	Reset() error
+	Stop() error
+}
+
+// Contexter is implemented by storages that trace the requests they are
+// used for.
+type Contexter interface {
+	// WithContext returns the storage bound to the request of ctx; it
+	// shares the data with the receiver.
+	WithContext(ctx context.Context) Storage
+}
+
+// WithContext binds s to the request of ctx if s supports it and returns
+// s otherwise.
+func WithContext(s Storage, ctx context.Context) Storage {
+	if c, ok := s.(Contexter); ok {
+		return c.WithContext(ctx)
+	}
+	return s
+}
+
+// Copy writes every player, tournament, season archive, API key, webhook,
+// delivery and audit entry of src into an empty dst in a single
+// transaction of dst. It is used to move data between backends.
+func Copy(dst, src Storage) error {
+	players, err := src.Players()
+	if err != nil {
+		return err
+	}
+
+	tournaments, err := src.Tournaments()
+	if err != nil {
+		return err
+	}
+
+	seasons, err := src.Seasons()
+	if err != nil {
+		return err
+	}
+
+	keys, err := src.ApiKeys()
+	if err != nil {
+		return err
+	}
+
+	hooks, err := src.Webhooks()
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := src.Deliveries("", "")
+	if err != nil {
+		return err
+	}
+
+	audit, err := src.AuditEntries(AuditQuery{})
+	if err != nil {
+		return err
+	}
+
+	return dst.Update(func(s Storage) error {
+		for _, k := range keys {
+			if err := s.CreateApiKey(*k); err != nil {
+				return err
+			}
+			if !k.RevokedAt.IsZero() {
+				if err := s.RevokeApiKey(k.Id, k.RevokedAt); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, w := range hooks {
+			if err := s.CreateWebhook(*w); err != nil {
+				return err
+			}
+		}
+		for _, d := range deliveries {
+			if err := s.CreateDelivery(*d); err != nil {
+				return err
+			}
+		}
+		for _, e := range audit {
+			if err := s.AppendAuditEntry(*e); err != nil {
+				return err
+			}
+		}
+
+		for id, pts := range players {
+			if err := s.CreatePlayer(id, pts); err != nil {
+				return err
+			}
+		}
+
+		// replay seasons in order: tournaments are created in the current
+		// season of dst, closing it moves on to the next one
+		for _, season := range seasons {
+			for _, t := range tournaments {
+				if t.SeasonId != season.Id {
+					continue
+				}
+				if err := copyTournament(s, t); err != nil {
+					return err
+				}
+			}
+
+			if season.ClosedAt.IsZero() {
+				continue
+			}
+
+			balances, err := src.SeasonBalances(season.Id)
+			if err != nil {
+				return err
+			}
+			standings, err := src.SeasonStandings(season.Id)
+			if err != nil {
+				return err
+			}
+			if _, err := s.CloseSeason(season.ClosedAt, balances, standings); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func copyTournament(s Storage, t *Tournament) error {
+	if err := s.CreateTournament(t.Id, t.Deposit); err != nil {
+		return err
+	}
+	for _, e := range t.Entries {
+		if err := s.JoinTournament(t.Id, e); err != nil {
+			return err
+		}
+	}
+	if !t.SettledAt.IsZero() {
+		return s.SettleTournament(t.Id, t.SettledAt)
+	}
+	return nil
+}
@@ -1,24 +1,65 @@
 package db
 
 import (
-	"strings"
+	"time"
 )
 
 const (
-	announceTournamentQuery      = "insert into Tournaments values (?, ?, '')"
-	selectPlayersTournamentQuery = "select Players from Tournaments where TourId=?"
-	selectTournamentQuery        = "select * from Tournaments where TourId=?"
-	updateTournamentPlayersQuery = "update Tournaments set Players=? where TourId = ?"
+	announceTournamentQuery   = "insert into Tournaments (TourId, Deposit, SeasonId) values (?, ?, (select SeasonId from Seasons where ClosedAt is null))"
+	selectTournamentQuery     = "select TourId, Deposit, SeasonId, SettledAt from Tournaments where TourId=?"
+	selectAllTournamentsQuery = "select TourId, Deposit, SeasonId, SettledAt from Tournaments order by TourId"
+	settleTournamentQuery     = "update Tournaments set SettledAt=? where TourId=? and SettledAt is null"
+	selectEntryQuery          = "select 1 from TournamentEntries where TourId=? and PlayerId=?"
+	selectEntriesQuery        = "select PlayerId, JoinedAt, Stake, Position from TournamentEntries where TourId=? order by rowid"
+	selectBackersQuery        = "select PlayerId, BackerId, Stake from EntryBackers where TourId=? order by rowid"
+	insertEntryQuery          = "insert into TournamentEntries (TourId, PlayerId, JoinedAt, Stake, Position) values (?, ?, ?, ?, ?)"
+	insertBackerQuery         = "insert into EntryBackers (TourId, PlayerId, BackerId, Stake) values (?, ?, ?, ?)"
+	updateEntryPositionQuery  = "update TournamentEntries set Position=? where TourId=? and PlayerId=?"
 )
 
 type Tournament struct {
-	Id      int
-	Deposit int
-	Players []string
+	Id        int
+	Deposit   int
+	SeasonId  int
+	Players   []string // ids of Entries, in join order
+	Entries   []Entry
+	SettledAt time.Time // zero while the tournament is running
+}
+
+// Entry is a player's participation in a tournament.
+type Entry struct {
+	PlayerId string
+	JoinedAt time.Time
+	Stake    int // points paid by the player itself
+	Position int // finishing position, 0 until the tournament is settled
+	Backers  []Backing
+}
+
+// Backing is the part of an entry's deposit paid by another player.
+type Backing struct {
+	BackerId string
+	Stake    int
+}
+
+// withEntries returns a copy of t holding entries and their player ids.
+func withEntries(t Tournament, entries []Entry) *Tournament {
+	t.Players = make([]string, 0, len(entries))
+	for _, e := range entries {
+		t.Players = append(t.Players, e.PlayerId)
+	}
+	t.Entries = entries
+	return &t
+}
+
+func settledTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
 }
 
 func (d *Db) CreateTournament(id int, deposit int) (rerr error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return err
 	}
@@ -29,23 +70,56 @@ func (d *Db) CreateTournament(id int, deposit int) (rerr error) {
 		}
 	}()
 
-	stmt, err := tx.Prepare(announceTournamentQuery)
-	if err != nil {
+	if _, err := tx.Exec(announceTournamentQuery, id, deposit); err != nil {
 		return err
 	}
 
-	defer stmt.Close()
+	return tx.Commit()
+}
 
-	_, err = stmt.Exec(id, deposit)
+func loadEntries(tx dbTx, tourId int) ([]Entry, error) {
+	rows, err := tx.Query(selectEntriesQuery, tourId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return tx.Commit()
+	entries := []Entry{}
+	idx := make(map[string]int)
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.PlayerId, &e.JoinedAt, &e.Stake, &e.Position); err != nil {
+			return nil, err
+		}
+		idx[e.PlayerId] = len(entries)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = tx.Query(selectBackersQuery, tourId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pid string
+	var b Backing
+	for rows.Next() {
+		if err := rows.Scan(&pid, &b.BackerId, &b.Stake); err != nil {
+			return nil, err
+		}
+		if i, ok := idx[pid]; ok {
+			entries[i].Backers = append(entries[i].Backers, b)
+		}
+	}
+	return entries, rows.Err()
 }
 
 func (d *Db) TournamentInfo(tourId int) (_ *Tournament, rerr error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return nil, err
 	}
@@ -60,28 +134,30 @@ func (d *Db) TournamentInfo(tourId int) (_ *Tournament, rerr error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	if !rows.Next() {
 		return nil, ErrorNotFound
 	}
 
-	var id, depo int
-	var p string
-	if err := rows.Scan(&id, &depo, &p); err != nil {
+	var t Tournament
+	var settledAt *time.Time
+	if err := rows.Scan(&t.Id, &t.Deposit, &t.SeasonId, &settledAt); err != nil {
 		return nil, err
 	}
+	t.SettledAt = settledTime(settledAt)
+	rows.Close()
 
-	defer tx.Commit()
-
-	players := []string{}
-	if p != "" {
-		players = strings.Split(p, ",")
+	entries, err := loadEntries(tx, t.Id)
+	if err != nil {
+		return nil, err
 	}
-	return &Tournament{id, depo, players}, nil
+
+	return withEntries(t, entries), tx.Commit()
 }
 
-func (d *Db) JoinTournament(tourId int, playerId string) (rerr error) {
-	tx, err := d.db.Begin()
+func (d *Db) JoinTournament(tourId int, entry Entry) (rerr error) {
+	tx, err := d.begin()
 	if err != nil {
 		return err
 	}
@@ -92,41 +168,140 @@ func (d *Db) JoinTournament(tourId int, playerId string) (rerr error) {
 		}
 	}()
 
-	rows, err := tx.Query(selectPlayersTournamentQuery, tourId)
+	rows, err := tx.Query(selectTournamentQuery, tourId)
 	if err != nil {
 		return err
 	}
-
-	if !rows.Next() {
+	found := rows.Next()
+	rows.Close()
+	if !found {
 		return ErrorNotFound
 	}
 
-	var players string
-	if err := rows.Scan(&players); err != nil {
+	rows, err = tx.Query(selectEntryQuery, tourId, entry.PlayerId)
+	if err != nil {
 		return err
 	}
+	found = rows.Next()
+	rows.Close()
+	if found {
+		return ErrAlreadyExists
+	}
 
-	pArr := strings.Split(players, ",")
-	for _, p := range pArr {
-		if p == playerId {
-			return ErrAlreadyExists
+	if _, err := tx.Exec(insertEntryQuery, tourId, entry.PlayerId, entry.JoinedAt, entry.Stake, entry.Position); err != nil {
+		return err
+	}
+
+	for _, b := range entry.Backers {
+		if _, err := tx.Exec(insertBackerQuery, tourId, entry.PlayerId, b.BackerId, b.Stake); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Db) SetEntryPosition(tourId int, playerId string, position int) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
 		}
+	}()
+
+	res, err := tx.Exec(updateEntryPositionQuery, position, tourId, playerId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorNotFound
 	}
 
-	semicolonRequired := len(pArr) >= 1 && pArr[0] != ""
-	if semicolonRequired {
-		players += "," + playerId
-	} else {
-		players += playerId
+	return tx.Commit()
+}
+
+// SettleTournament marks a running tournament as finished. Settling an
+// already settled tournament returns ErrAlreadyExists.
+func (d *Db) SettleTournament(tourId int, settledAt time.Time) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
 	}
 
-	stmt, err := tx.Prepare(updateTournamentPlayersQuery)
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(settleTournamentQuery, settledAt, tourId)
 	if err != nil {
 		return err
 	}
-	if _, err = stmt.Exec(players, tourId); err != nil {
+	if n, err := res.RowsAffected(); err != nil {
 		return err
+	} else if n == 0 {
+		rows, err := tx.Query(selectTournamentQuery, tourId)
+		if err != nil {
+			return err
+		}
+		found := rows.Next()
+		rows.Close()
+		if found {
+			return ErrAlreadyExists
+		}
+		return ErrorNotFound
 	}
 
 	return tx.Commit()
 }
+
+func (d *Db) Tournaments() (_ []*Tournament, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectAllTournamentsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settledAt *time.Time
+	res := []*Tournament{}
+	for rows.Next() {
+		var t Tournament
+		if err := rows.Scan(&t.Id, &t.Deposit, &t.SeasonId, &settledAt); err != nil {
+			return nil, err
+		}
+		t.SettledAt = settledTime(settledAt)
+		res = append(res, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i, t := range res {
+		entries, err := loadEntries(tx, t.Id)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = withEntries(*t, entries)
+	}
+
+	return res, tx.Commit()
+}
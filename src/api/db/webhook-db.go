@@ -0,0 +1,339 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	insertWebhookQuery     = "insert into Webhooks (WebhookId, URL, Secret, Events, CreatedAt) values (?, ?, ?, ?, ?)"
+	selectWebhookQuery     = "select WebhookId, URL, Secret, Events, CreatedAt from Webhooks where WebhookId=?"
+	selectWebhooksQuery    = "select WebhookId, URL, Secret, Events, CreatedAt from Webhooks order by CreatedAt, WebhookId"
+	deleteWebhookQuery     = "delete from Webhooks where WebhookId=?"
+	deleteDeliveriesQuery  = "delete from WebhookDeliveries where WebhookId=?"
+	insertDeliveryQuery    = "insert into WebhookDeliveries (DeliveryId, WebhookId, EventType, Payload, State, Attempts, NextAttempt, LastStatus, LastError, CreatedAt, DeliveredAt) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	updateDeliveryQuery    = "update WebhookDeliveries set State=?, Attempts=?, NextAttempt=?, LastStatus=?, LastError=?, DeliveredAt=? where DeliveryId=?"
+	selectDeliveryColumns  = "select DeliveryId, WebhookId, EventType, Payload, State, Attempts, NextAttempt, LastStatus, LastError, CreatedAt, DeliveredAt from WebhookDeliveries"
+	selectDeliveryQuery    = selectDeliveryColumns + " where DeliveryId=?"
+	selectDeliveriesQuery  = selectDeliveryColumns + " where (?='' or WebhookId=?) and (?='' or State=?) order by CreatedAt, DeliveryId"
+	selectWebhookByIdQuery = "select 1 from Webhooks where WebhookId=?"
+)
+
+// States of a delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // given up after too many failed attempts
+)
+
+// Webhook is a partner endpoint subscribed to events. The secret signs
+// every delivery, so unlike API keys it is stored as is.
+type Webhook struct {
+	Id        string
+	URL       string
+	Secret    string
+	Events    []string // event types to deliver, all if empty
+	CreatedAt time.Time
+}
+
+// Wants reports whether the webhook subscribed to events of eventType.
+func (w *Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event queued for one webhook.
+type Delivery struct {
+	Id          string
+	WebhookId   string
+	EventType   string
+	Payload     string
+	State       string
+	Attempts    int
+	NextAttempt time.Time
+	LastStatus  int    // HTTP status of the last attempt, 0 if it got none
+	LastError   string // why the last attempt failed
+	CreatedAt   time.Time
+	DeliveredAt time.Time // zero until delivered
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func joinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+func splitEvents(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func scanWebhook(scan func(dest ...interface{}) error) (*Webhook, error) {
+	var w Webhook
+	var events string
+	if err := scan(&w.Id, &w.URL, &w.Secret, &events, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	w.Events = splitEvents(events)
+	return &w, nil
+}
+
+func scanDelivery(scan func(dest ...interface{}) error) (*Delivery, error) {
+	var d Delivery
+	var deliveredAt *time.Time
+	err := scan(&d.Id, &d.WebhookId, &d.EventType, &d.Payload, &d.State, &d.Attempts, &d.NextAttempt, &d.LastStatus, &d.LastError, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	d.DeliveredAt = settledTime(deliveredAt)
+	return &d, nil
+}
+
+func (d *Db) CreateWebhook(hook Webhook) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectWebhookByIdQuery, hook.Id)
+	if err != nil {
+		return err
+	}
+	found := rows.Next()
+	rows.Close()
+	if found {
+		return ErrAlreadyExists
+	}
+
+	if _, err := tx.Exec(insertWebhookQuery, hook.Id, hook.URL, hook.Secret, joinEvents(hook.Events), hook.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Db) Webhook(id string) (_ *Webhook, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectWebhookQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorNotFound
+	}
+	w, err := scanWebhook(rows.Scan)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return w, tx.Commit()
+}
+
+func (d *Db) Webhooks() (_ []*Webhook, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectWebhooksQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries.
+func (d *Db) DeleteWebhook(id string) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(deleteWebhookQuery, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorNotFound
+	}
+
+	if _, err := tx.Exec(deleteDeliveriesQuery, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Db) CreateDelivery(dl Delivery) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectWebhookByIdQuery, dl.WebhookId)
+	if err != nil {
+		return err
+	}
+	found := rows.Next()
+	rows.Close()
+	if !found {
+		return ErrorNotFound
+	}
+
+	_, err = tx.Exec(insertDeliveryQuery, dl.Id, dl.WebhookId, dl.EventType, dl.Payload, dl.State, dl.Attempts, dl.NextAttempt,
+		dl.LastStatus, dl.LastError, dl.CreatedAt, nullTime(dl.DeliveredAt))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateDelivery stores the outcome of an attempt: state, attempts, next
+// attempt, last status and error and delivery time.
+func (d *Db) UpdateDelivery(dl Delivery) (rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(updateDeliveryQuery, dl.State, dl.Attempts, dl.NextAttempt, dl.LastStatus, dl.LastError, nullTime(dl.DeliveredAt), dl.Id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorNotFound
+	}
+	return tx.Commit()
+}
+
+func (d *Db) Delivery(id string) (_ *Delivery, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectDeliveryQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrorNotFound
+	}
+	dl, err := scanDelivery(rows.Scan)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return dl, tx.Commit()
+}
+
+// Deliveries lists the deliveries of a webhook in a state, oldest first.
+// An empty webhookId or state matches all.
+func (d *Db) Deliveries(webhookId string, state string) (_ []*Delivery, rerr error) {
+	tx, err := d.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.Query(selectDeliveriesQuery, webhookId, webhookId, state, state)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*Delivery{}
+	for rows.Next() {
+		dl, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"api/apierr"
+	"api/db"
+)
+
+var ErrTournamentRunning = apierr.New(apierr.CodeTournamentRunning, "A tournament is running")
+
+// CarryOver decides which part of a balance survives the end of a season.
+type CarryOver struct {
+	Percent int // part of the balance kept, 0 starts everyone from zero
+	Max     int // upper bound of the kept balance, 0 for no limit
+}
+
+func (c CarryOver) apply(pts int) int {
+	res := pts * c.Percent / 100
+	if c.Max > 0 && res > c.Max {
+		res = c.Max
+	}
+	return res
+}
+
+// CloseSeason archives balances and standings of the current season and
+// starts the next one with balances reduced by rules.
+func (a *api_impl) CloseSeason(ctx context.Context, rules CarryOver) (db.Season, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	if a.activeTournamentId != noActiveTournament {
+		return db.Season{}, ErrTournamentRunning
+	}
+
+	var closed, next *db.Season
+	closedAt := time.Now().UTC()
+	err := store.Update(func(s db.Storage) error {
+		current, err := s.CurrentSeason()
+		if err != nil {
+			return err
+		}
+		closed = current
+
+		balances, err := s.Players()
+		if err != nil {
+			return err
+		}
+
+		standings, err := seasonStandings(s, current.Id, balances)
+		if err != nil {
+			return err
+		}
+
+		next, err = s.CloseSeason(closedAt, balances, standings)
+		if err != nil {
+			return err
+		}
+
+		for id, pts := range balances {
+			if err := s.UpdatePlayer(id, rules.apply(pts)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return db.Season{}, err
+	}
+
+	c := *closed
+	c.ClosedAt = closedAt
+	a.bus.Publish(SeasonClosed{c, *next, rules, closedAt})
+	return *next, nil
+}
+
+// Leaderboard ranks the players of a season by balance. The current season,
+// also selected by seasonId 0, is computed from the live balances.
+func (a *api_impl) Leaderboard(ctx context.Context, seasonId int) ([]db.Standing, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	current, err := store.CurrentSeason()
+	if err != nil {
+		return nil, err
+	}
+	if seasonId != 0 && seasonId != current.Id {
+		standings, err := store.SeasonStandings(seasonId)
+		return standings, notFound(err, ErrSeasonNotFound)
+	}
+
+	balances, err := store.Players()
+	if err != nil {
+		return nil, err
+	}
+	return seasonStandings(store, current.Id, balances)
+}
+
+// SeasonBalance returns the balance a player closed a season with, or the
+// live balance for the current season or seasonId 0.
+func (a *api_impl) SeasonBalance(ctx context.Context, seasonId int, playerId string) (int, error) {
+	a.lock()
+	defer a.dbMux.Unlock()
+	store := a.store(ctx)
+
+	current, err := store.CurrentSeason()
+	if err != nil {
+		return 0, err
+	}
+	if seasonId == 0 || seasonId == current.Id {
+		pts, err := store.PlayerPoints(playerId)
+		return pts, notFound(err, ErrPlayerNotFound)
+	}
+
+	balances, err := store.SeasonBalances(seasonId)
+	if err != nil {
+		return 0, notFound(err, ErrSeasonNotFound)
+	}
+	pts, ok := balances[playerId]
+	if !ok {
+		return 0, ErrPlayerNotFound
+	}
+	return pts, nil
+}
+
+func seasonStandings(s db.Storage, seasonId int, balances map[string]int) ([]db.Standing, error) {
+	tournaments, err := s.Tournaments()
+	if err != nil {
+		return nil, err
+	}
+
+	played := make(map[string]int)
+	won := make(map[string]int)
+	for _, t := range tournaments {
+		if t.SeasonId != seasonId {
+			continue
+		}
+		for _, e := range t.Entries {
+			played[e.PlayerId]++
+			if e.Position == 1 {
+				won[e.PlayerId]++
+			}
+		}
+	}
+
+	res := []db.Standing{}
+	for i, id := range rankPlayers(balances) {
+		res = append(res, db.Standing{
+			PlayerId: id,
+			Rank:     i + 1,
+			Points:   balances[id],
+			Played:   played[id],
+			Won:      won[id],
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"context"
+
+	"api/db"
+)
+
+// Stats are figures of the stored data for monitoring.
+type Stats db.Stats
+
+// Stats does not take dbMux: the storage sums up the data in a single
+// read, so frequent scrapes do not hold up operations.
+func (a *api_impl) Stats(ctx context.Context) (Stats, error) {
+	s, err := a.store(ctx).Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats(*s), nil
+}
@@ -0,0 +1,143 @@
+// back-a-friend-admin runs maintenance commands directly against the
+// database file.
+//
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] backup <file>
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] restore <file>
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] [-format jsonl|csv] export <file>
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] [-format jsonl|csv] import <file>
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] issue-key operator|game-server|player
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] revoke-key <key id>
+//	back-a-friend-admin [-storage sqlite|kv] [-db path] list-keys
+//
+// export and import use "-" for stdout and stdin. issue-key prints the new
+// key, which is not stored and cannot be shown again.
+//
+// SQLite backups can be taken while the server is running. The kv backend
+// locks its file, and restore always requires the server to be stopped; use
+// the /backup and /restore endpoints of a running server instead.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"api/db"
+	"server"
+)
+
+var ErrUsage = errors.New("usage: back-a-friend-admin [flags] backup|restore|export|import <file> | issue-key <role> | revoke-key <key id> | list-keys")
+
+func backup(s db.Storage, file string, restore bool) error {
+	b, ok := s.(db.Backuper)
+	if !ok {
+		return db.ErrBackupNotSupported
+	}
+	if restore {
+		return b.Restore(file)
+	}
+	return b.Backup(file)
+}
+
+func export(s db.Storage, file string, format string) error {
+	if file == "-" {
+		return db.Export(s, os.Stdout, format)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if err := db.Export(s, f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func importFile(s db.Storage, file string, format string) error {
+	if file == "-" {
+		return db.Import(s, os.Stdin, format)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return db.Import(s, f, format)
+}
+
+func issueKey(s db.Storage, role string) error {
+	key, k, err := server.IssueApiKey(s, role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id:   %s\nrole: %s\nkey:  %s\n", k.Id, k.Role, key)
+	return nil
+}
+
+func listKeys(s db.Storage) error {
+	keys, err := s.ApiKeys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		status := "active"
+		if !k.RevokedAt.IsZero() {
+			status = "revoked " + k.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", k.Id, k.Role, k.CreatedAt.Format(time.RFC3339), status)
+	}
+	return nil
+}
+
+func run(storage, dbPath, format string, args []string) error {
+	if len(args) == 0 || len(args) > 2 || (len(args) == 1) != (args[0] == "list-keys") {
+		return ErrUsage
+	}
+
+	s, err := server.OpenStorage(dbPath, storage)
+	if err != nil {
+		return err
+	}
+	defer s.Stop()
+
+	switch args[0] {
+	case "backup":
+		return backup(s, args[1], false)
+	case "restore":
+		return backup(s, args[1], true)
+	case "export":
+		return export(s, args[1], format)
+	case "import":
+		return importFile(s, args[1], format)
+	case "issue-key":
+		return issueKey(s, args[1])
+	case "revoke-key":
+		return s.RevokeApiKey(args[1], time.Now().UTC())
+	case "list-keys":
+		return listKeys(s)
+	}
+	return ErrUsage
+}
+
+func main() {
+	storage := flag.String("storage", server.StorageSqlite, "storage backend: sqlite or kv")
+	dbPath := flag.String("db", "", "database file, db/<default name> by default")
+	format := flag.String("format", db.FormatJSONL, "export and import format: jsonl or csv")
+	flag.Parse()
+
+	if *dbPath == "" {
+		*dbPath = path.Join("db", server.StorageFiles[*storage])
+	}
+
+	if err := run(*storage, *dbPath, *format, flag.Args()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -1,24 +1,74 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"path/filepath"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"logging"
 	"server"
 )
 
 func main() {
-	currDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	config, printConfig, err := server.LoadConfig(os.Args[0], os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		js, _ := json.MarshalIndent(config, "", "  ")
+		fmt.Println(string(js))
 		return
 	}
 
-	doneCh, err := server.StartServer(currDir)
-	if err != nil {
-		fmt.Println(err)
+	if config.LogFile != "" {
+		f, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logging.SetOutput(f)
+	}
+	// the standard library, net/http included, logs as JSON too
+	log.SetFlags(0)
+	log.SetOutput(logging.StdWriter)
+
+	if err := run(config); err != nil {
+		logging.Error(context.Background(), "server failed", err, nil)
+		os.Exit(1)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, or until serving fails.
+func run(config server.Config) error {
+	srv := server.NewServer(config)
+	if err := srv.Start(); err != nil {
+		return err
 	}
 
-	<-doneCh
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var failed error
+	select {
+	case sig := <-signals:
+		logging.Info(context.Background(), "shutting down", logging.Fields{"signal": sig.String()})
+	case failed = <-srv.Err():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && failed == nil {
+		return fmt.Errorf("shutdown: %v", err)
+	}
+	return failed
 }
@@ -0,0 +1,430 @@
+// Package client calls the HTTP API of back-a-friend. Its methods mirror
+// api.Api; failed calls return an *Error carrying the code the server
+// reported, so callers can branch with apierr.CodeOf.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/apierr"
+)
+
+const (
+	DefaultRetries   = 3
+	DefaultRetryWait = 100 * time.Millisecond
+	maxRetryWait     = 5 * time.Second
+
+	// maxIdempotentBody is the largest body the server buffers to replay
+	// a request.
+	maxIdempotentBody = 1 << 20
+)
+
+// Client talks to one server. Its fields must not be changed while calls
+// are running.
+type Client struct {
+	BaseURL    string
+	ApiKey     string
+	Token      string // player bearer token, sent instead of ApiKey if set
+	HTTPClient *http.Client
+
+	// Retries is how often a call is repeated after a network error, a
+	// 5xx response or a rate limit. Every call that changes something
+	// carries an Idempotency-Key, so the server replays the first response
+	// to a repeated call instead of applying it again. That only holds
+	// while the server that answered keeps running: it keeps responses in
+	// memory, does not share them between replicas and forgets requests
+	// that failed with a 5xx. Imports larger than the server buffers for
+	// replays are sent without a key; importing twice fails, since imports
+	// need an empty store.
+	Retries int
+	// RetryWait is the wait before the first retry, it doubles with every
+	// further one. A Retry-After of the server takes precedence.
+	RetryWait time.Duration
+}
+
+func New(baseURL string, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		ApiKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+		Retries:    DefaultRetries,
+		RetryWait:  DefaultRetryWait,
+	}
+}
+
+// Error is a call the server rejected.
+type Error struct {
+	Status  int
+	Code    apierr.Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) ErrorCode() apierr.Code {
+	return e.Code
+}
+
+// retryable reports whether the request may succeed if sent again.
+func (e *Error) retryable() bool {
+	switch {
+	case e.Status == http.StatusNotImplemented:
+		return false
+	case e.Status >= http.StatusInternalServerError:
+		return true
+	}
+	return e.Code == apierr.CodeRateLimited || e.Code == apierr.CodeRequestInProgress
+}
+
+func readError(resp *http.Response) *Error {
+	e := &Error{Status: resp.StatusCode}
+	var body struct {
+		Code  apierr.Code
+		Error string
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &body); err != nil || body.Code == "" {
+		e.Code = apierr.CodeInternal
+		e.Message = strings.TrimSpace(string(data))
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	e.Code = body.Code
+	e.Message = body.Error
+	return e
+}
+
+func idempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// wait sleeps before retry attempt, or until ctx is done.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	d := c.RetryWait << uint(attempt)
+	if s, err := strconv.Atoi(retryAfter); err == nil {
+		d = time.Duration(s) * time.Second
+	}
+	if d > maxRetryWait {
+		d = maxRetryWait
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// send sends a request, retrying it as configured, and returns the
+// response of the first attempt that succeeded.
+func (c *Client) send(ctx context.Context, method string, path string, contentType string, body []byte) (*http.Response, error) {
+	key, err := idempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		} else {
+			req.Header.Set("X-Api-Key", c.ApiKey)
+		}
+		if method != http.MethodGet && len(body) <= maxIdempotentBody {
+			req.Header.Set("Idempotency-Key", key)
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if attempt >= c.Retries {
+				return nil, err
+			}
+			if err := c.wait(ctx, attempt, ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+
+		apiErr := readError(resp)
+		resp.Body.Close()
+		if !apiErr.retryable() || attempt >= c.Retries {
+			return nil, apiErr
+		}
+		if err := c.wait(ctx, attempt, resp.Header.Get("Retry-After")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// do sends in as JSON and decodes the response into out, if not nil.
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		js, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = js
+	}
+
+	resp, err := c.send(ctx, method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func playerPath(playerId string) string {
+	return "/v2/players/" + url.PathEscape(playerId)
+}
+
+func tournamentPath(tourId int) string {
+	return "/v2/tournaments/" + strconv.Itoa(tourId)
+}
+
+type pointsRequest struct {
+	Points int
+}
+
+type balance struct {
+	PlayerId string
+	Balance  int
+}
+
+func (c *Client) Take(ctx context.Context, playerId string, points int) error {
+	return c.do(ctx, http.MethodPost, playerPath(playerId)+"/take", pointsRequest{points}, nil)
+}
+
+func (c *Client) Fund(ctx context.Context, playerId string, points int) error {
+	return c.do(ctx, http.MethodPost, playerPath(playerId)+"/fund", pointsRequest{points}, nil)
+}
+
+func (c *Client) Balance(ctx context.Context, playerId string) (int, error) {
+	return c.SeasonBalance(ctx, 0, playerId)
+}
+
+// SeasonBalance returns the balance of a player at the end of a closed
+// season, or the current one if seasonId is 0.
+func (c *Client) SeasonBalance(ctx context.Context, seasonId int, playerId string) (int, error) {
+	path := playerPath(playerId)
+	if seasonId != 0 {
+		path += "?seasonId=" + strconv.Itoa(seasonId)
+	}
+
+	var b balance
+	if err := c.do(ctx, http.MethodGet, path, nil, &b); err != nil {
+		return 0, err
+	}
+	return b.Balance, nil
+}
+
+type PlayerToken struct {
+	PlayerId  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// PlayerToken issues a bearer token bound to playerId, see Client.Token.
+func (c *Client) PlayerToken(ctx context.Context, playerId string) (PlayerToken, error) {
+	var t PlayerToken
+	err := c.do(ctx, http.MethodPost, playerPath(playerId)+"/tokens", nil, &t)
+	return t, err
+}
+
+type tournamentRequest struct {
+	TournamentId int
+	Deposit      int
+}
+
+type entryRequest struct {
+	PlayerId string
+	Backers  []string
+}
+
+type backingRequest struct {
+	PlayerId string
+	BackerId string
+}
+
+type Winner struct {
+	PlayerId string
+	Prize    int
+}
+
+func (c *Client) AnnounceTournament(ctx context.Context, tourId int, deposit int) error {
+	return c.do(ctx, http.MethodPost, "/v2/tournaments", tournamentRequest{tourId, deposit}, nil)
+}
+
+func (c *Client) JoinTournament(ctx context.Context, tourId int, playerId string, backers []string) error {
+	return c.do(ctx, http.MethodPost, tournamentPath(tourId)+"/entries", entryRequest{playerId, backers}, nil)
+}
+
+// ActiveTournament returns the id of the running tournament.
+func (c *Client) ActiveTournament(ctx context.Context) (int, error) {
+	var t struct {
+		TournamentId int
+	}
+	err := c.do(ctx, http.MethodGet, "/v2/tournaments/active", nil, &t)
+	return t.TournamentId, err
+}
+
+// ResultTournament settles the running tournament.
+func (c *Client) ResultTournament(ctx context.Context) (Winner, error) {
+	var w Winner
+	tourId, err := c.ActiveTournament(ctx)
+	if err != nil {
+		return w, err
+	}
+	err = c.do(ctx, http.MethodPost, tournamentPath(tourId)+"/result", nil, &w)
+	return w, err
+}
+
+func (c *Client) RequestBacking(ctx context.Context, tourId int, playerId string, backerId string) error {
+	return c.do(ctx, http.MethodPost, tournamentPath(tourId)+"/backing-requests", backingRequest{playerId, backerId}, nil)
+}
+
+func (c *Client) AcceptBacking(ctx context.Context, tourId int, playerId string, backerId string) error {
+	path := tournamentPath(tourId) + "/backing-requests/" + url.PathEscape(playerId) + "/" + url.PathEscape(backerId) + "/accept"
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+func (c *Client) AcceptedBackers(ctx context.Context, tourId int, playerId string) ([]string, error) {
+	var backers []string
+	err := c.do(ctx, http.MethodGet, tournamentPath(tourId)+"/backing-requests/"+url.PathEscape(playerId)+"/accepted", nil, &backers)
+	return backers, err
+}
+
+type CarryOver struct {
+	Percent int
+	Max     int
+}
+
+type Season struct {
+	Id        int
+	StartedAt time.Time
+	ClosedAt  time.Time
+}
+
+type Standing struct {
+	PlayerId string
+	Rank     int
+	Points   int
+	Played   int
+	Won      int
+}
+
+// CloseSeason closes the current season and returns the new one.
+func (c *Client) CloseSeason(ctx context.Context, rules CarryOver) (Season, error) {
+	var s Season
+	err := c.do(ctx, http.MethodPost, "/v2/seasons", struct {
+		CarryPercent int
+		CarryMax     int
+	}{rules.Percent, rules.Max}, &s)
+	return s, err
+}
+
+// Leaderboard returns the standings of a season, 0 is the current one.
+func (c *Client) Leaderboard(ctx context.Context, seasonId int) ([]Standing, error) {
+	id := "current"
+	if seasonId != 0 {
+		id = strconv.Itoa(seasonId)
+	}
+
+	var standings []Standing
+	err := c.do(ctx, http.MethodGet, "/v2/seasons/"+id+"/leaderboard", nil, &standings)
+	return standings, err
+}
+
+func (c *Client) Reset(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v2/reset", nil, nil)
+}
+
+// Backup backs up the database on the server and returns the name of the
+// backup file.
+func (c *Client) Backup(ctx context.Context) (string, error) {
+	var f struct {
+		File string
+	}
+	err := c.do(ctx, http.MethodPost, "/v2/backups", nil, &f)
+	return f.File, err
+}
+
+// Restore restores a backup made with Backup.
+func (c *Client) Restore(ctx context.Context, file string) error {
+	return c.do(ctx, http.MethodPost, "/v2/backups/"+url.PathEscape(file)+"/restore", nil, nil)
+}
+
+// Export writes all records in format, jsonl or csv, to w.
+func (c *Client) Export(ctx context.Context, w io.Writer, format string) error {
+	resp, err := c.send(ctx, http.MethodGet, "/v2/export?format="+url.QueryEscape(format), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+var contentTypes = map[string]string{
+	"jsonl": "application/x-ndjson",
+	"csv":   "text/csv",
+}
+
+// Import reads an export in format from r and imports it.
+func (c *Client) Import(ctx context.Context, r io.Reader, format string) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.send(ctx, http.MethodPost, "/v2/import?format="+url.QueryEscape(format), contentTypes[format], body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
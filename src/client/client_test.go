@@ -0,0 +1,223 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api"
+	"api/apierr"
+	"api/db"
+	"server"
+)
+
+// setup serves the real handlers over a MemDb and returns a client with
+// an operator key.
+func setup(t *testing.T, wrap func(http.Handler) http.Handler) (*Client, func()) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := server.IssueApiKey(s, server.RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	var h http.Handler = server.NewHandler(a, s, server.NewTokenSigner([]byte("secret")), server.RateLimits{}, "", ".db", stop, jobs)
+	if wrap != nil {
+		h = wrap(h)
+	}
+	ts := httptest.NewServer(h)
+
+	c := New(ts.URL, key)
+	c.RetryWait = time.Millisecond
+	return c, func() {
+		ts.Close()
+		close(stop)
+		jobs.Wait()
+	}
+}
+
+func TestClient_Tournament(t *testing.T) {
+	c, stop := setup(t, nil)
+	defer stop()
+	ctx := context.Background()
+
+	for id, pts := range map[string]int{"P1": 500, "P2": 200, "B1": 500} {
+		if err := c.Fund(ctx, id, pts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AnnounceTournament(ctx, 1, 400); err != nil {
+		t.Fatal(err)
+	}
+
+	// P2 needs a backer, asked for and accepted with player tokens
+	p2, b1 := *c, *c
+	for id, pc := range map[string]*Client{"P2": &p2, "B1": &b1} {
+		tok, err := c.PlayerToken(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc.Token = tok.Token
+	}
+	if err := p2.RequestBacking(ctx, 1, "P2", "B1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b1.AcceptBacking(ctx, 1, "P2", "B1"); err != nil {
+		t.Fatal(err)
+	}
+	backers, err := p2.AcceptedBackers(ctx, 1, "P2")
+	if err != nil || len(backers) != 1 || backers[0] != "B1" {
+		t.Fatal(backers, err)
+	}
+
+	if err := c.JoinTournament(ctx, 1, "P1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.JoinTournament(ctx, 1, "P2", backers); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.ActiveTournament(ctx); err != nil || id != 1 {
+		t.Fatal(id, err)
+	}
+
+	winner, err := c.ResultTournament(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winner.Prize != 800 {
+		t.Error(winner)
+	}
+
+	standings, err := c.Leaderboard(ctx, 0)
+	if err != nil || len(standings) != 3 {
+		t.Fatal(standings, err)
+	}
+
+	before, err := c.Balance(ctx, winner.PlayerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	season, err := c.CloseSeason(ctx, CarryOver{Percent: 10})
+	if err != nil || season.Id != 2 {
+		t.Fatal(season, err)
+	}
+	if pts, err := c.SeasonBalance(ctx, 1, winner.PlayerId); err != nil || pts != before {
+		t.Error(pts, before, err)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	c, stop := setup(t, nil)
+	defer stop()
+	ctx := context.Background()
+
+	if err := c.Fund(ctx, "P1", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.Take(ctx, "P1", 20)
+	if apierr.CodeOf(err) != apierr.CodeInsufficientFunds {
+		t.Fatal(err)
+	}
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusUnprocessableEntity {
+		t.Error(err)
+	}
+
+	if _, err := c.Balance(ctx, "nobody"); apierr.CodeOf(err) != apierr.CodePlayerNotFound {
+		t.Error(err)
+	}
+	if err := c.Fund(ctx, "P1", -1); apierr.CodeOf(err) != apierr.CodeInvalidArgument {
+		t.Error(err)
+	}
+
+	c.ApiKey = "wrong.key"
+	if _, err := c.Balance(ctx, "P1"); apierr.CodeOf(err) != apierr.CodeUnauthenticated {
+		t.Error(err)
+	}
+}
+
+func TestClient_RetryIsIdempotent(t *testing.T) {
+	var calls int32
+	// the first fund is applied, but its response is lost on the way back
+	lossy := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && atomic.AddInt32(&calls, 1) == 1 {
+				h.ServeHTTP(httptest.NewRecorder(), r)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+	c, stop := setup(t, lossy)
+	defer stop()
+	ctx := context.Background()
+
+	if err := c.Fund(ctx, "P1", 100); err != nil {
+		t.Fatal(err)
+	}
+	pts, err := c.Balance(ctx, "P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pts != 100 || atomic.LoadInt32(&calls) != 2 {
+		t.Error("fund applied twice or not retried:", pts, calls)
+	}
+
+	c.Retries = 0
+	atomic.StoreInt32(&calls, 0)
+	if err := c.Fund(ctx, "P1", 100); err == nil || err.(*Error).Status != http.StatusBadGateway {
+		t.Error(err)
+	}
+}
+
+func TestClient_Context(t *testing.T) {
+	block := make(chan struct{})
+	slow := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-block
+		})
+	}
+	c, stop := setup(t, slow)
+	defer stop()
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Balance(ctx, "P1"); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestClient_ExportImport(t *testing.T) {
+	c, stop := setup(t, nil)
+	defer stop()
+	ctx := context.Background()
+
+	if err := c.Fund(ctx, "P1", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Export(ctx, &buf, db.FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Import(ctx, &buf, db.FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	if pts, err := c.Balance(ctx, "P1"); err != nil || pts != 10 {
+		t.Error(pts, err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package logging writes structured logs, one JSON object per line.
+// Entries logged with the context of a request carry its id, so every line
+// a request causes can be found by it.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestIdHeader carries the id of a request. A valid id sent by the
+// client is kept, otherwise one is generated; responses echo it.
+const RequestIdHeader = "X-Request-ID"
+
+// maxRequestIdLen bounds the ids accepted from clients.
+const maxRequestIdLen = 128
+
+// Fields are the details of an entry besides time, level, message and
+// request id.
+type Fields map[string]interface{}
+
+var (
+	mux sync.Mutex
+	out io.Writer = os.Stderr
+	now           = time.Now
+)
+
+// SetOutput sets where entries are written, standard error by default.
+func SetOutput(w io.Writer) {
+	mux.Lock()
+	defer mux.Unlock()
+	out = w
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a copy of ctx carrying the request id.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the request id of ctx, empty outside requests.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// NewRequestId returns a random id.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestId reports whether a client supplied id is kept: at most 128
+// printable ASCII characters without spaces.
+func ValidRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Info logs an event worth keeping.
+func Info(ctx context.Context, msg string, f Fields) {
+	write(ctx, "info", msg, nil, f)
+}
+
+// Error logs a failure; err may be nil if msg says it all.
+func Error(ctx context.Context, msg string, err error, f Fields) {
+	write(ctx, "error", msg, err, f)
+}
+
+// write writes time, level, msg, the request id and the error first and
+// the fields ordered by name after them.
+func write(ctx context.Context, level string, msg string, err error, f Fields) {
+	var b bytes.Buffer
+	field := func(name string, v interface{}) {
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		js, _ := json.Marshal(name)
+		b.Write(js)
+		b.WriteByte(':')
+		if js, e := json.Marshal(v); e == nil {
+			b.Write(js)
+		} else {
+			js, _ = json.Marshal(e.Error())
+			b.Write(js)
+		}
+	}
+	field("time", now().UTC().Format(time.RFC3339Nano))
+	field("level", level)
+	field("msg", msg)
+	if id := RequestId(ctx); id != "" {
+		field("requestId", id)
+	}
+	if err != nil {
+		field("error", err.Error())
+	}
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field(name, f[name])
+	}
+	b.WriteString("}\n")
+
+	mux.Lock()
+	defer mux.Unlock()
+	out.Write(b.Bytes())
+}
+
+// StdWriter turns the lines of the standard library log package into
+// error entries, for log.SetOutput with no flags set.
+var StdWriter io.Writer = stdWriter{}
+
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	Error(context.Background(), strings.TrimSpace(string(p)), nil, nil)
+	return len(p), nil
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capture() (*bytes.Buffer, func()) {
+	var b bytes.Buffer
+	SetOutput(&b)
+	now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return &b, func() {
+		SetOutput(os.Stderr)
+		now = time.Now
+	}
+}
+
+func TestLogging_Entries(t *testing.T) {
+	b, restore := capture()
+	defer restore()
+
+	ctx := WithRequestId(context.Background(), "r1")
+	Info(ctx, "request", Fields{"status": 200, "route": "/v2/tournaments/{}/entries"})
+	Error(context.Background(), "statement failed", errors.New("disk I/O error"), Fields{"statement": "update"})
+
+	want := `{"time":"2024-05-01T12:00:00Z","level":"info","msg":"request","requestId":"r1","route":"/v2/tournaments/{}/entries","status":200}
+{"time":"2024-05-01T12:00:00Z","level":"error","msg":"statement failed","error":"disk I/O error","statement":"update"}
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestLogging_StdWriter(t *testing.T) {
+	b, restore := capture()
+	defer restore()
+
+	l := log.New(StdWriter, "", 0)
+	l.Println("http: TLS handshake error")
+	if want := `{"time":"2024-05-01T12:00:00Z","level":"error","msg":"http: TLS handshake error"}` + "\n"; b.String() != want {
+		t.Error(b.String())
+	}
+}
+
+func TestLogging_RequestIds(t *testing.T) {
+	if a, b := NewRequestId(), NewRequestId(); a == b || !ValidRequestId(a) {
+		t.Error(a, b)
+	}
+	for id, valid := range map[string]bool{
+		"abc-123":                      true,
+		"":                             false,
+		"with space":                   false,
+		"new\nline":                    false,
+		strings.Repeat("x", 128):       true,
+		strings.Repeat("x", 129):       false,
+		"café":                         false,
+		"0f8fad5b-d9cb-469f-a165-7067": true,
+	} {
+		if ValidRequestId(id) != valid {
+			t.Error(id, !valid)
+		}
+	}
+}
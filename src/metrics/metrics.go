@@ -0,0 +1,305 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of WriteText.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of latency
+// histograms, from lock waits to slow requests.
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// Default is the registry the package level constructors add to.
+var Default = NewRegistry()
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics by name.
+type Registry struct {
+	mux     sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) add(m metric) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.metrics[m.name()]; ok {
+		panic("metrics: " + m.name() + " registered twice")
+	}
+	r.metrics[m.name()] = m
+}
+
+// WriteText writes every metric of r, ordered by name, in the text
+// exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mux.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, len(names))
+	for i, name := range names {
+		ms[i] = r.metrics[name]
+	}
+	r.mux.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range ms {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc is the name, help and label names of a metric and keeps a value
+// per combination of label values.
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mux    sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64  // counters and gauges
+	counts []uint64 // histograms: observations per bucket, not cumulative
+	sum    float64  // histograms
+	count  uint64   // histograms
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+// get returns the series of labelValues, creating it if needed; d.mux
+// must be held.
+func (d *desc) get(labelValues []string) *series {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.metricName, len(d.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := d.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		d.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values; d.mux must be held.
+func (d *desc) sorted() []*series {
+	keys := make([]string, 0, len(d.series))
+	for k := range d.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]*series, len(keys))
+	for i, k := range keys {
+		res[i] = d.series[k]
+	}
+	return res
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+// writeSample writes one line: the name, the labels of d with values
+// followed by extra name and value pairs, and v.
+func (d *desc) writeSample(w *bufio.Writer, name string, values []string, v float64, extra ...string) {
+	w.WriteString(name)
+	n := 0
+	label := func(k, v string) {
+		if n == 0 {
+			w.WriteByte('{')
+		} else {
+			w.WriteByte(',')
+		}
+		n++
+		fmt.Fprintf(w, "%s=\"%s\"", k, escapeLabel(v))
+	}
+	for i, k := range d.labels {
+		label(k, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		label(extra[i], extra[i+1])
+	}
+	if n > 0 {
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// Counter is a value that only goes up, per combination of label values.
+type Counter struct {
+	desc
+}
+
+// NewCounter creates a counter and adds it to r.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc{metricName: name, help: help, kind: "counter", labels: labels, series: make(map[string]*series)}}
+	r.add(c)
+	return c
+}
+
+// NewCounter creates a counter and adds it to Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter of labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.metricName + " decreased")
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.get(labelValues).value += v
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		c.writeSample(w, c.metricName, s.labels, s.value)
+	}
+}
+
+// Gauge is a value that is set, per combination of label values.
+type Gauge struct {
+	desc
+}
+
+// NewGauge creates a gauge and adds it to r.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc{metricName: name, help: help, kind: "gauge", labels: labels, series: make(map[string]*series)}}
+	r.add(g)
+	return g
+}
+
+// NewGauge creates a gauge and adds it to Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.get(labelValues).value = v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.writeHeader(w)
+	for _, s := range g.sorted() {
+		g.writeSample(w, g.metricName, s.labels, s.value)
+	}
+}
+
+// Histogram counts observations in buckets, per combination of label
+// values.
+type Histogram struct {
+	desc
+	bounds []float64
+}
+
+// NewHistogram creates a histogram with buckets of the given increasing
+// upper bounds and adds it to r. The +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc{metricName: name, help: help, kind: "histogram", labels: labels, series: make(map[string]*series)}, buckets}
+	r.add(h)
+	return h
+}
+
+// NewHistogram creates a histogram and adds it to Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.bounds))
+	}
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, le := range h.bounds {
+			cumulative += s.counts[i]
+			h.writeSample(w, h.metricName+"_bucket", s.labels, float64(cumulative), "le", formatFloat(le))
+		}
+		h.writeSample(w, h.metricName+"_bucket", s.labels, float64(s.count), "le", "+Inf")
+		h.writeSample(w, h.metricName+"_sum", s.labels, s.sum)
+		h.writeSample(w, h.metricName+"_count", s.labels, float64(s.count))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
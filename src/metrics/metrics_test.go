@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests by handler.", "handler", "status")
+	g := r.NewGauge("entries", "Entries.")
+	h := r.NewHistogram("duration_seconds", "Request latency.", []float64{.1, 1}, "handler")
+
+	c.Inc("/fund", "200")
+	c.Add(2, "/take", "422")
+	c.Inc("/fund", "200")
+	c.Inc(`a"b\`, "200")
+	g.Set(3)
+	h.Observe(.05, "/fund")
+	h.Observe(.5, "/fund")
+	h.Observe(1, "/fund")
+	h.Observe(7, "/fund")
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP duration_seconds Request latency.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{handler="/fund",le="0.1"} 1
+duration_seconds_bucket{handler="/fund",le="1"} 3
+duration_seconds_bucket{handler="/fund",le="+Inf"} 4
+duration_seconds_sum{handler="/fund"} 8.55
+duration_seconds_count{handler="/fund"} 4
+# HELP entries Entries.
+# TYPE entries gauge
+entries 3
+# HELP requests_total Requests by handler.
+# TYPE requests_total counter
+requests_total{handler="/fund",status="200"} 2
+requests_total{handler="/take",status="422"} 2
+requests_total{handler="a\"b\\",status="200"} 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("c", "", "label")
+
+	panics := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic:", name)
+			}
+		}()
+		f()
+	}
+	panics("registered twice", func() { r.NewGauge("c", "") })
+	panics("missing label", func() { c.Inc() })
+	panics("decreased", func() { c.Add(-1, "x") })
+}
@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errMessage = errors.New("rpc: message must be a pointer to a struct")
+
+// Codec encodes the messages in messages.go in the protobuf wire format,
+// so that clients generated from back-a-friend.proto can talk to the
+// service. Fields are numbered by their `proto` tag; supported field
+// types are string, bool, int, int64, []byte, []string, structs and slices
+// of struct pointers.
+type Codec struct{}
+
+func (Codec) Name() string {
+	return "proto"
+}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, errMessage
+	}
+	return appendMessage(nil, rv.Elem()), nil
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errMessage
+	}
+	return consumeMessage(data, rv.Elem())
+}
+
+// fieldNumber returns the protobuf field number of the i-th field of t,
+// or 0 if the field is not part of the message.
+func fieldNumber(t reflect.Type, i int) protowire.Number {
+	n, err := strconv.Atoi(t.Field(i).Tag.Get("proto"))
+	if err != nil {
+		return 0
+	}
+	return protowire.Number(n)
+}
+
+func appendMessage(b []byte, v reflect.Value) []byte {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		num := fieldNumber(t, i)
+		if num == 0 {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			if f.Len() > 0 {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendString(b, f.String())
+			}
+		case reflect.Bool:
+			if f.Bool() {
+				b = protowire.AppendTag(b, num, protowire.VarintType)
+				b = protowire.AppendVarint(b, 1)
+			}
+		case reflect.Int, reflect.Int64:
+			if f.Int() != 0 {
+				b = protowire.AppendTag(b, num, protowire.VarintType)
+				b = protowire.AppendVarint(b, uint64(f.Int()))
+			}
+		case reflect.Struct:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, appendMessage(nil, f))
+		case reflect.Ptr:
+			if !f.IsNil() {
+				b = protowire.AppendTag(b, num, protowire.BytesType)
+				b = protowire.AppendBytes(b, appendMessage(nil, f.Elem()))
+			}
+		case reflect.Slice:
+			b = appendRepeated(b, num, f)
+		}
+	}
+	return b
+}
+
+func appendRepeated(b []byte, num protowire.Number, f reflect.Value) []byte {
+	switch f.Type().Elem().Kind() {
+	case reflect.Uint8:
+		if f.Len() > 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, f.Bytes())
+		}
+	case reflect.String:
+		for j := 0; j < f.Len(); j++ {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, f.Index(j).String())
+		}
+	case reflect.Ptr:
+		for j := 0; j < f.Len(); j++ {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, appendMessage(nil, f.Index(j).Elem()))
+		}
+	}
+	return b
+}
+
+func consumeMessage(b []byte, v reflect.Value) error {
+	t := v.Type()
+	fields := make(map[protowire.Number]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if num := fieldNumber(t, i); num != 0 {
+			fields[num] = i
+		}
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		i, ok := fields[num]
+		if !ok {
+			// unknown fields are skipped, newer clients may send them
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		n, err := consumeField(b, typ, v.Field(i))
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeField(b []byte, typ protowire.Type, f reflect.Value) (int, error) {
+	if typ == protowire.VarintType {
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(x != 0)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(int64(x))
+		}
+		return n, nil
+	}
+
+	if typ != protowire.BytesType {
+		return 0, errors.New("rpc: unexpected wire type " + strconv.Itoa(int(typ)))
+	}
+	data, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(string(data))
+	case reflect.Struct:
+		if err := consumeMessage(data, f); err != nil {
+			return 0, err
+		}
+	case reflect.Ptr:
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		if err := consumeMessage(data, f.Elem()); err != nil {
+			return 0, err
+		}
+	case reflect.Slice:
+		switch f.Type().Elem().Kind() {
+		case reflect.Uint8:
+			f.SetBytes(append([]byte(nil), data...))
+		case reflect.String:
+			f.Set(reflect.Append(f, reflect.ValueOf(string(data))))
+		case reflect.Ptr:
+			item := reflect.New(f.Type().Elem().Elem())
+			if err := consumeMessage(data, item.Elem()); err != nil {
+				return 0, err
+			}
+			f.Set(reflect.Append(f, item))
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	msgs := []interface{}{
+		&JoinRequest{TournamentId: 7, PlayerId: "P1", Backers: []string{"B1", "B2"}},
+		&Leaderboard{Standings: []*Standing{{PlayerId: "P1", Rank: 1, Points: 900, Played: 2, Won: 1}, {PlayerId: "P2", Rank: 2}}},
+		&Records{Format: "csv", Data: []byte("a,b\n")},
+		&PointsRequest{PlayerId: "P1", Points: -5},
+		&Empty{},
+	}
+	for _, m := range msgs {
+		b, err := Codec{}.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+		if err := (Codec{}).Unmarshal(b, got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("got %+v, want %+v", got, m)
+		}
+	}
+}
+
+func TestCodec_Wire(t *testing.T) {
+	b, err := Codec{}.Marshal(&PointsRequest{PlayerId: "P1", Points: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what protoc generated code emits for the same message
+	var want []byte
+	want = protowire.AppendTag(want, 1, protowire.BytesType)
+	want = protowire.AppendString(want, "P1")
+	want = protowire.AppendTag(want, 2, protowire.VarintType)
+	want = protowire.AppendVarint(want, 150)
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("got %x, want %x", b, want)
+	}
+
+	// unknown fields are skipped
+	extra := protowire.AppendTag(append([]byte(nil), want...), 9, protowire.BytesType)
+	extra = protowire.AppendString(extra, "ignored")
+	var got PointsRequest
+	if err := (Codec{}).Unmarshal(extra, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.PlayerId != "P1" || got.Points != 150 {
+		t.Error(got)
+	}
+
+	if err := (Codec{}).Unmarshal([]byte{0x0a, 0x05}, &got); err == nil {
+		t.Error("truncated message was accepted")
+	}
+}
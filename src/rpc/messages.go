@@ -0,0 +1,111 @@
+// Package rpc holds the messages and the service description of the gRPC
+// API, see back-a-friend.proto. Times are Unix seconds, zero if unset.
+package rpc
+
+type Empty struct{}
+
+type PointsRequest struct {
+	PlayerId string `proto:"1"`
+	Points   int64  `proto:"2"`
+}
+
+type TournamentRequest struct {
+	TournamentId int64 `proto:"1"`
+	Deposit      int64 `proto:"2"`
+}
+
+type JoinRequest struct {
+	TournamentId int64    `proto:"1"`
+	PlayerId     string   `proto:"2"`
+	Backers      []string `proto:"3"`
+}
+
+type Winner struct {
+	PlayerId string `proto:"1"`
+	Prize    int64  `proto:"2"`
+}
+
+type ActiveTournamentReply struct {
+	TournamentId int64 `proto:"1"`
+}
+
+// BalanceRequest reads the current balance if SeasonId is zero.
+type BalanceRequest struct {
+	PlayerId string `proto:"1"`
+	SeasonId int64  `proto:"2"`
+}
+
+type Balance struct {
+	PlayerId string `proto:"1"`
+	Balance  int64  `proto:"2"`
+}
+
+type BackupFile struct {
+	File string `proto:"1"`
+}
+
+// Records is an export in the format of api.Export.
+type Records struct {
+	Format string `proto:"1"`
+	Data   []byte `proto:"2"`
+}
+
+type ExportRequest struct {
+	Format string `proto:"1"`
+}
+
+type CarryOver struct {
+	Percent int64 `proto:"1"`
+	Max     int64 `proto:"2"`
+}
+
+type Season struct {
+	Id        int64 `proto:"1"`
+	StartedAt int64 `proto:"2"`
+	ClosedAt  int64 `proto:"3"`
+}
+
+// LeaderboardRequest reads the current season if SeasonId is zero.
+type LeaderboardRequest struct {
+	SeasonId int64 `proto:"1"`
+}
+
+type Standing struct {
+	PlayerId string `proto:"1"`
+	Rank     int64  `proto:"2"`
+	Points   int64  `proto:"3"`
+	Played   int64  `proto:"4"`
+	Won      int64  `proto:"5"`
+}
+
+type Leaderboard struct {
+	Standings []*Standing `proto:"1"`
+}
+
+type BackingRequest struct {
+	TournamentId int64  `proto:"1"`
+	PlayerId     string `proto:"2"`
+	BackerId     string `proto:"3"`
+}
+
+type BackersRequest struct {
+	TournamentId int64  `proto:"1"`
+	PlayerId     string `proto:"2"`
+}
+
+type Backers struct {
+	BackerIds []string `proto:"1"`
+}
+
+// TournamentEvent is one change to a tournament, Type is one of announced,
+// joined, backing_requested, backing_accepted and settled.
+type TournamentEvent struct {
+	Type         string   `proto:"1"`
+	TournamentId int64    `proto:"2"`
+	PlayerId     string   `proto:"3"`
+	BackerId     string   `proto:"4"`
+	Backers      []string `proto:"5"`
+	Deposit      int64    `proto:"6"`
+	Prize        int64    `proto:"7"`
+	At           int64    `proto:"8"`
+}
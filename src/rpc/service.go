@@ -0,0 +1,357 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of the service in back-a-friend.proto.
+const ServiceName = "backafriend.v1.BackAFriend"
+
+// BackAFriendServer mirrors api.Api. Lifecycle methods are left out, they
+// belong to the process running the service.
+type BackAFriendServer interface {
+	// Take takes points from a player.
+	Take(context.Context, *PointsRequest) (*Empty, error)
+	// Fund funds a player, creating it if needed.
+	Fund(context.Context, *PointsRequest) (*Empty, error)
+	// AnnounceTournament announces a tournament.
+	AnnounceTournament(context.Context, *TournamentRequest) (*Empty, error)
+	// JoinTournament joins the announced tournament.
+	JoinTournament(context.Context, *JoinRequest) (*Empty, error)
+	// ResultTournament settles the running tournament.
+	ResultTournament(context.Context, *Empty) (*Winner, error)
+	// ActiveTournament returns the id of the running tournament.
+	ActiveTournament(context.Context, *Empty) (*ActiveTournamentReply, error)
+	// Balance returns the current balance of a player.
+	Balance(context.Context, *BalanceRequest) (*Balance, error)
+	// SeasonBalance returns the balance of a player at the end of a season.
+	SeasonBalance(context.Context, *BalanceRequest) (*Balance, error)
+	// Reset drops all players and tournaments, keeping archived seasons.
+	Reset(context.Context, *Empty) (*Empty, error)
+	// Backup backs up the database into the backup directory.
+	Backup(context.Context, *Empty) (*BackupFile, error)
+	// Restore restores a backup from the backup directory.
+	Restore(context.Context, *BackupFile) (*Empty, error)
+	// Export exports all records.
+	Export(context.Context, *ExportRequest) (*Records, error)
+	// Import imports records into an empty database.
+	Import(context.Context, *Records) (*Empty, error)
+	// CloseSeason closes the current season and returns the new one.
+	CloseSeason(context.Context, *CarryOver) (*Season, error)
+	// Leaderboard returns the standings of a season.
+	Leaderboard(context.Context, *LeaderboardRequest) (*Leaderboard, error)
+	// RequestBacking asks another player to back an entry.
+	RequestBacking(context.Context, *BackingRequest) (*Empty, error)
+	// AcceptBacking accepts a backing request as the backer.
+	AcceptBacking(context.Context, *BackingRequest) (*Empty, error)
+	// AcceptedBackers lists the backers that accepted a player's requests.
+	AcceptedBackers(context.Context, *BackersRequest) (*Backers, error)
+	// WatchTournaments streams tournament events until the call ends.
+	WatchTournaments(*Empty, BackAFriend_WatchTournamentsServer) error
+}
+
+type BackAFriend_WatchTournamentsServer interface {
+	Send(*TournamentEvent) error
+	grpc.ServerStream
+}
+
+type watchTournamentsServer struct {
+	grpc.ServerStream
+}
+
+func (s watchTournamentsServer) Send(e *TournamentEvent) error {
+	return s.ServerStream.SendMsg(e)
+}
+
+func RegisterBackAFriendServer(s *grpc.Server, srv BackAFriendServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// unary adapts call to a grpc method handler. in returns a new request
+// message to decode into.
+func unary(name string, in func() interface{}, call func(BackAFriendServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := in()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(BackAFriendServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*BackAFriendServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Take", func() interface{} { return new(PointsRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Take(ctx, req.(*PointsRequest))
+		}),
+		unary("Fund", func() interface{} { return new(PointsRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Fund(ctx, req.(*PointsRequest))
+		}),
+		unary("AnnounceTournament", func() interface{} { return new(TournamentRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.AnnounceTournament(ctx, req.(*TournamentRequest))
+		}),
+		unary("JoinTournament", func() interface{} { return new(JoinRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.JoinTournament(ctx, req.(*JoinRequest))
+		}),
+		unary("ResultTournament", func() interface{} { return new(Empty) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ResultTournament(ctx, req.(*Empty))
+		}),
+		unary("ActiveTournament", func() interface{} { return new(Empty) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ActiveTournament(ctx, req.(*Empty))
+		}),
+		unary("Balance", func() interface{} { return new(BalanceRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Balance(ctx, req.(*BalanceRequest))
+		}),
+		unary("SeasonBalance", func() interface{} { return new(BalanceRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.SeasonBalance(ctx, req.(*BalanceRequest))
+		}),
+		unary("Reset", func() interface{} { return new(Empty) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Reset(ctx, req.(*Empty))
+		}),
+		unary("Backup", func() interface{} { return new(Empty) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Backup(ctx, req.(*Empty))
+		}),
+		unary("Restore", func() interface{} { return new(BackupFile) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Restore(ctx, req.(*BackupFile))
+		}),
+		unary("Export", func() interface{} { return new(ExportRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Export(ctx, req.(*ExportRequest))
+		}),
+		unary("Import", func() interface{} { return new(Records) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Import(ctx, req.(*Records))
+		}),
+		unary("CloseSeason", func() interface{} { return new(CarryOver) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.CloseSeason(ctx, req.(*CarryOver))
+		}),
+		unary("Leaderboard", func() interface{} { return new(LeaderboardRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Leaderboard(ctx, req.(*LeaderboardRequest))
+		}),
+		unary("RequestBacking", func() interface{} { return new(BackingRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.RequestBacking(ctx, req.(*BackingRequest))
+		}),
+		unary("AcceptBacking", func() interface{} { return new(BackingRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.AcceptBacking(ctx, req.(*BackingRequest))
+		}),
+		unary("AcceptedBackers", func() interface{} { return new(BackersRequest) }, func(s BackAFriendServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.AcceptedBackers(ctx, req.(*BackersRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "WatchTournaments",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := new(Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(BackAFriendServer).WatchTournaments(in, watchTournamentsServer{stream})
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "back-a-friend.proto",
+}
+
+// BackAFriendClient calls the service over conn. The connection has to use
+// Codec, see DialOptions.
+type BackAFriendClient struct {
+	conn *grpc.ClientConn
+}
+
+func NewBackAFriendClient(conn *grpc.ClientConn) BackAFriendClient {
+	return BackAFriendClient{conn}
+}
+
+// DialOptions are needed by connections of a BackAFriendClient.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{}))}
+}
+
+func (c BackAFriendClient) invoke(ctx context.Context, method string, in interface{}, out interface{}, opts ...grpc.CallOption) error {
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, in, out, opts...)
+}
+
+func (c BackAFriendClient) Take(ctx context.Context, in *PointsRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Take", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Fund(ctx context.Context, in *PointsRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Fund", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) AnnounceTournament(ctx context.Context, in *TournamentRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "AnnounceTournament", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) JoinTournament(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "JoinTournament", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) ResultTournament(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Winner, error) {
+	out := new(Winner)
+	if err := c.invoke(ctx, "ResultTournament", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) ActiveTournament(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ActiveTournamentReply, error) {
+	out := new(ActiveTournamentReply)
+	if err := c.invoke(ctx, "ActiveTournament", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Balance(ctx context.Context, in *BalanceRequest, opts ...grpc.CallOption) (*Balance, error) {
+	out := new(Balance)
+	if err := c.invoke(ctx, "Balance", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) SeasonBalance(ctx context.Context, in *BalanceRequest, opts ...grpc.CallOption) (*Balance, error) {
+	out := new(Balance)
+	if err := c.invoke(ctx, "SeasonBalance", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Reset(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Reset", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Backup(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*BackupFile, error) {
+	out := new(BackupFile)
+	if err := c.invoke(ctx, "Backup", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Restore(ctx context.Context, in *BackupFile, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Restore", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (*Records, error) {
+	out := new(Records)
+	if err := c.invoke(ctx, "Export", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Import(ctx context.Context, in *Records, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "Import", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) CloseSeason(ctx context.Context, in *CarryOver, opts ...grpc.CallOption) (*Season, error) {
+	out := new(Season)
+	if err := c.invoke(ctx, "CloseSeason", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) Leaderboard(ctx context.Context, in *LeaderboardRequest, opts ...grpc.CallOption) (*Leaderboard, error) {
+	out := new(Leaderboard)
+	if err := c.invoke(ctx, "Leaderboard", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) RequestBacking(ctx context.Context, in *BackingRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "RequestBacking", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) AcceptBacking(ctx context.Context, in *BackingRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	if err := c.invoke(ctx, "AcceptBacking", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c BackAFriendClient) AcceptedBackers(ctx context.Context, in *BackersRequest, opts ...grpc.CallOption) (*Backers, error) {
+	out := new(Backers)
+	if err := c.invoke(ctx, "AcceptedBackers", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TournamentEvents receives the events of a WatchTournaments call.
+type TournamentEvents struct {
+	grpc.ClientStream
+}
+
+func (s TournamentEvents) Recv() (*TournamentEvent, error) {
+	e := new(TournamentEvent)
+	if err := s.ClientStream.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (c BackAFriendClient) WatchTournaments(ctx context.Context, in *Empty, opts ...grpc.CallOption) (TournamentEvents, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/WatchTournaments", opts...)
+	if err != nil {
+		return TournamentEvents{}, err
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return TournamentEvents{}, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return TournamentEvents{}, err
+	}
+	return TournamentEvents{stream}, nil
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+
+	"api/apierr"
+	"api/db"
+	"logging"
+)
+
+const (
+	// auditBodyLimit is how much of a request body an audit entry keeps.
+	auditBodyLimit = 4 << 10
+	auditPageSize  = 100
+	auditMaxPage   = 1000
+)
+
+// unauditedPaths are the v1 endpoints that only read. Every other v1
+// request is recorded.
+var unauditedPaths = map[string]bool{
+	"/balance":      true,
+	"/leaderboard":  true,
+	"/metrics":      true,
+	"/openapi.json": true,
+	"/rateLimits":   true,
+}
+
+// unauditedCalls are the unary gRPC methods that only read.
+var unauditedCalls = map[string]bool{
+	"Balance":          true,
+	"SeasonBalance":    true,
+	"ActiveTournament": true,
+	"Leaderboard":      true,
+	"AcceptedBackers":  true,
+}
+
+// AuditHash returns the hash chaining an audit entry to the one before
+// it: the hex SHA-256 of PrevHash, Seq, At in RFC 3339 with nanoseconds
+// and UTC, Role, KeyId, PlayerId, Addr, Method, Endpoint, Query, Body,
+// Status and Outcome, each followed by a zero byte.
+func AuditHash(e db.AuditEntry) string {
+	fields := []string{
+		e.PrevHash, strconv.FormatInt(e.Seq, 10), e.At.UTC().Format(time.RFC3339Nano),
+		e.Role, e.KeyId, e.PlayerId, e.Addr, e.Method, e.Endpoint, e.Query, e.Body,
+		strconv.Itoa(e.Status), e.Outcome,
+	}
+	parts := make([][]byte, len(fields))
+	for i, f := range fields {
+		parts[i] = []byte(f)
+	}
+	return hashOf(parts...)
+}
+
+// AuditVerification is the result of checking the hash chain of the
+// audit log. Truncating the log keeps the chain intact, compare LastHash
+// with one noted earlier to detect it.
+type AuditVerification struct {
+	Entries  int
+	Valid    bool
+	BrokenAt int64 `json:",omitempty"` // Seq of the first entry not matching its hash or predecessor
+	LastHash string
+}
+
+// checkAuditChain checks that entries, the whole audit log in order, are
+// numbered without gaps and chained by their hashes.
+func checkAuditChain(entries []*db.AuditEntry) AuditVerification {
+	res := AuditVerification{Entries: len(entries), Valid: true}
+	prev := ""
+	for i, e := range entries {
+		seq := int64(i + 1)
+		if e.Seq != seq || e.PrevHash != prev || AuditHash(*e) != e.Hash {
+			res.Valid = false
+			res.BrokenAt = seq
+			return res
+		}
+		prev = e.Hash
+	}
+	res.LastHash = prev
+	return res
+}
+
+// auditLog appends a record of every call that may change something, and
+// of exports, to the append-only audit log in storage. Only calls of
+// authenticated callers with a role allowed to make them are recorded. A
+// nil auditLog records nothing.
+type auditLog struct {
+	store db.Storage
+	mux   sync.Mutex
+}
+
+func newAuditLog(store db.Storage) *auditLog {
+	return &auditLog{store: store}
+}
+
+// append chains e to the last entry in storage and stores it. The
+// response has been sent already, so a failure is only logged.
+func (l *auditLog) append(e db.AuditEntry) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	last, err := l.store.LastAuditEntry()
+	switch err {
+	case nil:
+		e.Seq = last.Seq + 1
+		e.PrevHash = last.Hash
+	case db.ErrorNotFound:
+		e.Seq = 1
+	default:
+		logging.Error(context.Background(), "audit entry not recorded", err, nil)
+		return
+	}
+	e.At = time.Now().UTC()
+	e.Hash = AuditHash(e)
+	if err := l.store.AppendAuditEntry(e); err != nil {
+		logging.Error(context.Background(), "audit entry not recorded", err, nil)
+	}
+}
+
+// audits reports whether r is recorded: every v1 request but the reads,
+// every v2 request but GETs, and exports.
+func (l *auditLog) audits(r *http.Request) bool {
+	if l == nil {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		return r.Method != http.MethodGet || r.URL.Path == "/v2/export"
+	}
+	return !unauditedPaths[r.URL.Path]
+}
+
+// auditBody returns the start of the body of r and leaves r.Body unread.
+func auditBody(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	head, _ := ioutil.ReadAll(io.LimitReader(r.Body, auditBodyLimit))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return string(head)
+}
+
+func (l *auditLog) recordRequest(r *http.Request, p Principal, body string, w *statusWriter) {
+	l.append(db.AuditEntry{
+		Role:     p.Role,
+		KeyId:    p.KeyId,
+		PlayerId: p.PlayerId,
+		Addr:     remoteHost(r),
+		Method:   r.Method,
+		Endpoint: r.URL.Path,
+		Query:    r.URL.RawQuery,
+		Body:     body,
+		Status:   w.status,
+		Outcome:  w.outcome(),
+	})
+}
+
+// recordCall records a unary gRPC call that failed with code, if not
+// empty. The request encoded as JSON takes the place of the body.
+func (l *auditLog) recordCall(ctx context.Context, fullMethod string, p Principal, req interface{}, code apierr.Code) {
+	if l == nil || unauditedCalls[path.Base(fullMethod)] {
+		return
+	}
+	e := db.AuditEntry{Role: p.Role, KeyId: p.KeyId, PlayerId: p.PlayerId, Method: "GRPC", Endpoint: fullMethod, Status: http.StatusOK, Outcome: "ok"}
+	if pr, ok := peer.FromContext(ctx); ok {
+		e.Addr = hostOf(pr.Addr.String())
+	}
+	if body, err := json.Marshal(req); err == nil {
+		if len(body) > auditBodyLimit {
+			body = body[:auditBodyLimit]
+		}
+		e.Body = string(body)
+	}
+	if code != "" {
+		e.Status = code.Status()
+		e.Outcome = string(code)
+	}
+	l.append(e)
+}
+
+// statusWriter passes a response through and keeps its status and, of
+// failed requests, the start of the error body.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < auditBodyLimit {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// outcome is "ok" for a successful response and the error code otherwise.
+func (w *statusWriter) outcome() string {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status < http.StatusBadRequest {
+		return "ok"
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(w.body.Bytes(), &body); err == nil && body.Code != "" {
+		return string(body.Code)
+	}
+	return strconv.Itoa(w.status)
+}
+
+// listAudit lists audit entries in order, a page at a time: after is the
+// Seq of the last entry of the previous page.
+func (h v2) listAudit(w http.ResponseWriter, r *http.Request, p []string) {
+	q := r.URL.Query()
+	aq := db.AuditQuery{Limit: auditPageSize, KeyId: q.Get("keyId"), PlayerId: q.Get("playerId"), Endpoint: q.Get("endpoint")}
+	if s := q.Get("after"); s != "" {
+		after, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || after < 0 {
+			badRequest(w, "after")
+			return
+		}
+		aq.After = after
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > auditMaxPage {
+			badRequest(w, "limit")
+			return
+		}
+		aq.Limit = limit
+	}
+
+	entries, err := h.store.AuditEntries(aq)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (h v2) verifyAudit(w http.ResponseWriter, r *http.Request, p []string) {
+	entries, err := h.store.AuditEntries(db.AuditQuery{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checkAuditChain(entries))
+}
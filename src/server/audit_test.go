@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"api"
+	"api/db"
+	"rpc"
+)
+
+func auditEntries(t *testing.T, h http.Handler, key string, url string) []db.AuditEntry {
+	w := do(h, "GET", url, key, nil)
+	if w.Code != http.StatusOK {
+		t.Fatal(url, w.Code, w.Body.String())
+	}
+	var res []db.AuditEntry
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestAudit_RecordsOperatorActions(t *testing.T) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, k, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenSigner([]byte("secret"))
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	defer jobs.Wait()
+	defer close(stop)
+	h := NewHandler(a, s, tokens, RateLimits{}, "", ".db", stop, jobs)
+
+	do(h, "GET", "/fund?playerId=P1&points=300", key, nil)
+	do(h, "GET", "/balance?playerId=P1", key, nil)
+	do(h, "POST", "/v2/players/P1/take", key, PointsRequest{1000})
+	do(h, "GET", "/reset", "", nil)
+
+	token, _, err := tokens.Issue("P1", PlayerTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/v2/reset", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := auditEntries(t, h, key, "/v2/audit")
+	if len(entries) != 2 {
+		t.Fatal("reads and rejected requests are not recorded", entries)
+	}
+	want := []struct {
+		endpoint, outcome string
+		status            int
+	}{
+		{"/fund", "ok", http.StatusOK},
+		{"/v2/players/P1/take", "insufficient_funds", http.StatusUnprocessableEntity},
+	}
+	for i, e := range entries {
+		if e.Seq != int64(i+1) || e.Endpoint != want[i].endpoint || e.Outcome != want[i].outcome || e.Status != want[i].status || e.Addr == "" {
+			t.Error(i, e)
+		}
+	}
+	if e := entries[0]; e.Role != RoleOperator || e.KeyId != k.Id || e.Query != "playerId=P1&points=300" || e.PrevHash != "" {
+		t.Error(e)
+	}
+	if e := entries[1]; e.Body != "{\"Points\":1000}\n" || e.PrevHash != entries[0].Hash {
+		t.Error(e)
+	}
+
+	if page := auditEntries(t, h, key, "/v2/audit?after=1&limit=2"); len(page) != 1 || page[0].Seq != 2 {
+		t.Error(page)
+	}
+	if mine := auditEntries(t, h, key, "/v2/audit?keyId="+k.Id+"&endpoint=/fund"); len(mine) != 1 {
+		t.Error(mine)
+	}
+	if w := do(h, "GET", "/v2/audit?limit=0", key, nil); w.Code != http.StatusBadRequest {
+		t.Error(w.Code)
+	}
+
+	w := do(h, "GET", "/v2/audit/verify", key, nil)
+	var res AuditVerification
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || !res.Valid || res.Entries != 2 || res.LastHash != entries[1].Hash {
+		t.Error(res, err)
+	}
+}
+
+func TestAudit_DetectsTampering(t *testing.T) {
+	s := db.CreateMemDb()
+	l := newAuditLog(s)
+	for _, ep := range []string{"/fund", "/resultTournament", "/reset"} {
+		l.append(db.AuditEntry{Role: RoleOperator, KeyId: "k1", Method: "GET", Endpoint: ep, Status: http.StatusOK, Outcome: "ok"})
+	}
+	entries, err := s.AuditEntries(db.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := checkAuditChain(entries); !res.Valid || res.Entries != 3 {
+		t.Fatal(res)
+	}
+
+	changed := *entries[1]
+	changed.KeyId = "k2"
+	if res := checkAuditChain([]*db.AuditEntry{entries[0], &changed, entries[2]}); res.Valid || res.BrokenAt != 2 {
+		t.Error("changed entry", res)
+	}
+
+	// rehashing the changed entry breaks the link to the next one
+	changed.Hash = AuditHash(changed)
+	if res := checkAuditChain([]*db.AuditEntry{entries[0], &changed, entries[2]}); res.Valid || res.BrokenAt != 3 {
+		t.Error("rehashed entry", res)
+	}
+	if res := checkAuditChain([]*db.AuditEntry{entries[0], entries[2]}); res.Valid || res.BrokenAt != 2 {
+		t.Error("dropped entry", res)
+	}
+}
+
+func TestAudit_RecordsGrpcCalls(t *testing.T) {
+	s := db.CreateMemDb()
+	core, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := authenticator{s, NewTokenSigner([]byte("secret")), nil, newAuditLog(s), nil, nil}
+	srv := newGrpcServer(core, auth, newEventBroker(), "", ".db")
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) { return lis.Dial() }
+	opts := append(rpc.DialOptions(), grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := rpc.NewBackAFriendClient(conn)
+
+	c.Fund(withKey(key), &rpc.PointsRequest{PlayerId: "P1", Points: 100})
+	c.Balance(withKey(key), &rpc.BalanceRequest{PlayerId: "P1"})
+	c.Take(withKey(key), &rpc.PointsRequest{PlayerId: "P1", Points: 500})
+	c.Reset(withKey("garbage"), &rpc.Empty{})
+
+	entries, err := s.AuditEntries(db.AuditQuery{})
+	if err != nil || len(entries) != 2 {
+		t.Fatal(entries, err)
+	}
+	if e := entries[0]; e.Method != "GRPC" || e.Endpoint != "/"+rpc.ServiceName+"/Fund" || e.Outcome != "ok" || e.Body == "" {
+		t.Error(e)
+	}
+	if e := entries[1]; e.Outcome != "insufficient_funds" || e.Status != http.StatusUnprocessableEntity {
+		t.Error(e)
+	}
+}
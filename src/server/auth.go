@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/apierr"
+	"api/db"
+)
+
+// Roles an API key can be issued for.
+const (
+	RoleOperator   = "operator"    // runs the service: funding, seasons, backups
+	RoleGameServer = "game-server" // runs tournaments
+	RolePlayer     = "player"      // checks balances and joins tournaments
+)
+
+// ApiKeyHeader is the request header API keys are sent in.
+const ApiKeyHeader = "X-Api-Key"
+
+var (
+	ErrUnknownRole    = apierr.New(apierr.CodeInvalidArgument, "Unknown role")
+	ErrInvalidApiKey  = apierr.New(apierr.CodeUnauthenticated, "Invalid API key")
+	ErrApiKeyRequired = apierr.New(apierr.CodeUnauthenticated, "API key required")
+	ErrForbidden      = apierr.New(apierr.CodeForbidden, "Not allowed for this API key")
+)
+
+var Roles = map[string]bool{
+	RoleOperator:   true,
+	RoleGameServer: true,
+	RolePlayer:     true,
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// IssueApiKey creates a key for role and returns it. The key has the form
+// <id>.<secret>; only the id and a hash of the secret are stored, so the
+// returned key cannot be recovered later.
+func IssueApiKey(s db.Storage, role string) (string, *db.ApiKey, error) {
+	if !Roles[role] {
+		return "", nil, ErrUnknownRole
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	k := db.ApiKey{Id: id, Hash: hashSecret(secret), Role: role, CreatedAt: time.Now().UTC()}
+	if err := s.CreateApiKey(k); err != nil {
+		return "", nil, err
+	}
+	return id + "." + secret, &k, nil
+}
+
+// checkApiKey returns the stored key matching key if it is valid.
+func checkApiKey(s db.Storage, key string) (*db.ApiKey, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidApiKey
+	}
+
+	k, err := s.ApiKey(parts[0])
+	if err == db.ErrorNotFound {
+		return nil, ErrInvalidApiKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashSecret(parts[1]))) != 1 || !k.RevokedAt.IsZero() {
+		return nil, ErrInvalidApiKey
+	}
+	return k, nil
+}
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	Role     string
+	PlayerId string // set for requests authenticated with a player token
+	KeyId    string // set for requests authenticated with an API key or client certificate
+}
+
+type principalKey struct{}
+
+func principalFrom(r *http.Request) Principal {
+	return principalFromContext(r.Context())
+}
+
+func principalFromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	return p
+}
+
+// canActAs reports whether p may act on the account of playerId. Players
+// are limited to the account their token was issued for; a player API key
+// without a token acts on no account.
+func (p Principal) canActAs(playerId string) bool {
+	return p.Role != RolePlayer || p.PlayerId == playerId
+}
+
+// authenticator checks API keys and player tokens, applies rate limits
+// and replays the responses of repeated requests.
+type authenticator struct {
+	keys    db.Storage
+	tokens  *TokenSigner
+	limits  *rateLimiter
+	audit   *auditLog
+	certs   map[string]CertIdentity // by subject common name
+	replays *idempotencyCache
+}
+
+// authenticate returns the principal of a request carrying either an API
+// key or a player bearer token. Without either a client certificate mapped
+// to an identity is accepted.
+func (au authenticator) authenticate(r *http.Request) (Principal, error) {
+	auth, key := r.Header.Get("Authorization"), r.Header.Get(ApiKeyHeader)
+	if auth == "" && key == "" {
+		if p, ok := au.certPrincipal(r.TLS); ok {
+			return p, nil
+		}
+	}
+	return au.credentials(auth, key)
+}
+
+// credentials checks the value of an Authorization header and an API key,
+// the token wins if both are given.
+func (au authenticator) credentials(auth string, key string) (Principal, error) {
+	if strings.HasPrefix(auth, "Bearer ") {
+		playerId, err := au.tokens.Verify(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{Role: RolePlayer, PlayerId: playerId}, nil
+	}
+
+	if key == "" {
+		return Principal{}, ErrApiKeyRequired
+	}
+
+	k, err := checkApiKey(au.keys, key)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{Role: k.Role, KeyId: k.Id}, nil
+}
+
+type authHandler struct {
+	au    authenticator
+	roles map[string]bool
+	h     http.Handler
+}
+
+// require only passes requests to h that carry a valid API key or token
+// of one of roles and match the OpenAPI document. Requests repeated with
+// an Idempotency-Key get the response of the first one.
+func (au authenticator) require(h http.Handler, roles ...string) http.Handler {
+	allowed := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		allowed[r] = true
+	}
+	return authHandler{au, allowed, h}
+}
+
+func (h authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	if ok, wait := h.au.limits.checkAddr(r, now); !ok {
+		tooManyRequests(w, wait)
+		return
+	}
+
+	if !h.au.audit.audits(r) {
+		h.serve(w, r, now)
+		return
+	}
+	body := auditBody(r)
+	aw := &statusWriter{ResponseWriter: w}
+	// rejected callers only show up in the request log, they could fill
+	// the append-only audit log otherwise
+	if p, authorized := h.serve(aw, r, now); authorized {
+		h.au.audit.recordRequest(r, p, body, aw)
+	}
+}
+
+// serve authenticates, authorizes and validates r before passing it on.
+// It returns the principal as far as it got and whether it may call the
+// route.
+func (h authHandler) serve(w http.ResponseWriter, r *http.Request, now time.Time) (Principal, bool) {
+	p, err := h.au.authenticate(r)
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.caller = p
+	}
+	if err != nil {
+		if apierr.CodeOf(err) == apierr.CodeUnauthenticated {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, err)
+		return p, false
+	}
+
+	if !h.roles[p.Role] {
+		writeError(w, ErrForbidden)
+		return p, false
+	}
+
+	if ok, wait := h.au.limits.checkPrincipal(r, p, now); !ok {
+		tooManyRequests(w, wait)
+		return p, true
+	}
+
+	if err := spec.validate(w, r); err != nil {
+		writeError(w, err)
+		return p, true
+	}
+	h.au.replays.serve(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), p, h.h)
+	return p, true
+}
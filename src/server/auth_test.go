@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api/db"
+)
+
+func TestRequireRole(t *testing.T) {
+	s := db.CreateMemDb()
+
+	operator, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, _, err := IssueApiKey(s, RolePlayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, k, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeApiKey(k.Id, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := authenticator{s, NewTokenSigner([]byte("secret")), nil, nil, nil, nil}.require(ok, RoleOperator)
+
+	tests := map[string]int{
+		"":                  http.StatusUnauthorized,
+		"garbage":           http.StatusUnauthorized,
+		operator + "0":      http.StatusUnauthorized,
+		revoked:             http.StatusUnauthorized,
+		player:              http.StatusForbidden,
+		operator:            http.StatusOK,
+		k.Id + "." + k.Hash: http.StatusUnauthorized,
+	}
+	for key, status := range tests {
+		r := httptest.NewRequest("GET", "/reset", nil)
+		if key != "" {
+			r.Header.Set(ApiKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("key %q: got %d, want %d", key, w.Code, status)
+		}
+	}
+
+	if _, _, err := IssueApiKey(s, "admin"); err != ErrUnknownRole {
+		t.Error(err)
+	}
+}
+
+func TestPlayerToken(t *testing.T) {
+	s := db.CreateMemDb()
+	tokens := NewTokenSigner([]byte("secret"))
+	au := authenticator{s, tokens, nil, nil, nil, nil}
+
+	var got Principal
+	h := au.require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = principalFrom(r)
+	}), RolePlayer)
+
+	token, _, err := tokens.Issue("P1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := tokens.Issue("P1", -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _, err := NewTokenSigner([]byte("other")).Issue("P1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{
+		token:         http.StatusOK,
+		expired:       http.StatusUnauthorized,
+		forged:        http.StatusUnauthorized,
+		token[1:]:     http.StatusUnauthorized,
+		"not.a.token": http.StatusUnauthorized,
+	}
+	for tok, status := range tests {
+		r := httptest.NewRequest("GET", "/balance?playerId=P1", nil)
+		r.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("token %q: got %d, want %d", tok, w.Code, status)
+		}
+	}
+
+	if got.Role != RolePlayer || got.PlayerId != "P1" {
+		t.Error(got)
+	}
+	if !got.canActAs("P1") || got.canActAs("P2") {
+		t.Error("player token is not bound to its player")
+	}
+	if (Principal{Role: RolePlayer}).canActAs("P1") {
+		t.Error("player API key without token acts on an account")
+	}
+	if !(Principal{Role: RoleGameServer}).canActAs("P1") {
+		t.Error("game server cannot act on accounts")
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api"
+	"api/apierr"
+)
+
+var ErrBackingNotAccepted = apierr.New(apierr.CodeForbidden, "Backing was not accepted")
+
+func containsAll(set []string, items []string) bool {
+	m := make(map[string]bool, len(set))
+	for _, s := range set {
+		m[s] = true
+	}
+	for _, i := range items {
+		if !m[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBackers makes sure that players are only backed by those who
+// accepted their request. Operators and game servers back players directly.
+func checkBackers(ctx context.Context, a api.Api, p Principal, tourId int, playerId string, backers []string) error {
+	if p.Role != RolePlayer || len(backers) == 0 {
+		return nil
+	}
+
+	accepted, err := a.AcceptedBackers(ctx, tourId, playerId)
+	if err != nil {
+		return err
+	}
+	if !containsAll(accepted, backers) {
+		return ErrBackingNotAccepted
+	}
+	return nil
+}
+
+// backingParams reads tournamentId, playerId and backerId of a backing
+// request.
+func backingParams(r *http.Request) (int, string, string, bool) {
+	q := r.URL.Query()
+
+	tourId, ok := q["tournamentId"]
+	if !ok || len(tourId) > 1 {
+		return 0, "", "", false
+	}
+	playerId, ok := q["playerId"]
+	if !ok || len(playerId) > 1 {
+		return 0, "", "", false
+	}
+	backerId, ok := q["backerId"]
+	if !ok || len(backerId) > 1 {
+		return 0, "", "", false
+	}
+
+	tid, err := strconv.Atoi(tourId[0])
+	if err != nil {
+		return 0, "", "", false
+	}
+	return tid, playerId[0], backerId[0], true
+}
+
+type requestBackingHandler struct {
+	a api.Api
+}
+
+func newRequestBackingHandler(a api.Api) http.Handler {
+	return requestBackingHandler{a}
+}
+
+func (h requestBackingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tid, playerId, backerId, ok := backingParams(r)
+	if !ok {
+		badRequest(w, "tournamentId", "playerId", "backerId")
+		return
+	}
+
+	if !principalFrom(r).canActAs(playerId) {
+		writeError(w, ErrForbidden)
+		return
+	}
+
+	if err := h.a.RequestBacking(r.Context(), tid, playerId, backerId); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type acceptBackingHandler struct {
+	a api.Api
+}
+
+func newAcceptBackingHandler(a api.Api) http.Handler {
+	return acceptBackingHandler{a}
+}
+
+func (h acceptBackingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tid, playerId, backerId, ok := backingParams(r)
+	if !ok {
+		badRequest(w, "tournamentId", "playerId", "backerId")
+		return
+	}
+
+	// only the backer can accept a request addressed to it
+	if !principalFrom(r).canActAs(backerId) {
+		writeError(w, ErrForbidden)
+		return
+	}
+
+	if err := h.a.AcceptBacking(r.Context(), tid, playerId, backerId); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type playerTokenHandler struct {
+	tokens *TokenSigner
+}
+
+func newPlayerTokenHandler(tokens *TokenSigner) http.Handler {
+	return playerTokenHandler{tokens}
+}
+
+type PlayerToken struct {
+	PlayerId  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (h playerTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	playerId, ok := r.URL.Query()["playerId"]
+	if !ok || len(playerId) > 1 || playerId[0] == "" {
+		badRequest(w, "playerId")
+		return
+	}
+
+	token, expiresAt, err := h.tokens.Issue(playerId[0], PlayerTokenTTL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	js, err := json.Marshal(PlayerToken{playerId[0], token, expiresAt})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"api"
+	"api/apierr"
+)
+
+type backupHandler struct {
+	a   api.Api
+	dir string
+	ext string
+}
+
+func newBackupHandler(a api.Api, dir string, ext string) http.Handler {
+	return backupHandler{a, dir, ext}
+}
+
+type BackupFile struct {
+	File string
+}
+
+// backupName is the name of a new backup with file extension ext.
+func backupName(ext string) string {
+	return "back-a-friend-" + time.Now().UTC().Format("20060102-150405.000") + ext
+}
+
+// findBackup returns the path of backup file in dir. Only backups from the
+// backup directory can be restored.
+func findBackup(dir string, file string) (string, error) {
+	if file == "" || path.Base(file) != file {
+		return "", apierr.Invalid("Invalid backup file")
+	}
+
+	backupPath := path.Join(dir, file)
+	if _, err := os.Stat(backupPath); err != nil {
+		return "", ErrBackupNotFound
+	}
+	return backupPath, nil
+}
+
+func (h backupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := backupName(h.ext)
+	if err := h.a.Backup(r.Context(), path.Join(h.dir, name)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	js, err := json.Marshal(BackupFile{name})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+type restoreHandler struct {
+	a   api.Api
+	dir string
+}
+
+func newRestoreHandler(a api.Api, dir string) http.Handler {
+	return restoreHandler{a, dir}
+}
+
+func (h restoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	file, ok := q["file"]
+	if !ok || len(file) > 1 {
+		badRequest(w, "file")
+		return
+	}
+
+	backupPath, err := findBackup(h.dir, file[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.a.Restore(r.Context(), backupPath); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable setting: the
+// flag name in upper case with dashes replaced by underscores, e.g.
+// BACKAFRIEND_HTTP_ADDR for -http-addr.
+const EnvPrefix = "BACKAFRIEND_"
+
+// Duration is a time.Duration written like "30s" in flags, environment
+// variables and config files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	return d.Set(string(b))
+}
+
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Limit) UnmarshalText(b []byte) error {
+	return l.Set(string(b))
+}
+
+// Config configures a Server. The JSON encoding is the format of config
+// files, fields missing from a file keep their defaults.
+type Config struct {
+	HTTPAddr string
+	GrpcAddr string // empty to disable the gRPC API
+
+	DataDir string // holds the database, backups and token key
+	Storage string // StorageSqlite or StorageKv
+	DbPath  string // database file, <DataDir>/<StorageFiles[Storage]> if empty
+
+	Limits RateLimits
+
+	ReadHeaderTimeout Duration
+	ReadTimeout       Duration // for whole requests, 0 for none
+	WriteTimeout      Duration // 0 for none; a limit also ends event streams
+	IdleTimeout       Duration
+	ShutdownTimeout   Duration
+
+	// TLSCert and TLSKey are PEM files; the HTTP and gRPC APIs are served
+	// with TLS when both are set. The files are loaded again when they
+	// change.
+	TLSCert string
+	TLSKey  string
+	// TLSClientCA holds the PEM certificates client certificates are
+	// verified with; they are only asked for if it is set.
+	TLSClientCA          string
+	TLSRequireClientCert bool
+	// ClientCerts maps the subject common names of client certificates to
+	// the callers they authenticate, for requests without API key or
+	// token. It can only be set in the config file.
+	ClientCerts map[string]CertIdentity
+
+	LogFile string // standard error if empty
+
+	WebhookMaxAttempts int
+}
+
+// DefaultConfig is the configuration without config file, environment or
+// flags.
+func DefaultConfig() Config {
+	return Config{
+		HTTPAddr:           DefaultHTTPAddr,
+		GrpcAddr:           ":9090",
+		DataDir:            "db",
+		Storage:            StorageSqlite,
+		Limits:             DefaultRateLimits,
+		ReadHeaderTimeout:  Duration(10 * time.Second),
+		IdleTimeout:        Duration(2 * time.Minute),
+		ShutdownTimeout:    Duration(30 * time.Second),
+		WebhookMaxAttempts: WebhookMaxAttempts,
+	}
+}
+
+// flags binds a flag for every setting to c.
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "address of the HTTP API")
+	fs.StringVar(&c.GrpcAddr, "grpc-addr", c.GrpcAddr, "address of the gRPC API, empty to disable it")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory holding the database, backups and token key")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "storage backend: sqlite or kv")
+	fs.StringVar(&c.DbPath, "db-path", c.DbPath, "database file, <data-dir>/back-a-friend.db or .kv by default")
+	fs.Var(&c.Limits.Key, "limit-key", "rate limit per API key: <requests per second>,<burst> or 0")
+	fs.Var(&c.Limits.Addr, "limit-addr", "rate limit per remote address: <requests per second>,<burst> or 0")
+	fs.Var(&c.Limits.Player, "limit-player", "rate limit per player: <requests per second>,<burst> or 0")
+	fs.Var(&c.ReadHeaderTimeout, "read-header-timeout", "time to read request headers")
+	fs.Var(&c.ReadTimeout, "read-timeout", "time to read a whole request, 0 for none")
+	fs.Var(&c.WriteTimeout, "write-timeout", "time to write a response, 0 for none; also ends event streams")
+	fs.Var(&c.IdleTimeout, "idle-timeout", "how long idle keep-alive connections are kept")
+	fs.Var(&c.ShutdownTimeout, "shutdown-timeout", "how long to wait for requests in flight and background jobs on shutdown")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "PEM certificate chain of the HTTP and gRPC APIs, serves TLS together with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "PEM private key of -tls-cert")
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", c.TLSClientCA, "PEM certificates verifying client certificates")
+	fs.BoolVar(&c.TLSRequireClientCert, "tls-require-client-cert", c.TLSRequireClientCert, "reject connections without a client certificate verified by -tls-client-ca")
+	fs.StringVar(&c.LogFile, "log-file", c.LogFile, "file to append the log to, standard error if empty")
+	fs.IntVar(&c.WebhookMaxAttempts, "webhook-max-attempts", c.WebhookMaxAttempts, "delivery attempts before a webhook delivery becomes a dead letter")
+}
+
+// EnvName returns the environment variable of the setting with the given
+// flag name.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, a JSON config file, environment variables and the command
+// line args. The config file is given with -config or BACKAFRIEND_CONFIG.
+// printConfig reports whether -print-config was given.
+func LoadConfig(name string, args []string, getenv func(string) string) (c Config, printConfig bool, err error) {
+	// the command line is parsed first for the config file and the
+	// settings given explicitly, which are applied last
+	cmd := DefaultConfig()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cmd.flags(fs)
+	configFile := fs.String("config", getenv(EnvName("config")), "JSON config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration as JSON and exit")
+	if err := fs.Parse(args); err != nil {
+		return c, false, err
+	}
+	if fs.NArg() > 0 {
+		return c, false, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	c = DefaultConfig()
+	if *configFile != "" {
+		js, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return c, false, err
+		}
+		if err := json.Unmarshal(js, &c); err != nil {
+			return c, false, fmt.Errorf("%s: %v", *configFile, err)
+		}
+	}
+
+	layers := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.flags(layers)
+	layers.VisitAll(func(f *flag.Flag) {
+		v := getenv(EnvName(f.Name))
+		if v == "" || err != nil {
+			return
+		}
+		if e := f.Value.Set(v); e != nil {
+			err = fmt.Errorf("%s: %v", EnvName(f.Name), e)
+		}
+	})
+	if err != nil {
+		return c, false, err
+	}
+	for name, v := range given {
+		if f := layers.Lookup(name); f != nil {
+			f.Value.Set(v)
+		}
+	}
+	return c, printConfig, c.validate()
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.HTTPAddr == "":
+		return errors.New("the HTTP address is required")
+	case c.DataDir == "":
+		return errors.New("the data directory is required")
+	case c.TLSCert == "" != (c.TLSKey == ""):
+		return errors.New("TLS needs both a certificate and a key")
+	case c.TLSClientCA != "" && c.TLSCert == "":
+		return errors.New("client certificates need TLS")
+	case c.TLSRequireClientCert && c.TLSClientCA == "":
+		return errors.New("requiring client certificates needs a client CA")
+	case c.ReadHeaderTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0:
+		return errors.New("timeouts must not be negative")
+	case c.WebhookMaxAttempts < 1:
+		return errors.New("webhooks need at least one delivery attempt")
+	}
+	if _, ok := StorageFiles[c.Storage]; !ok {
+		return ErrUnknownStorage
+	}
+	for name, id := range c.ClientCerts {
+		if !Roles[id.Role] || (id.Role == RolePlayer) != (id.PlayerId != "") {
+			return fmt.Errorf("invalid identity of client certificate %q", name)
+		}
+	}
+	return nil
+}
+
+// dbPath returns the database file.
+func (c *Config) dbPath() string {
+	if c.DbPath != "" {
+		return c.DbPath
+	}
+	return path.Join(c.DataDir, StorageFiles[c.Storage])
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig_Layers(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"HTTPAddr": ":8000", "Storage": "kv", "DataDir": "/var/lib/baf", "Limits": {"Player": "1,2"}, "ShutdownTimeout": "5s"}`)
+	f.Close()
+
+	env := map[string]string{
+		"BACKAFRIEND_CONFIG":       f.Name(),
+		"BACKAFRIEND_HTTP_ADDR":    ":8001",
+		"BACKAFRIEND_STORAGE":      "sqlite",
+		"BACKAFRIEND_IDLE_TIMEOUT": "1m",
+	}
+	c, printConfig, err := LoadConfig("test", []string{"-storage", "kv", "-print-config"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printConfig {
+		t.Error("print-config")
+	}
+
+	want := DefaultConfig()
+	want.HTTPAddr = ":8001"          // environment over file
+	want.Storage = StorageKv         // flag over environment
+	want.DataDir = "/var/lib/baf"    // file over default
+	want.Limits.Player = Limit{1, 2} // nested in the file
+	want.ShutdownTimeout = Duration(5 * time.Second)
+	want.IdleTimeout = Duration(time.Minute)
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v\nwant %+v", c, want)
+	}
+	if p := c.dbPath(); p != "/var/lib/baf/back-a-friend.kv" {
+		t.Error(p)
+	}
+}
+
+func TestConfig_Invalid(t *testing.T) {
+	none := func(string) string { return "" }
+	for _, args := range [][]string{
+		{"-storage", "mysql"},
+		{"-tls-cert", "cert.pem"},
+		{"-tls-require-client-cert"},
+		{"-read-timeout", "-1s"},
+		{"-limit-key", "fast"},
+		{"extra"},
+	} {
+		if _, _, err := LoadConfig("test", args, none); err == nil {
+			t.Error(args)
+		}
+	}
+
+	env := func(k string) string {
+		if k == "BACKAFRIEND_WEBHOOK_MAX_ATTEMPTS" {
+			return "many"
+		}
+		return ""
+	}
+	if _, _, err := LoadConfig("test", nil, env); err == nil {
+		t.Error("invalid environment variable accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"api/apierr"
+)
+
+var (
+	ErrMethodNotAllowed = apierr.New(apierr.CodeMethodNotAllowed, "Method not allowed")
+	ErrNoSuchResource   = apierr.New(apierr.CodeNotFound, "No such resource")
+	ErrBackupNotFound   = apierr.New(apierr.CodeNotFound, "Backup not found")
+	errInvalidBody      = apierr.New(apierr.CodeInvalidArgument, "Invalid request body")
+	errBodyTooLarge     = apierr.New(apierr.CodeBodyTooLarge, "Request body too large")
+)
+
+// maxBodySize bounds the JSON bodies read by the server and the bodies of
+// requests carrying an Idempotency-Key. Other imports are read by their
+// handler and not limited.
+const maxBodySize = 1 << 20
+
+// ErrorBody is the response body of every failed request.
+type ErrorBody struct {
+	Code  apierr.Code
+	Error string
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// writeError responds with the status of the code of err.
+func writeError(w http.ResponseWriter, err error) {
+	code := apierr.CodeOf(err)
+	writeJSON(w, code.Status(), ErrorBody{code, err.Error()})
+}
+
+// badRequest rejects a request with missing or malformed parameters.
+func badRequest(w http.ResponseWriter, params ...string) {
+	writeError(w, apierr.Invalid("Missing or invalid parameter: %s", strings.Join(params, ", ")))
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"api"
+)
+
+// Types of events.
+const (
+	EventAnnounced        = "announced"
+	EventJoined           = "joined"
+	EventBackingRequested = "backing_requested"
+	EventBackingAccepted  = "backing_accepted"
+	EventSettled          = "settled"
+	EventFunded           = "funded"
+	EventBalanceChanged   = "balance_changed"
+)
+
+// Event is published after a change succeeded. Ids increase by one with
+// every event and start over when the server restarts.
+type Event struct {
+	Id           uint64
+	Type         string
+	TournamentId int          `json:",omitempty"`
+	PlayerId     string       `json:",omitempty"`
+	BackerId     string       `json:",omitempty"`
+	Backers      []string     `json:",omitempty"`
+	Deposit      int          `json:",omitempty"`
+	Prize        int          `json:",omitempty"`
+	Points       int          `json:",omitempty"` // points added, for funded
+	Payouts      []api.Payout `json:",omitempty"`
+	Balance      *int         `json:",omitempty"` // new balance of PlayerId, for balance_changed
+	At           time.Time
+}
+
+// private reports whether e concerns the account of e.PlayerId, which only
+// the player and operators may see.
+func (e Event) private() bool {
+	return e.Type == EventFunded || e.Type == EventBalanceChanged
+}
+
+// involves reports whether playerId took part in e as player, backer or
+// payee.
+func (e Event) involves(playerId string) bool {
+	if e.PlayerId == playerId || e.BackerId == playerId {
+		return true
+	}
+	for _, b := range e.Backers {
+		if b == playerId {
+			return true
+		}
+	}
+	for _, p := range e.Payouts {
+		if p.PlayerId == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// eventBufferSize is how many events a subscriber may fall behind
+	// before its subscription is ended.
+	eventBufferSize = 64
+	// eventHistorySize is how many past events are kept for subscribers
+	// resuming after a reconnect.
+	eventHistorySize = 1024
+)
+
+// eventBroker fans events out to subscribers. Publishing never blocks on
+// a slow subscriber; its channel is closed instead, so it can resume from
+// the last event it received.
+type eventBroker struct {
+	mux     sync.Mutex
+	lastId  uint64
+	history []Event
+	subs    map[chan Event]bool
+	closing chan struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan Event]bool), closing: make(chan struct{})}
+}
+
+// shutdown tells streaming subscribers to end, the server is shutting
+// down. Events are still published and kept for resuming afterwards.
+func (b *eventBroker) shutdown() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	select {
+	case <-b.closing:
+	default:
+		close(b.closing)
+	}
+}
+
+// stopping is closed by shutdown.
+func (b *eventBroker) stopping() <-chan struct{} {
+	return b.closing
+}
+
+// subscribe returns a channel receiving every event published from now on
+// and a function ending the subscription.
+func (b *eventBroker) subscribe() (<-chan Event, func()) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.add()
+}
+
+// resume subscribes like subscribe and also returns the kept events
+// published after the event lastId. An id the broker has not reached yet
+// was issued before a restart, all kept events are returned for it.
+func (b *eventBroker) resume(lastId uint64) ([]Event, <-chan Event, func()) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	var missed []Event
+	for _, e := range b.history {
+		if e.Id > lastId || lastId > b.lastId {
+			missed = append(missed, e)
+		}
+	}
+	ch, cancel := b.add()
+	return missed, ch, cancel
+}
+
+// last returns the id of the last published event.
+func (b *eventBroker) last() uint64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.lastId
+}
+
+// add adds a subscriber, b.mux must be held.
+func (b *eventBroker) add() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.subs[ch] = true
+
+	return ch, func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		if b.subs[ch] {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) publish(e Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.lastId++
+	e.Id = b.lastId
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[1:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// forwardEvents publishes the domain events of bus to events, turning
+// them into the events clients see. It subscribes synchronously, so the
+// events keep the order of the changes.
+func forwardEvents(bus *api.Bus, events *eventBroker) {
+	bus.Subscribe(func(de api.Event) {
+		for _, e := range clientEvents(de) {
+			events.publish(e)
+		}
+	})
+}
+
+func balanceChanged(tourId int, playerId string, balance int, at time.Time) Event {
+	return Event{Type: EventBalanceChanged, TournamentId: tourId, PlayerId: playerId, Balance: &balance, At: at}
+}
+
+// clientEvents returns the events of a domain event, none for those
+// clients are not told about.
+func clientEvents(de api.Event) []Event {
+	switch e := de.(type) {
+	case api.PlayerFunded:
+		return []Event{
+			{Type: EventFunded, PlayerId: e.PlayerId, Points: e.Points, At: e.At},
+			balanceChanged(0, e.PlayerId, e.Balance, e.At),
+		}
+	case api.PointsTaken:
+		return []Event{balanceChanged(0, e.PlayerId, e.Balance, e.At)}
+	case api.TournamentAnnounced:
+		return []Event{{Type: EventAnnounced, TournamentId: e.TournamentId, Deposit: e.Deposit, At: e.At}}
+	case api.PlayerJoined:
+		return []Event{
+			{Type: EventJoined, TournamentId: e.TournamentId, PlayerId: e.PlayerId, Backers: e.Backers, At: e.At},
+			balanceChanged(e.TournamentId, e.PlayerId, e.Balance, e.At),
+		}
+	case api.BackingTaken:
+		return []Event{balanceChanged(e.TournamentId, e.BackerId, e.Balance, e.At)}
+	case api.BackingRequested:
+		return []Event{{Type: EventBackingRequested, TournamentId: e.TournamentId, PlayerId: e.PlayerId, BackerId: e.BackerId, At: e.At}}
+	case api.BackingAccepted:
+		return []Event{{Type: EventBackingAccepted, TournamentId: e.TournamentId, PlayerId: e.PlayerId, BackerId: e.BackerId, At: e.At}}
+	case api.TournamentSettled:
+		if e.Winner.PlayerId == "" {
+			return nil
+		}
+		res := []Event{{Type: EventSettled, TournamentId: e.TournamentId, PlayerId: e.Winner.PlayerId, Prize: e.Winner.Prize, Payouts: e.Winner.Payouts, At: e.At}}
+		for _, p := range e.Winner.Payouts {
+			res = append(res, balanceChanged(e.TournamentId, p.PlayerId, e.Balances[p.PlayerId], e.At))
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"api"
+	"api/db"
+)
+
+var contentTypes = map[string]string{
+	db.FormatJSONL: "application/x-ndjson",
+	db.FormatCSV:   "text/csv",
+}
+
+func exportFormat(r *http.Request) (string, bool) {
+	format, ok := r.URL.Query()["format"]
+	if !ok {
+		return db.FormatJSONL, true
+	}
+	if len(format) > 1 {
+		return "", false
+	}
+	_, ok = contentTypes[format[0]]
+	return format[0], ok
+}
+
+type exportHandler struct {
+	a api.Api
+}
+
+func newExportHandler(a api.Api) http.Handler {
+	return exportHandler{a}
+}
+
+func (h exportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormat(r)
+	if !ok {
+		badRequest(w, "format")
+		return
+	}
+
+	// buffer the export so that a failure can still be reported
+	var buf bytes.Buffer
+	if err := h.a.Export(r.Context(), &buf, format); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Write(buf.Bytes())
+}
+
+type importHandler struct {
+	a api.Api
+}
+
+func newImportHandler(a api.Api) http.Handler {
+	return importHandler{a}
+}
+
+func (h importHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, ErrMethodNotAllowed)
+		return
+	}
+
+	format, ok := exportFormat(r)
+	if !ok {
+		badRequest(w, "format")
+		return
+	}
+
+	if err := h.a.Import(r.Context(), r.Body, format); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
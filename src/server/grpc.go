@@ -0,0 +1,470 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"api"
+	"api/apierr"
+	"api/db"
+	"logging"
+	"rpc"
+)
+
+// ErrorCodeTrailer is the trailer carrying the apierr code of a failed
+// call.
+const ErrorCodeTrailer = "error-code"
+
+var grpcCodes = map[apierr.Code]codes.Code{
+	apierr.CodeInvalidArgument:         codes.InvalidArgument,
+	apierr.CodeUnauthenticated:         codes.Unauthenticated,
+	apierr.CodeForbidden:               codes.PermissionDenied,
+	apierr.CodeNotFound:                codes.NotFound,
+	apierr.CodePlayerNotFound:          codes.NotFound,
+	apierr.CodeTournamentNotFound:      codes.NotFound,
+	apierr.CodeSeasonNotFound:          codes.NotFound,
+	apierr.CodeBackingRequestNotFound:  codes.NotFound,
+	apierr.CodeMethodNotAllowed:        codes.Unimplemented,
+	apierr.CodeBodyTooLarge:            codes.ResourceExhausted,
+	apierr.CodeAlreadyExists:           codes.AlreadyExists,
+	apierr.CodeTournamentAlreadyActive: codes.FailedPrecondition,
+	apierr.CodeTournamentNotRunning:    codes.FailedPrecondition,
+	apierr.CodeTournamentRunning:       codes.FailedPrecondition,
+	apierr.CodeInsufficientFunds:       codes.FailedPrecondition,
+	apierr.CodeRateLimited:             codes.ResourceExhausted,
+	apierr.CodeRequestInProgress:       codes.Aborted,
+	apierr.CodeNotImplemented:          codes.Unimplemented,
+}
+
+type errorCodeKey struct{}
+
+// grpcError turns err into a status error and sends its apierr code in the
+// ErrorCodeTrailer. The code is also kept for the audit log.
+func grpcError(ctx context.Context, err error) error {
+	code := apierr.CodeOf(err)
+	grpc.SetTrailer(ctx, metadata.Pairs(ErrorCodeTrailer, string(code)))
+	if c, ok := ctx.Value(errorCodeKey{}).(*apierr.Code); ok {
+		*c = code
+	}
+
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Internal
+	}
+	return status.Error(c, err.Error())
+}
+
+var rpcRoles = map[string][]string{
+	"Take":               {RoleOperator, RoleGameServer, RolePlayer},
+	"Fund":               {RoleOperator},
+	"AnnounceTournament": {RoleOperator, RoleGameServer},
+	"JoinTournament":     {RoleOperator, RoleGameServer, RolePlayer},
+	"ResultTournament":   {RoleOperator, RoleGameServer},
+	"ActiveTournament":   {RoleOperator, RoleGameServer, RolePlayer},
+	"Balance":            {RoleOperator, RoleGameServer, RolePlayer},
+	"SeasonBalance":      {RoleOperator, RoleGameServer, RolePlayer},
+	"Reset":              {RoleOperator},
+	"Backup":             {RoleOperator},
+	"Restore":            {RoleOperator},
+	"Export":             {RoleOperator},
+	"Import":             {RoleOperator},
+	"CloseSeason":        {RoleOperator},
+	"Leaderboard":        {RoleOperator, RoleGameServer, RolePlayer},
+	"RequestBacking":     {RoleOperator, RoleGameServer, RolePlayer},
+	"AcceptBacking":      {RoleOperator, RoleGameServer, RolePlayer},
+	"AcceptedBackers":    {RoleOperator, RoleGameServer, RolePlayer},
+	"WatchTournaments":   {RoleOperator, RoleGameServer, RolePlayer},
+}
+
+// rpcPlayer is the player a call acts on, if any.
+func rpcPlayer(req interface{}) string {
+	switch r := req.(type) {
+	case *rpc.PointsRequest:
+		return r.PlayerId
+	case *rpc.JoinRequest:
+		return r.PlayerId
+	case *rpc.BalanceRequest:
+		return r.PlayerId
+	case *rpc.BackingRequest:
+		return r.PlayerId
+	case *rpc.BackersRequest:
+		return r.PlayerId
+	}
+	return ""
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// authorize authenticates a call with the same credentials and rate limits
+// as HTTP requests: an "x-api-key" or an "authorization" bearer token in
+// the metadata or, without either, a mapped client certificate. It returns
+// the principal as far as it got.
+func (au authenticator) authorize(ctx context.Context, fullMethod string, req interface{}) (context.Context, Principal, error) {
+	now := time.Now()
+	if pr, ok := peer.FromContext(ctx); ok {
+		if ok, _ := au.limits.allowAddr(hostOf(pr.Addr.String()), now); !ok {
+			return nil, Principal{}, ErrRateLimited
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	p, err := au.callPrincipal(ctx, firstValue(md, "authorization"), firstValue(md, ApiKeyHeader))
+	if err != nil {
+		return nil, p, err
+	}
+
+	if !containsAll(rpcRoles[path.Base(fullMethod)], []string{p.Role}) {
+		return nil, p, ErrForbidden
+	}
+
+	player := p.PlayerId
+	if player == "" {
+		player = rpcPlayer(req)
+	}
+	if ok, _ := au.limits.allowPrincipal(p, player, now); !ok {
+		return nil, p, ErrRateLimited
+	}
+	return context.WithValue(ctx, principalKey{}, p), p, nil
+}
+
+func (au authenticator) callPrincipal(ctx context.Context, auth string, key string) (Principal, error) {
+	if pr, ok := peer.FromContext(ctx); ok && auth == "" && key == "" {
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			if p, ok := au.certPrincipal(&info.State); ok {
+				return p, nil
+			}
+		}
+	}
+	return au.credentials(auth, key)
+}
+
+// withCallId gives a call the id sent in the x-request-id metadata, or a
+// new one, and sends it back in the header.
+func withCallId(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := requestId(firstValue(md, logging.RequestIdHeader))
+	grpc.SetHeader(ctx, metadata.Pairs(logging.RequestIdHeader, id))
+	return logging.WithRequestId(ctx, id)
+}
+
+func (au authenticator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx = withCallId(ctx)
+	authorized, p, err := au.authorize(ctx, info.FullMethod, req)
+	if err != nil {
+		observeCall(info.FullMethod, apierr.CodeOf(err), start)
+		logCall(ctx, info.FullMethod, p, apierr.CodeOf(err), start)
+		return nil, grpcError(ctx, err)
+	}
+
+	var code apierr.Code
+	res, err := handler(context.WithValue(authorized, errorCodeKey{}, &code), req)
+	if err != nil && code == "" {
+		code = apierr.CodeOf(err)
+	}
+	au.audit.recordCall(ctx, info.FullMethod, p, req, code)
+	observeCall(info.FullMethod, code, start)
+	logCall(ctx, info.FullMethod, p, code, start)
+	return res, err
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (au authenticator) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	authorized, _, err := au.authorize(ss.Context(), info.FullMethod, nil)
+	if err != nil {
+		return grpcError(ss.Context(), err)
+	}
+	return handler(srv, authorizedStream{ss, authorized})
+}
+
+// rpcServer implements the gRPC service on top of the same Api as the
+// HTTP handlers.
+type rpcServer struct {
+	a         api.Api
+	events    *eventBroker
+	backupDir string
+	backupExt string
+}
+
+// newGrpcServer returns a gRPC server for a. Messages are encoded with
+// rpc.Codec whatever codec a client asks for.
+func newGrpcServer(a api.Api, auth authenticator, events *eventBroker, backupDir string, backupExt string, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ForceServerCodec(rpc.Codec{}),
+		grpc.UnaryInterceptor(auth.unaryInterceptor),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	}, opts...)...)
+	rpc.RegisterBackAFriendServer(s, rpcServer{a, events, backupDir, backupExt})
+	return s
+}
+
+// rpcActAs rejects calls acting on an account the caller is not bound to.
+func rpcActAs(ctx context.Context, playerId string) error {
+	if !principalFromContext(ctx).canActAs(playerId) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (s rpcServer) Take(ctx context.Context, in *rpc.PointsRequest) (*rpc.Empty, error) {
+	if in.Points <= 0 {
+		return nil, grpcError(ctx, apierr.Invalid("Points must be positive"))
+	}
+	if err := rpcActAs(ctx, in.PlayerId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if err := s.a.Take(ctx, in.PlayerId, int(in.Points)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) Fund(ctx context.Context, in *rpc.PointsRequest) (*rpc.Empty, error) {
+	if in.Points <= 0 || in.PlayerId == "" {
+		return nil, grpcError(ctx, apierr.Invalid("PlayerId and positive Points are required"))
+	}
+	if err := s.a.Fund(ctx, in.PlayerId, int(in.Points)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) AnnounceTournament(ctx context.Context, in *rpc.TournamentRequest) (*rpc.Empty, error) {
+	if in.Deposit <= 0 {
+		return nil, grpcError(ctx, apierr.Invalid("Deposit must be positive"))
+	}
+	if err := s.a.AnnounceTournament(ctx, int(in.TournamentId), int(in.Deposit)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) JoinTournament(ctx context.Context, in *rpc.JoinRequest) (*rpc.Empty, error) {
+	if err := rpcActAs(ctx, in.PlayerId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	err := checkBackers(ctx, s.a, principalFromContext(ctx), int(in.TournamentId), in.PlayerId, in.Backers)
+	if err == nil {
+		err = s.a.JoinTournament(ctx, int(in.TournamentId), in.PlayerId, in.Backers)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) ResultTournament(ctx context.Context, in *rpc.Empty) (*rpc.Winner, error) {
+	w, err := s.a.ResultTournament(ctx)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Winner{PlayerId: w.PlayerId, Prize: int64(w.Prize)}, nil
+}
+
+func (s rpcServer) ActiveTournament(ctx context.Context, in *rpc.Empty) (*rpc.ActiveTournamentReply, error) {
+	id, err := s.a.ActiveTournament(ctx)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.ActiveTournamentReply{TournamentId: int64(id)}, nil
+}
+
+func (s rpcServer) Balance(ctx context.Context, in *rpc.BalanceRequest) (*rpc.Balance, error) {
+	if err := rpcActAs(ctx, in.PlayerId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	b, err := s.a.Balance(ctx, in.PlayerId)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Balance{PlayerId: in.PlayerId, Balance: int64(b)}, nil
+}
+
+func (s rpcServer) SeasonBalance(ctx context.Context, in *rpc.BalanceRequest) (*rpc.Balance, error) {
+	if err := rpcActAs(ctx, in.PlayerId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	b, err := s.a.SeasonBalance(ctx, int(in.SeasonId), in.PlayerId)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Balance{PlayerId: in.PlayerId, Balance: int64(b)}, nil
+}
+
+func (s rpcServer) Reset(ctx context.Context, in *rpc.Empty) (*rpc.Empty, error) {
+	if err := s.a.Reset(ctx); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) Backup(ctx context.Context, in *rpc.Empty) (*rpc.BackupFile, error) {
+	name := backupName(s.backupExt)
+	if err := s.a.Backup(ctx, path.Join(s.backupDir, name)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.BackupFile{File: name}, nil
+}
+
+func (s rpcServer) Restore(ctx context.Context, in *rpc.BackupFile) (*rpc.Empty, error) {
+	backupPath, err := findBackup(s.backupDir, in.File)
+	if err == nil {
+		err = s.a.Restore(ctx, backupPath)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) Export(ctx context.Context, in *rpc.ExportRequest) (*rpc.Records, error) {
+	format := in.Format
+	if format == "" {
+		format = db.FormatJSONL
+	}
+
+	var buf bytes.Buffer
+	if err := s.a.Export(ctx, &buf, format); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Records{Format: format, Data: buf.Bytes()}, nil
+}
+
+func (s rpcServer) Import(ctx context.Context, in *rpc.Records) (*rpc.Empty, error) {
+	format := in.Format
+	if format == "" {
+		format = db.FormatJSONL
+	}
+
+	if err := s.a.Import(ctx, bytes.NewReader(in.Data), format); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) CloseSeason(ctx context.Context, in *rpc.CarryOver) (*rpc.Season, error) {
+	if in.Percent < 0 || in.Percent > 100 || in.Max < 0 {
+		return nil, grpcError(ctx, apierr.Invalid("Invalid carry over"))
+	}
+	season, err := s.a.CloseSeason(ctx, api.CarryOver{Percent: int(in.Percent), Max: int(in.Max)})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Season{Id: int64(season.Id), StartedAt: unix(season.StartedAt), ClosedAt: unix(season.ClosedAt)}, nil
+}
+
+func (s rpcServer) Leaderboard(ctx context.Context, in *rpc.LeaderboardRequest) (*rpc.Leaderboard, error) {
+	standings, err := s.a.Leaderboard(ctx, int(in.SeasonId))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	res := &rpc.Leaderboard{}
+	for _, st := range standings {
+		res.Standings = append(res.Standings, &rpc.Standing{
+			PlayerId: st.PlayerId,
+			Rank:     int64(st.Rank),
+			Points:   int64(st.Points),
+			Played:   int64(st.Played),
+			Won:      int64(st.Won),
+		})
+	}
+	return res, nil
+}
+
+func (s rpcServer) RequestBacking(ctx context.Context, in *rpc.BackingRequest) (*rpc.Empty, error) {
+	err := rpcActAs(ctx, in.PlayerId)
+	if err == nil {
+		err = s.a.RequestBacking(ctx, int(in.TournamentId), in.PlayerId, in.BackerId)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) AcceptBacking(ctx context.Context, in *rpc.BackingRequest) (*rpc.Empty, error) {
+	// only the backer can accept a request addressed to it
+	err := rpcActAs(ctx, in.BackerId)
+	if err == nil {
+		err = s.a.AcceptBacking(ctx, int(in.TournamentId), in.PlayerId, in.BackerId)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s rpcServer) AcceptedBackers(ctx context.Context, in *rpc.BackersRequest) (*rpc.Backers, error) {
+	if err := rpcActAs(ctx, in.PlayerId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	backers, err := s.a.AcceptedBackers(ctx, int(in.TournamentId), in.PlayerId)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &rpc.Backers{BackerIds: backers}, nil
+}
+
+func (s rpcServer) WatchTournaments(in *rpc.Empty, stream rpc.BackAFriend_WatchTournamentsServer) error {
+	events, cancel := s.events.subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.events.stopping():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e.private() {
+				continue
+			}
+			err := stream.Send(&rpc.TournamentEvent{
+				Type:         e.Type,
+				TournamentId: int64(e.TournamentId),
+				PlayerId:     e.PlayerId,
+				BackerId:     e.BackerId,
+				Backers:      e.Backers,
+				Deposit:      int64(e.Deposit),
+				Prize:        int64(e.Prize),
+				At:           unix(e.At),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"api"
+	"api/apierr"
+	"api/db"
+	"rpc"
+)
+
+func setupGrpc(t *testing.T) (rpc.BackAFriendClient, string, *eventBroker, func()) {
+	s := db.CreateMemDb()
+	core, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := newEventBroker()
+	forwardEvents(core.Bus(), events)
+	srv := newGrpcServer(core, authenticator{s, NewTokenSigner([]byte("secret")), nil, nil, nil, nil}, events, "", ".db")
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) { return lis.Dial() }
+	opts := append(rpc.DialOptions(), grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rpc.NewBackAFriendClient(conn), key, events, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func (b *eventBroker) subscribers() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.subs)
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), ApiKeyHeader, key)
+}
+
+func TestGrpc_Tournament(t *testing.T) {
+	c, key, broker, stop := setupGrpc(t)
+	defer stop()
+	ctx := withKey(key)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := c.WatchTournaments(watchCtx, &rpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream is only subscribed once the server saw the call
+	for i := 0; i < 100 && broker.subscribers() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, id := range []string{"P1", "P2"} {
+		if _, err := c.Fund(ctx, &rpc.PointsRequest{PlayerId: id, Points: 500}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.AnnounceTournament(ctx, &rpc.TournamentRequest{TournamentId: 1, Deposit: 400}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"P1", "P2"} {
+		if _, err := c.JoinTournament(ctx, &rpc.JoinRequest{TournamentId: 1, PlayerId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	winner, err := c.ResultTournament(ctx, &rpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winner.Prize != 800 {
+		t.Error(winner)
+	}
+
+	want := []string{EventAnnounced, EventJoined, EventJoined, EventSettled}
+	for _, typ := range want {
+		e, err := events.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != typ || e.TournamentId != 1 {
+			t.Error(e)
+		}
+	}
+
+	b, err := c.Balance(ctx, &rpc.BalanceRequest{PlayerId: winner.PlayerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Balance != 900 {
+		t.Error(b)
+	}
+}
+
+func TestGrpc_Errors(t *testing.T) {
+	c, key, _, stop := setupGrpc(t)
+	defer stop()
+
+	_, err := c.Balance(context.Background(), &rpc.BalanceRequest{PlayerId: "P1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error(err)
+	}
+
+	ctx := withKey(key)
+	if _, err := c.Fund(ctx, &rpc.PointsRequest{PlayerId: "P1", Points: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	var trailer metadata.MD
+	_, err = c.Take(ctx, &rpc.PointsRequest{PlayerId: "P1", Points: 20}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Error(err)
+	}
+	if got := trailer.Get(ErrorCodeTrailer); len(got) != 1 || got[0] != string(apierr.CodeInsufficientFunds) {
+		t.Error(trailer)
+	}
+
+	if _, err := c.Leaderboard(ctx, &rpc.LeaderboardRequest{SeasonId: 9}); status.Code(err) != codes.NotFound {
+		t.Error(err)
+	}
+	if _, err := c.Take(ctx, &rpc.PointsRequest{PlayerId: "P1", Points: 0}); status.Code(err) != codes.InvalidArgument {
+		t.Error(err)
+	}
+}
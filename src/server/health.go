@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api/db"
+)
+
+// readinessTimeout bounds each readiness check.
+const readinessTimeout = 2 * time.Second
+
+var (
+	errShuttingDown  = errors.New("shutting down")
+	errNotDelivering = errors.New("webhook deliveries are not running")
+)
+
+// Health is the answer of /healthz.
+type Health struct {
+	Status string
+}
+
+// Readiness is the answer of /readyz: whether the instance can serve
+// requests and the result of every check.
+type Readiness struct {
+	Ready  bool
+	Checks []CheckResult
+}
+
+type CheckResult struct {
+	Name  string
+	Ok    bool
+	Error string `json:",omitempty"`
+	Took  Duration
+}
+
+// readinessCheck is one condition of readiness.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// runChecks runs the checks at the same time and fails those that take
+// longer than timeout.
+func runChecks(checks []readinessCheck, timeout time.Duration) Readiness {
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(checks))
+	start := time.Now()
+	for i, c := range checks {
+		go func(i int, check func() error) {
+			results <- result{i, check()}
+		}(i, c.check)
+	}
+
+	res := Readiness{Ready: true, Checks: make([]CheckResult, len(checks))}
+	for i, c := range checks {
+		res.Checks[i] = CheckResult{Name: c.name, Error: fmt.Sprintf("timed out after %v", timeout), Took: Duration(timeout)}
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for n := 0; n < len(checks); n++ {
+		select {
+		case r := <-results:
+			c := &res.Checks[r.i]
+			c.Ok, c.Error, c.Took = r.err == nil, "", Duration(time.Since(start))
+			if r.err != nil {
+				c.Error = r.err.Error()
+			}
+		case <-deadline.C:
+			n = len(checks)
+		}
+	}
+	for _, c := range res.Checks {
+		res.Ready = res.Ready && c.Ok
+	}
+	return res
+}
+
+type healthHandler struct{}
+
+func newHealthHandler() http.Handler {
+	return healthHandler{}
+}
+
+// ServeHTTP answers as long as the process serves HTTP at all.
+func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Health{"ok"})
+}
+
+type readinessHandler struct {
+	checks []readinessCheck
+}
+
+// newReadinessHandler checks that store answers a query, that its schema
+// is current if it is versioned, and that the background workers run.
+func newReadinessHandler(store db.Storage, hooks *webhooks) http.Handler {
+	checks := []readinessCheck{
+		{"storage", func() error {
+			_, err := store.CurrentSeason()
+			return err
+		}},
+	}
+	if v, ok := store.(db.Versioned); ok {
+		checks = append(checks, readinessCheck{"migrations", func() error {
+			version, err := v.SchemaVersion()
+			if err != nil {
+				return err
+			}
+			if version != db.LatestSchemaVersion {
+				return fmt.Errorf("schema version %d, want %d", version, db.LatestSchemaVersion)
+			}
+			return nil
+		}})
+	}
+	checks = append(checks, readinessCheck{"workers", func() error {
+		select {
+		case <-hooks.events.stopping():
+			return errShuttingDown
+		default:
+		}
+		if !hooks.isRunning() {
+			return errNotDelivering
+		}
+		return nil
+	}})
+	return readinessHandler{checks}
+}
+
+// ServeHTTP answers 200 if every check passes and 503 otherwise.
+func (h readinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res := runChecks(h.checks, readinessTimeout)
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, res)
+}
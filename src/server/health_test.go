@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"api/db"
+)
+
+func readiness(t *testing.T, h http.Handler) (int, map[string]CheckResult) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	var res Readiness
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	checks := make(map[string]CheckResult)
+	for _, c := range res.Checks {
+		checks[c.Name] = c
+	}
+	if res.Ready != (w.Code == http.StatusOK) {
+		t.Error("status does not match", w.Code, res)
+	}
+	return w.Code, checks
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := OpenStorage(path.Join(dir, StorageFiles[StorageSqlite]), StorageSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	hooks := newWebhooks(s, newEventBroker())
+	h := newReadinessHandler(s, hooks)
+	code, checks := readiness(t, h)
+	if code != http.StatusServiceUnavailable || !checks["storage"].Ok || !checks["migrations"].Ok || checks["workers"].Error != errNotDelivering.Error() {
+		t.Error("workers not started", code, checks)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		hooks.run(stop)
+		close(stopped)
+	}()
+	for i := 0; !hooks.isRunning() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, checks := readiness(t, h); code != http.StatusOK || len(checks) != 3 {
+		t.Error("ready", code, checks)
+	}
+
+	hooks.events.shutdown()
+	if code, checks := readiness(t, h); code != http.StatusServiceUnavailable || checks["workers"].Error != errShuttingDown.Error() {
+		t.Error("shutting down", code, checks)
+	}
+	close(stop)
+	<-stopped
+
+	s.Stop()
+	if code, checks := readiness(t, h); code != http.StatusServiceUnavailable || checks["storage"].Ok || checks["migrations"].Ok {
+		t.Error("closed storage", code, checks)
+	}
+}
+
+// oldSchema is a storage whose schema is behind the binary.
+type oldSchema struct {
+	db.Storage
+}
+
+func (oldSchema) SchemaVersion() (int, error) {
+	return db.LatestSchemaVersion - 1, nil
+}
+
+func TestHealth_OutdatedSchema(t *testing.T) {
+	h := newReadinessHandler(oldSchema{db.CreateMemDb()}, newWebhooks(db.CreateMemDb(), newEventBroker()))
+	if _, checks := readiness(t, h); checks["migrations"].Ok || checks["migrations"].Error == "" {
+		t.Error(checks)
+	}
+
+	// storages without schema versions skip the check
+	h = newReadinessHandler(db.CreateMemDb(), newWebhooks(db.CreateMemDb(), newEventBroker()))
+	if _, checks := readiness(t, h); len(checks) != 2 {
+		t.Error(checks)
+	}
+}
+
+func TestHealth_CheckTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	checks := []readinessCheck{
+		{"fast", func() error { return nil }},
+		{"stuck", func() error { <-block; return nil }},
+	}
+
+	start := time.Now()
+	res := runChecks(checks, 50*time.Millisecond)
+	if took := time.Since(start); took > time.Second {
+		t.Error("waited for the stuck check", took)
+	}
+	if res.Ready || !res.Checks[0].Ok || res.Checks[1].Ok || res.Checks[1].Error != "timed out after 50ms" {
+		t.Error(res)
+	}
+}
+
+func TestHealth_ProbesNeedNoKey(t *testing.T) {
+	srv, _, cleanup := startServer(t, nil)
+	defer cleanup()
+	defer srv.Shutdown(context.Background())
+
+	// the probes need no API key and the workers run before Start returns
+	for _, probe := range []string{"/healthz", "/readyz"} {
+		resp, err := http.Get("http://" + srv.HTTPAddr() + probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Error(probe, resp.StatusCode)
+		}
+	}
+}
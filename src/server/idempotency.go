@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"api/apierr"
+)
+
+// IdempotencyHeader carries a client chosen key of a request. A request
+// repeated by the same caller with the same key and body gets the response
+// of the first one instead of being applied twice. GET requests change
+// nothing and ignore the key. Responses are kept in the memory of the
+// process only: they are lost on a restart and not shared between
+// replicas.
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyTTL is how long responses are kept for replays.
+const IdempotencyTTL = 24 * time.Hour
+
+// IdempotencyMaxEntries bounds the responses kept for replays, the oldest
+// are dropped first.
+const IdempotencyMaxEntries = 100000
+
+var (
+	ErrRequestInProgress = apierr.New(apierr.CodeRequestInProgress, "A request with this Idempotency-Key is in progress")
+	ErrIdempotencyReused = apierr.New(apierr.CodeInvalidArgument, "Idempotency-Key was used for a different request")
+	ErrIdempotencyFull   = apierr.New(apierr.CodeRateLimited, "Too many requests with an Idempotency-Key in progress")
+)
+
+// storedResponse is the response of a request, or a marker for a request
+// still being served if done is false.
+type storedResponse struct {
+	id          string
+	fingerprint string
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyCache keeps the responses of authenticated requests carrying
+// an Idempotency-Key for ttl, at most max of them. Keys are scoped to the
+// principal, so a caller never gets the response of another one, and a
+// replay is only served to a caller that authenticated again.
+type idempotencyCache struct {
+	ttl time.Duration
+	max int
+
+	mux       sync.Mutex
+	responses map[string]*list.Element // of *storedResponse
+	order     *list.List               // oldest first, the order they expire in
+}
+
+func newIdempotencyCache(ttl time.Duration, max int) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, max: max, responses: make(map[string]*list.Element), order: list.New()}
+}
+
+// captureWriter passes a response through and keeps a copy of it.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func hashOf(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serve passes r, authenticated as p, to h unless it repeats a request
+// of p with the same key. A nil cache passes every request on.
+func (c *idempotencyCache) serve(w http.ResponseWriter, r *http.Request, p Principal, h http.Handler) {
+	key := r.Header.Get(IdempotencyHeader)
+	if c == nil || key == "" || r.Method == http.MethodGet {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		if len(body) == maxBodySize {
+			writeError(w, errBodyTooLarge)
+			return
+		}
+		writeError(w, errInvalidBody)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	id := hashOf([]byte(p.Role), []byte(p.KeyId), []byte(p.PlayerId), []byte(key))
+	fingerprint := hashOf([]byte(r.Method), []byte(r.URL.String()), body)
+
+	stored, replay, err := c.reserve(id, fingerprint, time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if replay {
+		c.replay(w, stored, fingerprint)
+		return
+	}
+
+	cw := &captureWriter{ResponseWriter: w}
+	h.ServeHTTP(cw, r)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if cw.status >= http.StatusInternalServerError || cw.status == http.StatusTooManyRequests {
+		// the request may not have been applied, let the client retry it
+		c.remove(id)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := make(http.Header, len(w.Header()))
+	for k, v := range w.Header() {
+		header[k] = v
+	}
+	stored.done = true
+	stored.status = cw.status
+	stored.header = header
+	stored.body = cw.body.Bytes()
+}
+
+// reserve returns the response stored under id, or stores a marker for a
+// request in progress under it. Once max responses are kept the oldest
+// finished one is dropped; if all are in progress the request is refused.
+func (c *idempotencyCache) reserve(id string, fingerprint string, now time.Time) (*storedResponse, bool, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.sweep(now)
+	if e, ok := c.responses[id]; ok {
+		stored := e.Value.(*storedResponse)
+		if !now.After(stored.expires) {
+			return stored, true, nil
+		}
+		c.remove(id)
+	}
+
+	if len(c.responses) >= c.max && !c.dropOldest() {
+		return nil, false, ErrIdempotencyFull
+	}
+	stored := &storedResponse{id: id, fingerprint: fingerprint, expires: now.Add(c.ttl)}
+	c.responses[id] = c.order.PushBack(stored)
+	return stored, false, nil
+}
+
+func (c *idempotencyCache) replay(w http.ResponseWriter, stored *storedResponse, fingerprint string) {
+	c.mux.Lock()
+	done, status, header, body := stored.done, stored.status, stored.header, stored.body
+	c.mux.Unlock()
+
+	if stored.fingerprint != fingerprint {
+		writeError(w, ErrIdempotencyReused)
+		return
+	}
+	if !done {
+		writeError(w, ErrRequestInProgress)
+		return
+	}
+
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (c *idempotencyCache) remove(id string) {
+	if e, ok := c.responses[id]; ok {
+		c.order.Remove(e)
+		delete(c.responses, id)
+	}
+}
+
+// sweep drops the expired responses at the front. Requests in progress
+// are kept.
+func (c *idempotencyCache) sweep(now time.Time) {
+	for e := c.order.Front(); e != nil; {
+		stored := e.Value.(*storedResponse)
+		if !now.After(stored.expires) {
+			return
+		}
+		next := e.Next()
+		if stored.done {
+			c.remove(stored.id)
+		}
+		e = next
+	}
+}
+
+// dropOldest drops the oldest finished response and reports whether there
+// was one.
+func (c *idempotencyCache) dropOldest() bool {
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		if stored := e.Value.(*storedResponse); stored.done {
+			c.remove(stored.id)
+			return true
+		}
+	}
+	return false
+}
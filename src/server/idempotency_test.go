@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api/db"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	c := newIdempotencyCache(time.Hour, 2)
+
+	send := func(key string, p Principal, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v2/players/P1/fund", strings.NewReader(body))
+		r.Header.Set(IdempotencyHeader, key)
+		w := httptest.NewRecorder()
+		c.serve(w, r, p, h)
+		return w
+	}
+	a, b := Principal{Role: RoleOperator, KeyId: "a"}, Principal{Role: RoleOperator, KeyId: "b"}
+
+	send("k1", a, "{}")
+	w := send("k1", a, "{}")
+	if calls != 1 || w.Code != http.StatusCreated || w.Body.String() != "done" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error(calls, w.Code, w.Body.String())
+	}
+	if w := send("k1", a, `{"Points": 2}`); w.Code != http.StatusBadRequest {
+		t.Error("key reused for another request", w.Code)
+	}
+	// keys of different callers do not collide
+	if send("k1", b, "{}"); calls != 2 {
+		t.Error(calls)
+	}
+
+	// the oldest response makes room once the cache is full
+	if send("k2", a, "{}"); calls != 3 || len(c.responses) != 2 {
+		t.Error(calls, len(c.responses))
+	}
+	if send("k1", a, "{}"); calls != 4 {
+		t.Error("dropped response replayed", calls)
+	}
+
+	// requests in progress are never dropped
+	c = newIdempotencyCache(time.Hour, 1)
+	if _, _, err := c.reserve("r1", "f", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.reserve("r2", "f", time.Now()); err != ErrIdempotencyFull {
+		t.Error(err)
+	}
+
+	if w := send("k3", a, strings.Repeat(" ", maxBodySize+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Error("body over the limit", w.Code)
+	}
+}
+
+func TestIdempotency_OnlyAuthenticated(t *testing.T) {
+	s := db.CreateMemDb()
+	key, k, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	au := authenticator{keys: s, tokens: NewTokenSigner([]byte("secret")), replays: newIdempotencyCache(time.Hour, 10)}
+	h := au.require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}), RoleOperator)
+	send := func(apiKey string) int {
+		r := httptest.NewRequest("POST", "/test", strings.NewReader("{}"))
+		r.Header.Set(IdempotencyHeader, "k1")
+		r.Header.Set(ApiKeyHeader, apiKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if status := send("garbage"); status != http.StatusUnauthorized || len(au.replays.responses) != 0 {
+		t.Error("rejected request kept", status, len(au.replays.responses))
+	}
+	send(key)
+	if status := send(key); status != http.StatusOK || calls != 1 {
+		t.Error(status, calls)
+	}
+
+	// a revoked key gets no replays
+	if err := s.RevokeApiKey(k.Id, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if status := send(key); status != http.StatusUnauthorized {
+		t.Error(status)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"api/apierr"
+	"logging"
+)
+
+// requestInfo is what the handlers of a request learn about it for its log
+// entry: the route that served it and who made it.
+type requestInfo struct {
+	route  string
+	caller Principal
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the info of the request of ctx, nil outside
+// logged requests.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestId returns the id sent by the client if it is valid and a new one
+// otherwise.
+func requestId(sent string) string {
+	if logging.ValidRequestId(sent) {
+		return sent
+	}
+	return logging.NewRequestId()
+}
+
+// callerFields describe p in log entries.
+func callerFields(f logging.Fields, p Principal) logging.Fields {
+	if p.Role != "" {
+		f["role"] = p.Role
+	}
+	if p.KeyId != "" {
+		f["keyId"] = p.KeyId
+	}
+	if p.PlayerId != "" {
+		f["playerId"] = p.PlayerId
+	}
+	return f
+}
+
+// logRequests gives every request an id, echoed in the response, and logs
+// it once served.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestId(r.Header.Get(logging.RequestIdHeader))
+		w.Header().Set(logging.RequestIdHeader, id)
+		info := &requestInfo{}
+		ctx := context.WithValue(logging.WithRequestId(r.Context(), id), requestInfoKey{}, info)
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		outcome := sw.outcome()
+
+		f := callerFields(logging.Fields{
+			"method":   r.Method,
+			"route":    info.route,
+			"path":     r.URL.Path,
+			"status":   sw.status,
+			"duration": Duration(time.Since(start)),
+			"addr":     r.RemoteAddr,
+		}, info.caller)
+		if outcome != "ok" {
+			f["code"] = outcome
+		}
+		logging.Info(ctx, "request", f)
+	})
+}
+
+// logCall logs a unary gRPC call that failed with code, if not empty.
+func logCall(ctx context.Context, fullMethod string, p Principal, code apierr.Code, start time.Time) {
+	f := callerFields(logging.Fields{
+		"method":   "GRPC",
+		"route":    fullMethod,
+		"status":   http.StatusOK,
+		"duration": Duration(time.Since(start)),
+	}, p)
+	if code != "" {
+		f["status"], f["code"] = code.Status(), string(code)
+	}
+	logging.Info(ctx, "call", f)
+}
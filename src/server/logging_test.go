@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"api"
+	"api/db"
+	"logging"
+)
+
+// logEntries decodes the entries written to b.
+func logEntries(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err, line)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLogging_FailedJoinIsTraced(t *testing.T) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, apiKey, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	defer jobs.Wait()
+	defer close(stop)
+	h := NewHandler(a, s, NewTokenSigner([]byte("secret")), RateLimits{}, "", ".db", stop, jobs)
+	do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{100})
+	do(h, "POST", "/v2/tournaments", key, TournamentRequest{1, 400})
+
+	var b bytes.Buffer
+	logging.SetOutput(&b)
+	defer logging.SetOutput(os.Stderr)
+
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(EntryRequest{PlayerId: "P1"})
+	r := httptest.NewRequest("POST", "/v2/tournaments/1/entries", &body)
+	r.Header.Set(ApiKeyHeader, key)
+	r.Header.Set(logging.RequestIdHeader, "join-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity || w.Header().Get(logging.RequestIdHeader) != "join-1" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	entries := logEntries(t, &b)
+	if len(entries) != 2 {
+		t.Fatal(b.String())
+	}
+	join, req := entries[0], entries[1]
+	if join["msg"] != "join refused" || join["requestId"] != "join-1" || join["playerId"] != "P1" ||
+		join["deposit"] != 400.0 || join["balance"] != 100.0 || join["reason"] == nil {
+		t.Error(join)
+	}
+	if req["msg"] != "request" || req["requestId"] != "join-1" || req["method"] != "POST" ||
+		req["route"] != "/v2/tournaments/{}/entries" || req["status"] != 422.0 ||
+		req["code"] != "insufficient_funds" || req["role"] != RoleOperator || req["keyId"] != apiKey.Id || req["duration"] == nil {
+		t.Error(req)
+	}
+
+	// ids that are missing or invalid are replaced
+	b.Reset()
+	for _, sent := range []string{"", "not valid"} {
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set(logging.RequestIdHeader, sent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if id := w.Header().Get(logging.RequestIdHeader); id == sent || !logging.ValidRequestId(id) {
+			t.Error(sent, id)
+		}
+	}
+	for _, e := range logEntries(t, &b) {
+		if e["route"] != "/healthz" || e["role"] != nil {
+			t.Error(e)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"api"
+	"api/apierr"
+	"metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("backafriend_requests_total",
+		"HTTP requests and unary gRPC calls by handler, method and status.", "handler", "method", "status")
+	requestDuration = metrics.NewHistogram("backafriend_request_duration_seconds",
+		"Time to serve HTTP requests and unary gRPC calls by handler and method.", metrics.DefaultBuckets, "handler", "method")
+	errorsTotal = metrics.NewCounter("backafriend_errors_total",
+		"Failed HTTP requests and gRPC calls by error code.", "code")
+)
+
+// observe records a request served by handler, outcome is "ok" or the
+// error code.
+func observe(handler string, method string, status int, outcome string, start time.Time) {
+	requestsTotal.Inc(handler, method, strconv.Itoa(status))
+	requestDuration.Observe(time.Since(start).Seconds(), handler, method)
+	if outcome != "ok" {
+		errorsTotal.Inc(outcome)
+	}
+}
+
+// instrument records the requests h serves under the name of the handler,
+// which also names the route in their log entries.
+func instrument(handler string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.route = handler
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		outcome := sw.outcome() // sets the status of empty responses
+		observe(handler, r.Method, sw.status, outcome, start)
+	})
+}
+
+// observeCall records a unary gRPC call that failed with code, if not
+// empty.
+func observeCall(fullMethod string, code apierr.Code, start time.Time) {
+	status, outcome := http.StatusOK, "ok"
+	if code != "" {
+		status, outcome = code.Status(), string(code)
+	}
+	observe(fullMethod, "GRPC", status, outcome, start)
+}
+
+type metricsHandler struct {
+	a api.Api
+}
+
+func newMetricsHandler(a api.Api) http.Handler {
+	return metricsHandler{a}
+}
+
+// ServeHTTP writes the metrics of the process followed by gauges of the
+// stored data in the Prometheus text format.
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.a.Stats(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	gauges := metrics.NewRegistry()
+	gauges.NewGauge("backafriend_active_tournaments",
+		"Tournaments announced and not settled yet.").Set(float64(stats.ActiveTournaments))
+	gauges.NewGauge("backafriend_points_in_circulation",
+		"Balances of all players and the stakes of running tournaments.").Set(float64(stats.Points))
+	entries := gauges.NewGauge("backafriend_tournament_entries",
+		"Entries of all tournaments by funding: backed or self.", "funding")
+	entries.Set(float64(stats.BackedEntries), "backed")
+	entries.Set(float64(stats.SelfFundedEntries), "self")
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Default.WriteText(w); err != nil {
+		return
+	}
+	gauges.WriteText(w)
+}
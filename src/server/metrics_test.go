@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api"
+	"api/db"
+	"metrics"
+)
+
+func TestMetrics_Exposition(t *testing.T) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, _, err := IssueApiKey(s, RoleGameServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	defer jobs.Wait()
+	defer close(stop)
+	h := NewHandler(a, s, NewTokenSigner([]byte("secret")), RateLimits{}, "", ".db", stop, jobs)
+
+	do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{500})
+	do(h, "POST", "/v2/players/P2/fund", key, PointsRequest{500})
+	do(h, "POST", "/v2/tournaments", key, TournamentRequest{1, 400})
+	do(h, "POST", "/v2/tournaments/1/entries", key, EntryRequest{PlayerId: "P1"})
+	do(h, "GET", "/take?playerId=P2&points=1000", key, nil)
+	players := newLimiter("player", Limit{Rate: 1, Burst: 1})
+	players.allow("P1", time.Now())
+	players.allow("P1", time.Now())
+
+	if w := do(h, "GET", "/metrics", serverKey, nil); w.Code != http.StatusForbidden {
+		t.Error("game servers read metrics", w.Code)
+	}
+	w := do(h, "GET", "/metrics", key, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != metrics.ContentType {
+		t.Fatal(w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE backafriend_requests_total counter\n",
+		`backafriend_requests_total{handler="/v2/players/{}/fund",method="POST",status="200"} `,
+		`backafriend_requests_total{handler="/take",method="GET",status="422"} `,
+		`backafriend_request_duration_seconds_bucket{handler="/v2/tournaments",method="POST",le="+Inf"} `,
+		`backafriend_errors_total{code="insufficient_funds"} `,
+		`backafriend_errors_total{code="forbidden"} `,
+		`backafriend_db_lock_wait_seconds_count `,
+		`backafriend_rate_limited_total{limit="player"} `,
+		"backafriend_active_tournaments 1\n",
+		"backafriend_points_in_circulation 1000\n",
+		"backafriend_tournament_entries{funding=\"backed\"} 0\n",
+		"backafriend_tournament_entries{funding=\"self\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Error("missing", want)
+		}
+	}
+}
@@ -0,0 +1,2444 @@
+package server
+
+// openAPISpec describes every endpoint of the HTTP API. Requests are
+// validated against it before they reach a handler, see openapi.go.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "back-a-friend",
+    "version": "2",
+    "description": "Players fund accounts, join tournaments and back each other's entries. Every request but the health probes needs an API key; players may use a bearer token instead. Requests carrying an Idempotency-Key header are applied once, repeating one with the same credentials within 24 hours returns the first response; the server keeps them in memory, so they do not survive a restart. JSON request bodies larger than 1 MiB are rejected with body_too_large. Every response carries an X-Request-ID header, the one sent if valid, naming the request in the server log. Webhook deliveries are signed: X-Webhook-Signature is sha256= and the hex HMAC-SHA256 of X-Webhook-Timestamp, a dot and the body, keyed with the webhook secret."
+  },
+  "security": [
+    {
+      "apiKey": []
+    },
+    {
+      "playerToken": []
+    }
+  ],
+  "paths": {
+    "/take": {
+      "get": {
+        "operationId": "take",
+        "summary": "Take points from a player",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "points",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/fund": {
+      "get": {
+        "operationId": "fund",
+        "summary": "Fund a player, creating it if needed",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "points",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/balance": {
+      "get": {
+        "operationId": "balance",
+        "summary": "Balance of a player",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "seasonId",
+            "in": "query",
+            "description": "Closed season to read the archived balance of, 0 for the current one",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Balance",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Balance"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/announceTournament": {
+      "get": {
+        "operationId": "announceTournament",
+        "summary": "Announce a tournament",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "deposit",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/joinTournament": {
+      "get": {
+        "operationId": "joinTournament",
+        "summary": "Join the announced tournament",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "backerId",
+            "in": "query",
+            "description": "Repeated for every backer",
+            "required": false,
+            "schema": {
+              "type": "array",
+              "maxItems": 10,
+              "items": {
+                "type": "string",
+                "minLength": 1
+              }
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/requestBacking": {
+      "get": {
+        "operationId": "requestBacking",
+        "summary": "Ask another player to back an entry",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "backerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/acceptBacking": {
+      "get": {
+        "operationId": "acceptBacking",
+        "summary": "Accept a backing request as the backer",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "backerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/resultTournament": {
+      "get": {
+        "operationId": "resultTournament",
+        "summary": "Settle the running tournament",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server"
+        ],
+        "responses": {
+          "200": {
+            "description": "Winner",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Winner"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/reset": {
+      "get": {
+        "operationId": "reset",
+        "summary": "Drop all players and tournaments, keeping archived seasons",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/closeSeason": {
+      "get": {
+        "operationId": "closeSeason",
+        "summary": "Close the current season",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "carryPercent",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0,
+              "maximum": 100
+            }
+          },
+          {
+            "name": "carryMax",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The new season",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Season"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/leaderboard": {
+      "get": {
+        "operationId": "leaderboard",
+        "summary": "Standings of a season",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "seasonId",
+            "in": "query",
+            "description": "0 for the current season",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Standings",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Standing"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/playerToken": {
+      "get": {
+        "operationId": "playerToken",
+        "summary": "Issue a bearer token bound to a player",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Token",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PlayerToken"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/rateLimits": {
+      "get": {
+        "operationId": "rateLimits",
+        "summary": "Requests rejected by each rate limit",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "Hits",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/RateLimitHits"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/backup": {
+      "get": {
+        "operationId": "backup",
+        "summary": "Back up the database",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "Backup",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/BackupFile"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/restore": {
+      "get": {
+        "operationId": "restore",
+        "summary": "Restore a backup, keeping the audit log",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "file",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/export": {
+      "get": {
+        "operationId": "export",
+        "summary": "Export all records",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "format",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "jsonl",
+                "csv"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "JSON Lines or CSV records"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/import": {
+      "post": {
+        "operationId": "import",
+        "summary": "Import records",
+        "tags": [
+          "v1"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "format",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "jsonl",
+                "csv"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/players/{playerId}": {
+      "get": {
+        "operationId": "getPlayer",
+        "summary": "Balance of a player",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "seasonId",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Balance",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Balance"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/players/{playerId}/fund": {
+      "post": {
+        "operationId": "fundPlayer",
+        "summary": "Fund a player, creating it if needed",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/PointsRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "New balance",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Balance"
+                }
+              }
+            }
+          },
+          "204": {
+            "description": "Funded, the new balance could not be read"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/players/{playerId}/take": {
+      "post": {
+        "operationId": "takePoints",
+        "summary": "Take points from a player",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/PointsRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "New balance",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Balance"
+                }
+              }
+            }
+          },
+          "204": {
+            "description": "Taken, the new balance could not be read"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/players/{playerId}/tokens": {
+      "post": {
+        "operationId": "issueToken",
+        "summary": "Issue a bearer token bound to a player",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "201": {
+            "description": "Token",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PlayerToken"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments": {
+      "post": {
+        "operationId": "createTournament",
+        "summary": "Announce a tournament",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server"
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/TournamentRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Tournament",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TournamentRequest"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/entries": {
+      "post": {
+        "operationId": "joinTournament2",
+        "summary": "Join the announced tournament",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/EntryRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Entry",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/EntryRequest"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/backing-requests": {
+      "post": {
+        "operationId": "requestBacking2",
+        "summary": "Ask another player to back an entry",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/BackingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Request",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/BackingRequest"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/backing-requests/{playerId}/{backerId}/accept": {
+      "post": {
+        "operationId": "acceptBacking2",
+        "summary": "Accept a backing request as the backer",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "backerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Request",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/BackingRequest"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/active": {
+      "get": {
+        "operationId": "activeTournament",
+        "summary": "Id of the running tournament",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "responses": {
+          "200": {
+            "description": "Tournament",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ActiveTournament"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/backing-requests/{playerId}/accepted": {
+      "get": {
+        "operationId": "acceptedBackers",
+        "summary": "Backers that accepted a player's requests",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Backer ids",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/tournaments/{tournamentId}/result": {
+      "post": {
+        "operationId": "resultTournament2",
+        "summary": "Settle the running tournament",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Winner",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Winner"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/seasons": {
+      "post": {
+        "operationId": "closeSeason2",
+        "summary": "Close the current season",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/SeasonRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "The new season",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Season"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/seasons/{seasonId}/leaderboard": {
+      "get": {
+        "operationId": "leaderboard2",
+        "summary": "Standings of a season",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "seasonId",
+            "in": "path",
+            "description": "Season id or \"current\"",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "pattern": "^(current|[1-9][0-9]*)$"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Standings",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Standing"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/reset": {
+      "post": {
+        "operationId": "reset2",
+        "summary": "Drop all players and tournaments, keeping archived seasons",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "204": {
+            "description": "Reset"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/backups": {
+      "post": {
+        "operationId": "backup2",
+        "summary": "Back up the database",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "201": {
+            "description": "Backup",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/BackupFile"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/backups/{file}/restore": {
+      "post": {
+        "operationId": "restore2",
+        "summary": "Restore a backup, keeping the audit log",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "file",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "Restored"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/export": {
+      "get": {
+        "operationId": "export2",
+        "summary": "Export all records",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "format",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "jsonl",
+                "csv"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "JSON Lines or CSV records"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/import": {
+      "post": {
+        "operationId": "import2",
+        "summary": "Import records",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "format",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "jsonl",
+                "csv"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/events": {
+      "get": {
+        "operationId": "events",
+        "summary": "Stream changes as server-sent events",
+        "tags": [
+          "v2"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "parameters": [
+          {
+            "name": "tournamentId",
+            "in": "query",
+            "description": "Only events of this tournament",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 1
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "query",
+            "description": "Only events involving this player; players may only pass their own id",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "lastEventId",
+            "in": "query",
+            "description": "Resume after this event, like Last-Event-ID",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "Last-Event-ID",
+            "in": "header",
+            "description": "Resume after this event, sent by reconnecting EventSource clients",
+            "required": false,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Events of the types announced, joined, backing_requested, backing_accepted, settled, funded and balance_changed. Players only receive their own funded and balance_changed events.",
+            "content": {
+              "text/event-stream": {
+                "schema": {
+                  "$ref": "#/components/schemas/Event"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/webhooks": {
+      "get": {
+        "operationId": "listWebhooks",
+        "summary": "List webhooks",
+        "tags": [
+          "webhooks"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "Webhooks",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Webhook"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "post": {
+        "operationId": "createWebhook",
+        "summary": "Subscribe a URL to events",
+        "tags": [
+          "webhooks"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/WebhookRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "The webhook, with the secret its deliveries are signed with. The secret is not shown again.",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Webhook"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/webhooks/{webhookId}": {
+      "delete": {
+        "operationId": "deleteWebhook",
+        "summary": "Delete a webhook and its deliveries",
+        "tags": [
+          "webhooks"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "webhookId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "Deleted"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/webhooks/{webhookId}/deliveries": {
+      "get": {
+        "operationId": "listDeliveries",
+        "summary": "Deliveries of a webhook, oldest first",
+        "tags": [
+          "webhooks"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "webhookId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "state",
+            "in": "query",
+            "description": "Only deliveries in this state; dead lists the dead letters",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "pending",
+                "delivered",
+                "dead"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Deliveries",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/Delivery"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/webhooks/{webhookId}/deliveries/{deliveryId}/retry": {
+      "post": {
+        "operationId": "retryDelivery",
+        "summary": "Queue a delivery again with a fresh set of attempts",
+        "tags": [
+          "webhooks"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "webhookId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "deliveryId",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The queued delivery",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Delivery"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/audit": {
+      "get": {
+        "operationId": "listAudit",
+        "summary": "Audit log of authorized calls that change something, and of exports, in order",
+        "tags": [
+          "audit"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "parameters": [
+          {
+            "name": "after",
+            "in": "query",
+            "description": "Only entries after this Seq, the last one of the previous page",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          },
+          {
+            "name": "limit",
+            "in": "query",
+            "description": "Page size, 100 by default",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 1000
+            }
+          },
+          {
+            "name": "keyId",
+            "in": "query",
+            "description": "Only calls made with this API key",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "playerId",
+            "in": "query",
+            "description": "Only calls made with a token of this player",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          },
+          {
+            "name": "endpoint",
+            "in": "query",
+            "description": "Only calls of this path or full gRPC method",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Audit entries",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "$ref": "#/components/schemas/AuditEntry"
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/v2/audit/verify": {
+      "get": {
+        "operationId": "verifyAudit",
+        "summary": "Check the hash chain of the audit log",
+        "tags": [
+          "audit"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "Result of the check",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/AuditVerification"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "openapi",
+        "summary": "This document",
+        "tags": [
+          "meta"
+        ],
+        "x-roles": [
+          "operator",
+          "game-server",
+          "player"
+        ],
+        "responses": {
+          "200": {
+            "description": "OpenAPI document"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/healthz": {
+      "get": {
+        "operationId": "healthz",
+        "summary": "Liveness probe, answers while the process serves HTTP",
+        "tags": [
+          "meta"
+        ],
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "Alive",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Health"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/readyz": {
+      "get": {
+        "operationId": "readyz",
+        "summary": "Readiness probe: storage answers, its schema is current and background workers run",
+        "tags": [
+          "meta"
+        ],
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "Ready",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Readiness"
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "Not ready, the failed checks say why",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Readiness"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "metrics",
+        "summary": "Metrics in the Prometheus text format",
+        "tags": [
+          "meta"
+        ],
+        "x-roles": [
+          "operator"
+        ],
+        "responses": {
+          "200": {
+            "description": "Request counts and latencies, errors, rate limit rejections, storage latencies and gauges of the stored data",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {
+        "type": "apiKey",
+        "in": "header",
+        "name": "X-Api-Key"
+      },
+      "playerToken": {
+        "type": "http",
+        "scheme": "bearer",
+        "bearerFormat": "JWT"
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {
+          "application/json": {
+            "schema": {
+              "$ref": "#/components/schemas/Error"
+            }
+          }
+        }
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": [
+          "Code",
+          "Error"
+        ],
+        "properties": {
+          "Code": {
+            "type": "string",
+            "description": "Stable error code, e.g. insufficient_funds"
+          },
+          "Error": {
+            "type": "string"
+          }
+        }
+      },
+      "Balance": {
+        "type": "object",
+        "properties": {
+          "PlayerId": {
+            "type": "string"
+          },
+          "Balance": {
+            "type": "integer"
+          }
+        }
+      },
+      "PointsRequest": {
+        "type": "object",
+        "required": [
+          "Points"
+        ],
+        "properties": {
+          "Points": {
+            "type": "integer",
+            "minimum": 1
+          }
+        }
+      },
+      "TournamentRequest": {
+        "type": "object",
+        "required": [
+          "TournamentId",
+          "Deposit"
+        ],
+        "properties": {
+          "TournamentId": {
+            "type": "integer",
+            "minimum": 0
+          },
+          "Deposit": {
+            "type": "integer",
+            "minimum": 1
+          }
+        }
+      },
+      "EntryRequest": {
+        "type": "object",
+        "required": [
+          "PlayerId"
+        ],
+        "properties": {
+          "PlayerId": {
+            "type": "string",
+            "minLength": 1
+          },
+          "Backers": {
+            "type": "array",
+            "maxItems": 10,
+            "items": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        }
+      },
+      "BackingRequest": {
+        "type": "object",
+        "required": [
+          "PlayerId",
+          "BackerId"
+        ],
+        "properties": {
+          "PlayerId": {
+            "type": "string",
+            "minLength": 1
+          },
+          "BackerId": {
+            "type": "string",
+            "minLength": 1
+          }
+        }
+      },
+      "SeasonRequest": {
+        "type": "object",
+        "properties": {
+          "CarryPercent": {
+            "type": "integer",
+            "minimum": 0,
+            "maximum": 100
+          },
+          "CarryMax": {
+            "type": "integer",
+            "minimum": 0
+          }
+        }
+      },
+      "ActiveTournament": {
+        "type": "object",
+        "properties": {
+          "TournamentId": {
+            "type": "integer"
+          }
+        }
+      },
+      "Payout": {
+        "type": "object",
+        "properties": {
+          "PlayerId": {
+            "type": "string"
+          },
+          "Points": {
+            "type": "integer"
+          }
+        }
+      },
+      "Winner": {
+        "type": "object",
+        "properties": {
+          "PlayerId": {
+            "type": "string"
+          },
+          "Prize": {
+            "type": "integer"
+          },
+          "Payouts": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Payout"
+            }
+          }
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "Id": {
+            "type": "integer"
+          },
+          "Type": {
+            "type": "string",
+            "enum": [
+              "announced",
+              "joined",
+              "backing_requested",
+              "backing_accepted",
+              "settled",
+              "funded",
+              "balance_changed"
+            ]
+          },
+          "TournamentId": {
+            "type": "integer"
+          },
+          "PlayerId": {
+            "type": "string"
+          },
+          "BackerId": {
+            "type": "string"
+          },
+          "Backers": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          },
+          "Deposit": {
+            "type": "integer"
+          },
+          "Prize": {
+            "type": "integer"
+          },
+          "Points": {
+            "type": "integer"
+          },
+          "Payouts": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Payout"
+            }
+          },
+          "Balance": {
+            "type": "integer"
+          },
+          "At": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "WebhookRequest": {
+        "type": "object",
+        "required": [
+          "URL"
+        ],
+        "properties": {
+          "URL": {
+            "type": "string",
+            "pattern": "^https?://"
+          },
+          "Events": {
+            "type": "array",
+            "items": {
+              "type": "string",
+              "enum": [
+                "announced",
+                "joined",
+                "backing_requested",
+                "backing_accepted",
+                "settled",
+                "funded",
+                "balance_changed"
+              ]
+            },
+            "description": "Event types to deliver, all if empty"
+          }
+        }
+      },
+      "Webhook": {
+        "type": "object",
+        "properties": {
+          "Id": {
+            "type": "string"
+          },
+          "URL": {
+            "type": "string"
+          },
+          "Events": {
+            "type": "array",
+            "items": {
+              "type": "string",
+              "enum": [
+                "announced",
+                "joined",
+                "backing_requested",
+                "backing_accepted",
+                "settled",
+                "funded",
+                "balance_changed"
+              ]
+            }
+          },
+          "Secret": {
+            "type": "string"
+          },
+          "CreatedAt": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Delivery": {
+        "type": "object",
+        "properties": {
+          "Id": {
+            "type": "string"
+          },
+          "WebhookId": {
+            "type": "string"
+          },
+          "EventType": {
+            "type": "string",
+            "enum": [
+              "announced",
+              "joined",
+              "backing_requested",
+              "backing_accepted",
+              "settled",
+              "funded",
+              "balance_changed"
+            ]
+          },
+          "Payload": {
+            "type": "string",
+            "description": "The event as JSON, the body that is posted"
+          },
+          "State": {
+            "type": "string",
+            "enum": [
+              "pending",
+              "delivered",
+              "dead"
+            ]
+          },
+          "Attempts": {
+            "type": "integer"
+          },
+          "NextAttempt": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "LastStatus": {
+            "type": "integer"
+          },
+          "LastError": {
+            "type": "string"
+          },
+          "CreatedAt": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "DeliveredAt": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "AuditEntry": {
+        "type": "object",
+        "properties": {
+          "Seq": {
+            "type": "integer"
+          },
+          "At": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "Role": {
+            "type": "string",
+            "description": "Of the caller"
+          },
+          "KeyId": {
+            "type": "string"
+          },
+          "PlayerId": {
+            "type": "string"
+          },
+          "Addr": {
+            "type": "string"
+          },
+          "Method": {
+            "type": "string",
+            "description": "HTTP method, or GRPC"
+          },
+          "Endpoint": {
+            "type": "string"
+          },
+          "Query": {
+            "type": "string"
+          },
+          "Body": {
+            "type": "string",
+            "description": "The request body, truncated to 4 KiB"
+          },
+          "Status": {
+            "type": "integer"
+          },
+          "Outcome": {
+            "type": "string",
+            "description": "ok or the error code"
+          },
+          "PrevHash": {
+            "type": "string"
+          },
+          "Hash": {
+            "type": "string",
+            "description": "Hex SHA-256 of PrevHash, Seq, At (RFC 3339, UTC), Role, KeyId, PlayerId, Addr, Method, Endpoint, Query, Body, Status and Outcome, each followed by a zero byte"
+          }
+        }
+      },
+      "AuditVerification": {
+        "type": "object",
+        "properties": {
+          "Entries": {
+            "type": "integer"
+          },
+          "Valid": {
+            "type": "boolean"
+          },
+          "BrokenAt": {
+            "type": "integer",
+            "description": "Seq of the first entry not matching its hash or predecessor"
+          },
+          "LastHash": {
+            "type": "string",
+            "description": "Compare with a hash noted earlier to detect a truncated log"
+          }
+        }
+      },
+      "Season": {
+        "type": "object",
+        "properties": {
+          "Id": {
+            "type": "integer"
+          },
+          "StartedAt": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "ClosedAt": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Standing": {
+        "type": "object",
+        "properties": {
+          "PlayerId": {
+            "type": "string"
+          },
+          "Rank": {
+            "type": "integer"
+          },
+          "Points": {
+            "type": "integer"
+          },
+          "Played": {
+            "type": "integer"
+          },
+          "Won": {
+            "type": "integer"
+          }
+        }
+      },
+      "PlayerToken": {
+        "type": "object",
+        "properties": {
+          "PlayerId": {
+            "type": "string"
+          },
+          "Token": {
+            "type": "string"
+          },
+          "ExpiresAt": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "BackupFile": {
+        "type": "object",
+        "properties": {
+          "File": {
+            "type": "string"
+          }
+        }
+      },
+      "Health": {
+        "type": "object",
+        "properties": {
+          "Status": {
+            "type": "string",
+            "enum": [
+              "ok"
+            ]
+          }
+        }
+      },
+      "CheckResult": {
+        "type": "object",
+        "properties": {
+          "Name": {
+            "type": "string",
+            "enum": [
+              "storage",
+              "migrations",
+              "workers"
+            ]
+          },
+          "Ok": {
+            "type": "boolean"
+          },
+          "Error": {
+            "type": "string"
+          },
+          "Took": {
+            "type": "string",
+            "description": "Duration like 1.5ms"
+          }
+        }
+      },
+      "Readiness": {
+        "type": "object",
+        "properties": {
+          "Ready": {
+            "type": "boolean"
+          },
+          "Checks": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/CheckResult"
+            }
+          }
+        }
+      },
+      "RateLimitHits": {
+        "type": "object",
+        "properties": {
+          "Key": {
+            "type": "integer"
+          },
+          "Addr": {
+            "type": "integer"
+          },
+          "Player": {
+            "type": "integer"
+          }
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"api/apierr"
+)
+
+// schema is the subset of an OpenAPI schema object requests are checked
+// against.
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Enum       []string           `json:"enum"`
+	Pattern    string             `json:"pattern"`
+	MinLength  *int               `json:"minLength"`
+	Minimum    *int64             `json:"minimum"`
+	Maximum    *int64             `json:"maximum"`
+	MaxItems   *int               `json:"maxItems"`
+	Items      *schema            `json:"items"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+
+	pattern *regexp.Regexp
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type operation struct {
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool                 `json:"required"`
+		Content  map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+}
+
+// pathItem is one templated path of the document, "{name}" segments are
+// path parameters.
+type pathItem struct {
+	template   string
+	pattern    string // template with "{}" placeholders, see matchPath
+	names      []string
+	operations map[string]*operation
+}
+
+// apiSpec is the parsed OpenAPI document. Paths are kept sorted so that
+// a literal segment wins over a parameter at the same position. Requests
+// are validated by authHandler once the caller is known.
+type apiSpec struct {
+	raw   []byte
+	paths []pathItem
+}
+
+var spec = mustParseSpec(openAPISpec)
+
+func mustParseSpec(doc string) *apiSpec {
+	s, err := parseSpec([]byte(doc))
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return s
+}
+
+func parseSpec(doc []byte) (*apiSpec, error) {
+	var d struct {
+		Paths      map[string]map[string]*operation `json:"paths"`
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+
+	s := &apiSpec{raw: doc}
+	for template, ops := range d.Paths {
+		item := pathItem{template: template, operations: make(map[string]*operation)}
+		segs := strings.Split(template, "/")
+		for i, seg := range segs {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				item.names = append(item.names, seg[1:len(seg)-1])
+				segs[i] = "{}"
+			}
+		}
+		item.pattern = strings.Join(segs, "/")
+
+		for method, op := range ops {
+			for _, p := range op.Parameters {
+				if err := resolve(p.Schema, d.Components.Schemas); err != nil {
+					return nil, err
+				}
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					if err := resolve(m.Schema, d.Components.Schemas); err != nil {
+						return nil, err
+					}
+				}
+			}
+			item.operations[strings.ToUpper(method)] = op
+		}
+		s.paths = append(s.paths, item)
+	}
+	sort.Sort(byTemplate(s.paths))
+	return s, nil
+}
+
+type byTemplate []pathItem
+
+func (p byTemplate) Len() int           { return len(p) }
+func (p byTemplate) Less(i, j int) bool { return p[i].pattern < p[j].pattern }
+func (p byTemplate) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// resolve replaces references to component schemas in place and compiles
+// patterns.
+func resolve(sc *schema, components map[string]*schema) error {
+	if sc == nil {
+		return nil
+	}
+	if sc.Ref != "" {
+		target, ok := components[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+		if !ok {
+			return apierr.Invalid("unknown schema %s", sc.Ref)
+		}
+		*sc = *target
+		return resolve(sc, components)
+	}
+	if sc.Pattern != "" && sc.pattern == nil {
+		re, err := regexp.Compile(sc.Pattern)
+		if err != nil {
+			return err
+		}
+		sc.pattern = re
+	}
+	if err := resolve(sc.Items, components); err != nil {
+		return err
+	}
+	for _, p := range sc.Properties {
+		if err := resolve(p, components); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// operation finds the operation documented for a request and the values
+// of its path parameters.
+func (s *apiSpec) operation(method string, p string) (*operation, map[string]string) {
+	for _, item := range s.paths {
+		values, ok := matchPath(item.pattern, p)
+		if !ok {
+			continue
+		}
+		op, ok := item.operations[method]
+		if !ok {
+			// v1 handlers accept any method, leave it to them
+			op, ok = item.operations[http.MethodGet]
+			if !ok || strings.HasPrefix(p, "/v2/") {
+				return nil, nil
+			}
+		}
+		params := make(map[string]string, len(values))
+		for i, v := range values {
+			params[item.names[i]] = v
+		}
+		return op, params
+	}
+	return nil, nil
+}
+
+// validate checks the parameters and the JSON body of r. The body is read
+// up to maxBodySize and replaced, handlers decode it again.
+func (s *apiSpec) validate(w http.ResponseWriter, r *http.Request) error {
+	op, pathParams := s.operation(r.Method, r.URL.Path)
+	if op == nil {
+		return nil
+	}
+
+	q := r.URL.Query()
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "query":
+			values = q[p.Name]
+		case "path":
+			values = []string{pathParams[p.Name]}
+		default:
+			continue
+		}
+		if err := checkParam(p, values); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	m, ok := op.RequestBody.Content["application/json"]
+	if !ok || m.Schema == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		if len(body) == maxBodySize {
+			return errBodyTooLarge
+		}
+		return errInvalidBody
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return apierr.Invalid("Request body is required")
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return errInvalidBody
+	}
+	return checkValue(m.Schema, "body", v)
+}
+
+func checkParam(p parameter, values []string) error {
+	if len(values) == 0 {
+		if p.Required {
+			return apierr.Invalid("Missing parameter: %s", p.Name)
+		}
+		return nil
+	}
+
+	sc := p.Schema
+	if sc == nil {
+		return nil
+	}
+	if sc.Type == "array" {
+		if sc.MaxItems != nil && len(values) > *sc.MaxItems {
+			return apierr.Invalid("%s: at most %d values allowed", p.Name, *sc.MaxItems)
+		}
+		for _, v := range values {
+			if err := checkString(sc.Items, p.Name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(values) > 1 {
+		return apierr.Invalid("%s: single value expected", p.Name)
+	}
+	return checkString(sc, p.Name, values[0])
+}
+
+// checkString checks a query or path value, which are always strings on
+// the wire.
+func checkString(sc *schema, name string, v string) error {
+	if sc == nil {
+		return nil
+	}
+	if sc.Type == "integer" {
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return apierr.Invalid("%s: integer expected", name)
+		}
+		return checkValue(sc, name, json.Number(v))
+	}
+	return checkValue(sc, name, v)
+}
+
+// checkValue checks a decoded JSON value against sc.
+func checkValue(sc *schema, name string, v interface{}) error {
+	if sc == nil {
+		return nil
+	}
+
+	switch sc.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return apierr.Invalid("%s: object expected", name)
+		}
+		for _, req := range sc.Required {
+			if _, ok := obj[req]; !ok {
+				return apierr.Invalid("%s: missing %s", name, req)
+			}
+		}
+		for prop, propSchema := range sc.Properties {
+			if pv, ok := obj[prop]; ok {
+				if err := checkValue(propSchema, prop, pv); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		if v == nil {
+			return nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return apierr.Invalid("%s: array expected", name)
+		}
+		if sc.MaxItems != nil && len(items) > *sc.MaxItems {
+			return apierr.Invalid("%s: at most %d items allowed", name, *sc.MaxItems)
+		}
+		for _, item := range items {
+			if err := checkValue(sc.Items, name, item); err != nil {
+				return err
+			}
+		}
+	case "integer":
+		num, ok := v.(json.Number)
+		if !ok {
+			return apierr.Invalid("%s: integer expected", name)
+		}
+		n, err := num.Int64()
+		if err != nil {
+			return apierr.Invalid("%s: integer expected", name)
+		}
+		if sc.Minimum != nil && n < *sc.Minimum {
+			return apierr.Invalid("%s: must be at least %d", name, *sc.Minimum)
+		}
+		if sc.Maximum != nil && n > *sc.Maximum {
+			return apierr.Invalid("%s: must be at most %d", name, *sc.Maximum)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return apierr.Invalid("%s: string expected", name)
+		}
+		if sc.MinLength != nil && len(str) < *sc.MinLength {
+			return apierr.Invalid("%s: must not be empty", name)
+		}
+		if len(sc.Enum) > 0 && !containsAll(sc.Enum, []string{str}) {
+			return apierr.Invalid("%s: must be one of %s", name, strings.Join(sc.Enum, ", "))
+		}
+		if sc.pattern != nil && !sc.pattern.MatchString(str) {
+			return apierr.Invalid("%s: must match %s", name, sc.Pattern)
+		}
+	}
+	return nil
+}
+
+type openAPIHandler struct{}
+
+func newOpenAPIHandler() http.Handler {
+	return openAPIHandler{}
+}
+
+func (h openAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec.raw)
+}
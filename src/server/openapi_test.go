@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api"
+	"api/apierr"
+	"api/db"
+)
+
+func TestOpenAPI_Documented(t *testing.T) {
+	h, _, _, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rt := range h.(v2Router).routes {
+		p := strings.Replace(rt.pattern, "{}", "1", -1)
+		if op, _ := spec.operation(rt.method, p); op == nil {
+			t.Error("not documented:", rt.method, rt.pattern)
+		}
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(spec.raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Error(doc["openapi"])
+	}
+}
+
+func TestOpenAPI_Validate(t *testing.T) {
+	s := db.CreateMemDb()
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	au := authenticator{s, NewTokenSigner([]byte("secret")), nil, nil, nil, nil}
+
+	passed := false
+	h := au.require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = true
+	}), RoleOperator)
+
+	backers := strings.Repeat("&backerId=B", api.MaxBackers+1)
+	tests := []struct {
+		method, url, body string
+		ok                bool
+	}{
+		{"GET", "/take?playerId=P1&points=5", "", true},
+		{"GET", "/take?playerId=P1", "", false},
+		{"GET", "/take?playerId=P1&points=0", "", false},
+		{"GET", "/take?playerId=P1&points=-5", "", false},
+		{"GET", "/take?playerId=P1&points=x", "", false},
+		{"GET", "/take?playerId=&points=5", "", false},
+		{"GET", "/take?playerId=P1&playerId=P2&points=5", "", false},
+		{"GET", "/closeSeason?carryPercent=101", "", false},
+		{"GET", "/export?format=xml", "", false},
+		{"GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=B1&backerId=B2", "", true},
+		{"GET", "/joinTournament?tournamentId=1&playerId=P1" + backers, "", false},
+		{"POST", "/v2/players/P1/fund", `{"Points": 5}`, true},
+		{"POST", "/v2/players/P1/fund", `{"Points": 0}`, false},
+		{"POST", "/v2/players/P1/fund", `{"Points": 1.5}`, false},
+		{"POST", "/v2/players/P1/fund", `{"Points": "5"}`, false},
+		{"POST", "/v2/players/P1/fund", `{}`, false},
+		{"POST", "/v2/players/P1/fund", ``, false},
+		{"POST", "/v2/tournaments/x/entries", `{"PlayerId": "P1"}`, false},
+		{"POST", "/v2/tournaments/1/entries", `{"PlayerId": "P1", "Backers": ["B1"]}`, true},
+		{"POST", "/v2/tournaments/1/entries", `{"PlayerId": "P1", "Backers": ["B", "B", "B", "B", "B", "B", "B", "B", "B", "B", "B"]}`, false},
+		{"GET", "/v2/seasons/current/leaderboard", "", true},
+		{"GET", "/v2/seasons/0/leaderboard", "", false},
+	}
+	for _, tc := range tests {
+		passed = false
+		r := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		r.Header.Set(ApiKeyHeader, key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if passed != tc.ok {
+			t.Error(tc.method, tc.url, tc.body, w.Code, w.Body.String())
+			continue
+		}
+		if tc.ok {
+			continue
+		}
+		var body ErrorBody
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusBadRequest || body.Code != apierr.CodeInvalidArgument {
+			t.Error(tc.url, w.Code, body)
+		}
+	}
+}
+
+func TestOpenAPI_ValidatedBodyReachesHandler(t *testing.T) {
+	h, key, _, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{7})
+	var b Balance
+	if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || b.Balance != 7 {
+		t.Error(w.Code, b)
+	}
+}
+
+func TestOpenAPI_BodyTooLarge(t *testing.T) {
+	h, key, _, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := strings.Repeat("x", maxBodySize)
+	w := do(h, "POST", "/v2/tournaments/1/entries", key, EntryRequest{PlayerId: name})
+	var body ErrorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusRequestEntityTooLarge || body.Code != apierr.CodeBodyTooLarge {
+		t.Error(w.Code, body)
+	}
+}
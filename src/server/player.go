@@ -21,29 +21,34 @@ func (h takeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	playerId, ok := q["playerId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "playerId")
 		return
 	}
 
 	pts, ok := q["points"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "points")
 		return
 	}
 
 	if len(pts) > 1 || len(playerId) > 1 {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "points", "playerId")
+		return
+	}
+
+	if !principalFrom(r).canActAs(playerId[0]) {
+		writeError(w, ErrForbidden)
 		return
 	}
 
 	p, err := strconv.Atoi(pts[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, "points")
 		return
 	}
 
-	if err := h.a.Take(playerId[0], p); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.a.Take(r.Context(), playerId[0], p); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -62,29 +67,29 @@ func (h fundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	playerId, ok := q["playerId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "playerId")
 		return
 	}
 
 	pts, ok := q["points"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "points")
 		return
 	}
 
 	if len(pts) > 1 || len(playerId) > 1 {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "points", "playerId")
 		return
 	}
 
 	p, err := strconv.Atoi(pts[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, "points")
 		return
 	}
 
-	if err := h.a.Fund(playerId[0], p); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.a.Fund(r.Context(), playerId[0], p); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -108,18 +113,29 @@ func (h balanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	playerId, ok := q["playerId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "playerId")
 		return
 	}
 
 	if len(playerId) > 1 {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "playerId")
+		return
+	}
+
+	if !principalFrom(r).canActAs(playerId[0]) {
+		writeError(w, ErrForbidden)
+		return
+	}
+
+	seasonId, ok := intParam(r, "seasonId", 0)
+	if !ok {
+		badRequest(w, "seasonId")
 		return
 	}
 
-	balance, err := h.a.Balance(playerId[0])
+	balance, err := h.a.SeasonBalance(r.Context(), seasonId, playerId[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api/apierr"
+	"metrics"
+)
+
+var ErrRateLimited = apierr.New(apierr.CodeRateLimited, "Too many requests")
+
+var rateLimited = metrics.NewCounter("backafriend_rate_limited_total",
+	"Requests and gRPC calls rejected by a rate limit, by limit: key, addr or player.", "limit")
+
+// Limit is a token bucket: Rate requests per second on average, in bursts
+// of up to Burst requests. The zero Limit does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) String() string {
+	if l.Rate <= 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(l.Rate, 'g', -1, 64) + "," + strconv.Itoa(l.Burst)
+}
+
+// Set parses "<rate>,<burst>" or "0" so that a Limit can be used as a flag.
+func (l *Limit) Set(s string) error {
+	if s == "0" {
+		*l = Limit{}
+		return nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("limit %q is not <rate>,<burst>", s)
+	}
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate < 0 {
+		return fmt.Errorf("invalid rate %q", parts[0])
+	}
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst < 1 {
+		return fmt.Errorf("invalid burst %q", parts[1])
+	}
+	*l = Limit{rate, burst}
+	return nil
+}
+
+// RateLimits configures the limits applied to every request. Requests are
+// limited by remote address before authentication, then by API key and by
+// the player they act on.
+type RateLimits struct {
+	Key    Limit
+	Addr   Limit
+	Player Limit
+}
+
+var DefaultRateLimits = RateLimits{
+	Key:    Limit{50, 100},
+	Addr:   Limit{20, 40},
+	Player: Limit{5, 10},
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps one token bucket per key.
+type limiter struct {
+	name    string // the label of its rejections in rateLimited
+	limit   Limit
+	mux     sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+	hits    uint64
+}
+
+func newLimiter(name string, l Limit) *limiter {
+	return &limiter{name: name, limit: l, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of key. If there is none it returns
+// how long to wait for the next one.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.calls++
+	if l.calls%1000 == 0 {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{float64(l.limit.Burst), now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	l.hits++
+	rateLimited.Inc(l.name)
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets buckets that have refilled, they are the same as new ones.
+func (l *limiter) sweep(now time.Time) {
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *limiter) hitCount() uint64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.hits
+}
+
+type rateLimiter struct {
+	key    *limiter
+	addr   *limiter
+	player *limiter
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{newLimiter("key", limits.Key), newLimiter("addr", limits.Addr), newLimiter("player", limits.Player)}
+}
+
+func remoteHost(r *http.Request) string {
+	return hostOf(r.RemoteAddr)
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// requestPlayer is the player a request acts on, if any: the one of the
+// token, the one named by the v2 route or the playerId parameter of v1.
+func requestPlayer(r *http.Request, p Principal) string {
+	if p.PlayerId != "" {
+		return p.PlayerId
+	}
+	if player, ok := r.Context().Value(playerOfKey{}).(func(r *http.Request) string); ok {
+		return player(r)
+	}
+	return r.URL.Query().Get("playerId")
+}
+
+// tooManyRequests rejects a request that has to wait before retrying.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, ErrRateLimited)
+}
+
+type RateLimitHits struct {
+	Key    uint64
+	Addr   uint64
+	Player uint64
+}
+
+type rateLimitsHandler struct {
+	l *rateLimiter
+}
+
+func newRateLimitsHandler(l *rateLimiter) http.Handler {
+	return rateLimitsHandler{l}
+}
+
+// ServeHTTP reports how many requests each limit rejected since start.
+func (h rateLimitsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hits := RateLimitHits{h.l.key.hitCount(), h.l.addr.hitCount(), h.l.player.hitCount()}
+	js, err := json.Marshal(hits)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// checkAddr limits requests by remote address, before they are
+// authenticated. A nil rateLimiter allows everything.
+func (l *rateLimiter) checkAddr(r *http.Request, now time.Time) (bool, time.Duration) {
+	return l.allowAddr(remoteHost(r), now)
+}
+
+func (l *rateLimiter) allowAddr(host string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.addr.allow(host, now)
+}
+
+// checkPrincipal limits authenticated requests by API key and by player.
+func (l *rateLimiter) checkPrincipal(r *http.Request, p Principal, now time.Time) (bool, time.Duration) {
+	return l.allowPrincipal(p, requestPlayer(r, p), now)
+}
+
+// allowPrincipal limits by the API key of p and by the player acted on.
+func (l *rateLimiter) allowPrincipal(p Principal, player string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	if p.KeyId != "" {
+		if ok, wait := l.key.allow(p.KeyId, now); !ok {
+			return false, wait
+		}
+	}
+	if player != "" {
+		return l.player.allow(player, now)
+	}
+	return true, 0
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api"
+	"api/db"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter("key", Limit{Rate: 2, Burst: 3})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("k", now); !ok {
+			t.Fatal("burst rejected", i)
+		}
+	}
+	ok, wait := l.allow("k", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Error(ok, wait)
+	}
+	if ok, _ := l.allow("other", now); !ok {
+		t.Error("keys share a bucket")
+	}
+
+	if ok, _ := l.allow("k", now.Add(500*time.Millisecond)); !ok {
+		t.Error("bucket did not refill")
+	}
+	if l.hitCount() != 1 {
+		t.Error(l.hitCount())
+	}
+
+	if ok, _ := newLimiter("key", Limit{}).allow("k", now); !ok {
+		t.Error("zero limit limits")
+	}
+}
+
+func TestLimitFlag(t *testing.T) {
+	var l Limit
+	if err := l.Set("2.5,10"); err != nil || l != (Limit{2.5, 10}) {
+		t.Error(l, err)
+	}
+	if l.String() != "2.5,10" {
+		t.Error(l.String())
+	}
+	for _, s := range []string{"", "5", "a,1", "1,0", "-1,5"} {
+		if err := l.Set(s); err == nil {
+			t.Error("accepted", s)
+		}
+	}
+}
+
+func TestRateLimitedRequest(t *testing.T) {
+	s := db.CreateMemDb()
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := newRateLimiter(RateLimits{Player: Limit{Rate: 0.1, Burst: 1}})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := authenticator{s, NewTokenSigner([]byte("secret")), limits, nil, nil, nil}.require(ok, RoleOperator)
+
+	for i, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest("GET", "/fund?playerId=P1&points=1", nil)
+		r.Header.Set(ApiKeyHeader, key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatal(i, w.Code)
+		}
+		if status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "10" {
+			t.Error("wrong Retry-After", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimitedV2Request(t *testing.T) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := newRateLimiter(RateLimits{Player: Limit{Rate: 0.1, Burst: 1}})
+	h := newV2Handler(a, authenticator{s, NewTokenSigner([]byte("secret")), limits, nil, nil, nil}, newWebhooks(s, newEventBroker()), "", ".db")
+
+	// the player is named by the path or by the body
+	for i, c := range []struct {
+		url    string
+		body   interface{}
+		status int
+	}{
+		{"/v2/players/P1/fund", PointsRequest{500}, http.StatusOK},
+		{"/v2/players/P1/fund", PointsRequest{500}, http.StatusTooManyRequests},
+		{"/v2/players/P2/fund", PointsRequest{500}, http.StatusOK},
+		{"/v2/tournaments/1/entries", EntryRequest{PlayerId: "P2"}, http.StatusTooManyRequests},
+		{"/v2/tournaments/1/entries", EntryRequest{PlayerId: "P3"}, http.StatusNotFound},
+	} {
+		if w := do(h, "POST", c.url, key, c.body); w.Code != c.status {
+			t.Error(i, c.url, w.Code, w.Body.String())
+		}
+	}
+}
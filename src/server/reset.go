@@ -15,9 +15,9 @@ func newResetHandler(a api.Api) http.Handler {
 }
 
 func (h resetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := h.a.Reset()
+	err := h.a.Reset(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api"
+)
+
+// intParam parses an optional single-valued integer query parameter.
+func intParam(r *http.Request, name string, def int) (int, bool) {
+	v, ok := r.URL.Query()[name]
+	if !ok {
+		return def, true
+	}
+	if len(v) > 1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v[0])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+type closeSeasonHandler struct {
+	a api.Api
+}
+
+func newCloseSeasonHandler(a api.Api) http.Handler {
+	return closeSeasonHandler{a}
+}
+
+func (h closeSeasonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	percent, ok := intParam(r, "carryPercent", 0)
+	if !ok || percent > 100 {
+		badRequest(w, "carryPercent")
+		return
+	}
+
+	max, ok := intParam(r, "carryMax", 0)
+	if !ok {
+		badRequest(w, "carryMax")
+		return
+	}
+
+	season, err := h.a.CloseSeason(r.Context(), api.CarryOver{Percent: percent, Max: max})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	js, err := json.Marshal(season)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+type leaderboardHandler struct {
+	a api.Api
+}
+
+func newLeaderboardHandler(a api.Api) http.Handler {
+	return leaderboardHandler{a}
+}
+
+func (h leaderboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	seasonId, ok := intParam(r, "seasonId", 0)
+	if !ok {
+		badRequest(w, "seasonId")
+		return
+	}
+
+	standings, err := h.a.Leaderboard(r.Context(), seasonId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	js, err := json.Marshal(standings)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -1,44 +1,283 @@
 package server
 
 import (
-	"path"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
 	"os"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"api"
 	"api/db"
-	"net/http"
+	"api/db/kvdb"
+)
+
+const (
+	StorageSqlite = "sqlite"
+	StorageKv     = "kv"
 )
 
-func StartServer(curDir string) (chan struct{}, error) {
-	dbDir := path.Join(curDir, "db")
-	if err := os.MkdirAll(dbDir, 0777); err != nil {
-		return nil, err
+var ErrUnknownStorage = errors.New("Unknown storage backend")
+
+// StorageFiles maps each storage backend to its file name in the db directory.
+var StorageFiles = map[string]string{
+	StorageSqlite: "back-a-friend.db",
+	StorageKv:     "back-a-friend.kv",
+}
+
+// OpenStorage opens (and creates, if needed) the database file at dbPath
+// with the given storage backend.
+func OpenStorage(dbPath string, storage string) (db.Storage, error) {
+	switch storage {
+	case StorageSqlite:
+		mydb := &db.Db{}
+		if err := mydb.Create(dbPath); err != nil {
+			return nil, err
+		}
+		return mydb, nil
+	case StorageKv:
+		kv := &kvdb.KvDb{}
+		if err := kv.Create(dbPath); err != nil {
+			return nil, err
+		}
+		return kv, nil
 	}
+	return nil, ErrUnknownStorage
+}
+
+// DefaultHTTPAddr is the address the HTTP API listens on by default.
+const DefaultHTTPAddr = ":8080"
 
-	dbPath := path.Join(dbDir, "back-a-friend.db")
+// Server serves the HTTP and gRPC APIs and runs the background jobs, like
+// webhook deliveries, until it is shut down.
+type Server struct {
+	config Config
+
+	a     api.Api
+	http  *http.Server
+	grpc  *grpc.Server
+	hooks *webhooks
+
+	httpLis net.Listener
+	grpcLis net.Listener
+	stop    chan struct{} // stops the background jobs
+	jobs    sync.WaitGroup
+	errs    chan error
+}
 
-	mydb := &db.Db{}
-	if err := mydb.Create(dbPath); err != nil {
-		return nil, err
+func NewServer(config Config) *Server {
+	return &Server{config: config, stop: make(chan struct{}), errs: make(chan error, 2)}
+}
+
+// Start opens the database, creating the data directory if needed, listens
+// on the configured addresses and serves in the background. Errors while
+// serving are sent to Err.
+func (s *Server) Start() (rerr error) {
+	if err := s.config.validate(); err != nil {
+		return err
 	}
 
-	var err error
-	a, err := api.CreateApi(mydb)
+	backupDir := path.Join(s.config.DataDir, "backups")
+	if err := os.MkdirAll(backupDir, 0777); err != nil {
+		return err
+	}
+
+	dbPath := s.config.dbPath()
+	mydb, err := OpenStorage(dbPath, s.config.Storage)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			mydb.Stop()
+		}
+	}()
+
+	tokens, err := LoadTokenSigner(path.Join(s.config.DataDir, "token.key"))
+	if err != nil {
+		return err
+	}
+
+	s.a, err = api.CreateApi(mydb)
+	if err != nil {
+		return err
+	}
+	events := newEventBroker()
+	forwardEvents(s.a.Bus(), events)
+	auth := authenticator{mydb, tokens, newRateLimiter(s.config.Limits), newAuditLog(mydb), s.config.ClientCerts,
+		newIdempotencyCache(IdempotencyTTL, IdempotencyMaxEntries)}
+	s.hooks = newWebhooks(mydb, events)
+	s.hooks.maxAttempts = s.config.WebhookMaxAttempts
+	// running before the first request, so /readyz never sees it starting
+	s.hooks.start(s.stop, &s.jobs)
+	defer func() {
+		if rerr != nil {
+			close(s.stop)
+			s.jobs.Wait()
+		}
+	}()
+
+	s.httpLis, err = net.Listen("tcp", s.config.HTTPAddr)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// gRPC is served with the same certificates as HTTP, never in clear
+	// text next to an encrypted HTTP API
+	var grpcOpts []grpc.ServerOption
+	if s.config.TLSCert != "" {
+		files, err := newTLSFiles(s.config)
+		if err != nil {
+			s.httpLis.Close()
+			return err
+		}
+		s.httpLis = tls.NewListener(s.httpLis, files.listenerConfig())
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(files.grpcConfig())))
+	}
+	if s.config.GrpcAddr != "" {
+		s.grpcLis, err = net.Listen("tcp", s.config.GrpcAddr)
+		if err != nil {
+			s.httpLis.Close()
+			return err
+		}
+		s.grpc = newGrpcServer(s.a, auth, events, backupDir, path.Ext(dbPath), grpcOpts...)
+		go func() { s.serve(s.grpc.Serve(s.grpcLis)) }()
 	}
 
-	doneCh := make(chan struct{})
+	s.http = &http.Server{
+		Handler:           newHandler(s.a, auth, s.hooks, backupDir, path.Ext(dbPath)),
+		ReadHeaderTimeout: time.Duration(s.config.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(s.config.ReadTimeout),
+		WriteTimeout:      time.Duration(s.config.WriteTimeout),
+		IdleTimeout:       time.Duration(s.config.IdleTimeout),
+	}
+	go func() { s.serve(s.http.Serve(s.httpLis)) }()
+	return nil
+}
+
+func (s *Server) serve(err error) {
+	if err != nil && err != http.ErrServerClosed && err != grpc.ErrServerStopped {
+		s.errs <- err
+	}
+}
+
+// Err receives the errors that made the HTTP or gRPC server stop serving.
+// Shutdown still has to be called after one.
+func (s *Server) Err() <-chan error {
+	return s.errs
+}
+
+// HTTPAddr returns the address the HTTP API listens on, useful when the
+// configured port is 0.
+func (s *Server) HTTPAddr() string {
+	return s.httpLis.Addr().String()
+}
+
+// Shutdown stops a started server: it stops accepting requests, ends event
+// streams, waits for the requests in flight and the background jobs and
+// closes the database. If ctx ends first, the remaining connections are
+// closed and its error is returned; the database is closed anyway.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.hooks.events.shutdown()
+
+	var wg sync.WaitGroup
+	var httpErr error
+	wg.Add(1)
 	go func() {
-		// init http-server
-		http.Handle("/take", newTakeHandler(a))
-		http.Handle("/fund", newFundHandler(a))
-		http.Handle("/balance", newBalanceHandler(a))
-		http.Handle("/announceTournament", newAnnounceTournament(a))
-		http.Handle("/joinTournament", newJoinTournament(a))
-		http.Handle("/resultTournament", newResultTournament(a))
-		http.Handle("/reset", newResetHandler(a))
-		http.ListenAndServe(":8080", nil)
+		defer wg.Done()
+		if httpErr = s.http.Shutdown(ctx); httpErr != nil {
+			s.http.Close()
+		}
 	}()
-	return doneCh, nil
+	if s.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				s.grpc.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				s.grpc.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(s.stop)
+	jobsDone := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(jobsDone)
+	}()
+	var err error
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if stopErr := s.a.Stop(); err == nil {
+		err = stopErr
+	}
+	if err == nil {
+		err = httpErr
+	}
+	return err
+}
+
+// NewHandler returns the HTTP API of a: the v1 endpoints, /v2,
+// /openapi.json, /metrics and the health probes /healthz and /readyz.
+// keys holds the API keys requests are checked against, the webhooks
+// changes are delivered to and the audit log requests are recorded in.
+// Changes made through the handler are streamed at /v2/events and sent to
+// webhooks in the background until stop is closed; jobs is done once the
+// deliveries in flight have finished.
+func NewHandler(a api.Api, keys db.Storage, tokens *TokenSigner, limits RateLimits, backupDir string, backupExt string, stop <-chan struct{}, jobs *sync.WaitGroup) http.Handler {
+	events := newEventBroker()
+	forwardEvents(a.Bus(), events)
+	hooks := newWebhooks(keys, events)
+	hooks.start(stop, jobs)
+	return newHandler(a, authenticator{keys, tokens, newRateLimiter(limits), newAuditLog(keys), nil,
+		newIdempotencyCache(IdempotencyTTL, IdempotencyMaxEntries)}, hooks, backupDir, backupExt)
+}
+
+func newHandler(a api.Api, auth authenticator, hooks *webhooks, backupDir string, backupExt string) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, instrument(pattern, h))
+	}
+	all := []string{RoleOperator, RoleGameServer, RolePlayer}
+	handle("/take", auth.require(newTakeHandler(a), all...))
+	handle("/fund", auth.require(newFundHandler(a), RoleOperator))
+	handle("/balance", auth.require(newBalanceHandler(a), all...))
+	handle("/announceTournament", auth.require(newAnnounceTournament(a), RoleOperator, RoleGameServer))
+	handle("/joinTournament", auth.require(newJoinTournament(a), all...))
+	handle("/requestBacking", auth.require(newRequestBackingHandler(a), all...))
+	handle("/acceptBacking", auth.require(newAcceptBackingHandler(a), all...))
+	handle("/resultTournament", auth.require(newResultTournament(a), RoleOperator, RoleGameServer))
+	handle("/reset", auth.require(newResetHandler(a), RoleOperator))
+	handle("/closeSeason", auth.require(newCloseSeasonHandler(a), RoleOperator))
+	handle("/leaderboard", auth.require(newLeaderboardHandler(a), all...))
+	handle("/playerToken", auth.require(newPlayerTokenHandler(auth.tokens), RoleOperator))
+	handle("/rateLimits", auth.require(newRateLimitsHandler(auth.limits), RoleOperator))
+	handle("/backup", auth.require(newBackupHandler(a, backupDir, backupExt), RoleOperator))
+	handle("/restore", auth.require(newRestoreHandler(a, backupDir), RoleOperator))
+	handle("/export", auth.require(newExportHandler(a), RoleOperator))
+	handle("/import", auth.require(newImportHandler(a), RoleOperator))
+	handle("/openapi.json", auth.require(newOpenAPIHandler(), all...))
+	handle("/metrics", auth.require(newMetricsHandler(a), RoleOperator))
+	handle("/healthz", newHealthHandler())
+	handle("/readyz", newReadinessHandler(auth.keys, hooks))
+	mux.Handle("/v2/", newV2Handler(a, auth, hooks, backupDir, backupExt))
+	return logRequests(mux)
 }
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// startServer starts a Server in a temporary directory and returns it
+// with an operator key. configure, if not nil, adjusts the configuration
+// before the start.
+func startServer(t *testing.T, configure func(*Config)) (*Server, string, func()) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStorage(path.Join(dir, StorageFiles[StorageKv]), StorageKv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+
+	config := DefaultConfig()
+	config.DataDir = dir
+	config.Storage = StorageKv
+	config.HTTPAddr = "127.0.0.1:0"
+	config.GrpcAddr = "127.0.0.1:0"
+	if configure != nil {
+		configure(&config)
+	}
+	srv := NewServer(config)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return srv, key, func() { os.RemoveAll(dir) }
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	srv, key, cleanup := startServer(t, nil)
+	defer cleanup()
+	base := "http://" + srv.HTTPAddr()
+
+	// an event stream is ended by the shutdown
+	r, _ := http.NewRequest("GET", base+"/v2/events", nil)
+	r.Header.Set(ApiKeyHeader, key)
+	stream, err := http.DefaultClient.Do(r)
+	if err != nil || stream.StatusCode != http.StatusOK {
+		t.Fatal(stream, err)
+	}
+	defer stream.Body.Close()
+
+	// a request still sending its body when the shutdown starts is served
+	body, send := io.Pipe()
+	r, _ = http.NewRequest("POST", base+"/v2/players/P1/fund", body)
+	r.Header.Set(ApiKeyHeader, key)
+	r.Header.Set("Content-Type", "application/json")
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- resp
+	}()
+	fmt.Fprint(send, `{"Points":`)
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprint(send, `100}`)
+	send.Close()
+
+	if resp := <-responses; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("request in flight was not drained", resp)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	if _, err := ioutil.ReadAll(stream.Body); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := http.Get(base + "/openapi.json"); err == nil {
+		t.Error("still accepting requests")
+	}
+	if srv.hooks.isRunning() {
+		t.Error("webhooks still running")
+	}
+
+	// the database was closed and holds the drained request
+	s, err := OpenStorage(srv.config.dbPath(), StorageKv)
+	if err != nil {
+		t.Fatal("database still open", err)
+	}
+	defer s.Stop()
+	if pts, err := s.PlayerPoints("P1"); err != nil || pts != 100 {
+		t.Error(pts, err)
+	}
+}
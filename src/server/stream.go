@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api/apierr"
+)
+
+// LastEventIdHeader is sent by reconnecting event stream clients, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html.
+const LastEventIdHeader = "Last-Event-ID"
+
+var errStreamingUnsupported = apierr.New(apierr.CodeInternal, "Streaming not supported")
+
+// eventHeartbeat is how often an idle stream sends a comment, so proxies
+// do not close it.
+const eventHeartbeat = 15 * time.Second
+
+// eventFilter selects the events of one tournament and/or player.
+type eventFilter struct {
+	tournamentId int
+	playerId     string
+}
+
+func (f eventFilter) match(e Event) bool {
+	if f.tournamentId != 0 && e.TournamentId != f.tournamentId {
+		return false
+	}
+	return f.playerId == "" || e.involves(f.playerId)
+}
+
+// canSee reports whether p may receive e. Tournament events are public,
+// changes to accounts are not.
+func (p Principal) canSee(e Event) bool {
+	return !e.private() || p.canActAs(e.PlayerId)
+}
+
+// lastEventId returns the id a client resumes from, taken from the
+// Last-Event-ID header or else the lastEventId parameter.
+func lastEventId(r *http.Request) (uint64, bool, error) {
+	s := r.Header.Get(LastEventIdHeader)
+	if s == "" {
+		s = r.URL.Query().Get("lastEventId")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, true, err
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	js, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, js)
+	return err
+}
+
+// streamEvents sends events as server-sent events until the client goes
+// away or the server shuts down. A client that falls too far behind is
+// disconnected and resumes with the id of the last event it received.
+func (h v2) streamEvents(w http.ResponseWriter, r *http.Request, p []string) {
+	q := r.URL.Query()
+	var f eventFilter
+	if s := q.Get("tournamentId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			badRequest(w, "tournamentId")
+			return
+		}
+		f.tournamentId = id
+	}
+	f.playerId = q.Get("playerId")
+	if f.playerId != "" && !actAs(w, r, f.playerId) {
+		return
+	}
+	lastId, resume, err := lastEventId(r)
+	if err != nil {
+		badRequest(w, LastEventIdHeader)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errStreamingUnsupported)
+		return
+	}
+
+	var missed []Event
+	var events <-chan Event
+	var cancel func()
+	if resume {
+		missed, events, cancel = h.events.resume(lastId)
+	} else {
+		events, cancel = h.events.subscribe()
+	}
+	defer cancel()
+
+	principal := principalFrom(r)
+	send := func(e Event) bool {
+		if !f.match(e) || !principal.canSee(e) {
+			return true
+		}
+		return writeEvent(w, e) == nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range missed {
+		if !send(e) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.events.stopping():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok || !send(e) {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"api"
+	"api/db"
+)
+
+func setupStream(t *testing.T, stop <-chan struct{}, jobs *sync.WaitGroup) (*httptest.Server, http.Handler, string, *TokenSigner) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenSigner([]byte("secret"))
+	h := NewHandler(a, s, tokens, RateLimits{}, "", ".db", stop, jobs)
+	return httptest.NewServer(h), h, key, tokens
+}
+
+// openStream opens /v2/events with query and headers and checks the
+// response status.
+func openStream(t *testing.T, ts *httptest.Server, query string, headers map[string]string, status int) io.ReadCloser {
+	r, err := http.NewRequest("GET", ts.URL+"/v2/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		resp.Body.Close()
+		t.Fatal(query, resp.StatusCode)
+	}
+	return resp.Body
+}
+
+// readEvents reads n events and checks the id line matches the data.
+func readEvents(t *testing.T, body io.Reader, n int) []Event {
+	events := []Event{}
+	sc := bufio.NewScanner(body)
+	id := ""
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = line[len("id: "):]
+		case strings.HasPrefix(line, "data: "):
+			var e Event
+			if err := json.Unmarshal([]byte(line[len("data: "):]), &e); err != nil {
+				t.Fatal(err)
+			}
+			if strconv.FormatUint(e.Id, 10) != id {
+				t.Fatal("id line", id, "for event", e.Id)
+			}
+			events = append(events, e)
+		}
+	}
+	if len(events) < n {
+		t.Fatal("stream ended after", events, sc.Err())
+	}
+	return events
+}
+
+func TestStream_Events(t *testing.T) {
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	defer jobs.Wait()
+	defer close(stop)
+	ts, h, key, _ := setupStream(t, stop, jobs)
+	defer ts.Close()
+	auth := map[string]string{ApiKeyHeader: key}
+
+	live := openStream(t, ts, "", auth, http.StatusOK)
+	defer live.Close()
+
+	steps := []struct {
+		url  string
+		body interface{}
+	}{
+		{"/v2/players/P1/fund", PointsRequest{500}},
+		{"/v2/players/P2/fund", PointsRequest{500}},
+		{"/v2/tournaments", TournamentRequest{1, 400}},
+		{"/v2/tournaments/1/entries", EntryRequest{PlayerId: "P1", Backers: []string{"P2"}}},
+		{"/v2/tournaments/1/result", nil},
+	}
+	for _, s := range steps {
+		if w := do(h, "POST", s.url, key, s.body); w.Code >= 300 {
+			t.Fatal(s.url, w.Code, w.Body.String())
+		}
+	}
+
+	want := []string{
+		EventFunded, EventBalanceChanged, EventFunded, EventBalanceChanged, EventAnnounced,
+		EventJoined, EventBalanceChanged, EventBalanceChanged,
+		EventSettled, EventBalanceChanged, EventBalanceChanged,
+	}
+	events := readEvents(t, live, len(want))
+	for i, e := range events {
+		if e.Type != want[i] || e.Id != uint64(i+1) {
+			t.Error(i, e)
+		}
+	}
+	if e := events[8]; e.TournamentId != 1 || e.PlayerId != "P1" || len(e.Payouts) != 2 {
+		t.Error(e)
+	}
+	if e := events[10]; e.Balance == nil || *e.Balance != 500 {
+		t.Error("P2 got its share back", e)
+	}
+
+	// a reconnecting client resumes after the last event it saw and only
+	// gets its tournament's events
+	resumed := openStream(t, ts, "?tournamentId=1", map[string]string{ApiKeyHeader: key, LastEventIdHeader: "6"}, http.StatusOK)
+	defer resumed.Close()
+	if e := readEvents(t, resumed, 1)[0]; e.Id != 7 {
+		t.Error(e)
+	}
+
+	// per player, from the start of the history
+	p2 := openStream(t, ts, "?playerId=P2&lastEventId=0", auth, http.StatusOK)
+	defer p2.Close()
+	for _, e := range readEvents(t, p2, 4) {
+		if !e.involves("P2") {
+			t.Error(e)
+		}
+	}
+
+	openStream(t, ts, "?lastEventId=x", auth, http.StatusBadRequest).Close()
+}
+
+func TestStream_PlayerOnlySeesOwnBalance(t *testing.T) {
+	stop, jobs := make(chan struct{}), &sync.WaitGroup{}
+	defer jobs.Wait()
+	defer close(stop)
+	ts, h, key, tokens := setupStream(t, stop, jobs)
+	defer ts.Close()
+
+	token, _, err := tokens.Issue("P2", PlayerTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + token}
+	openStream(t, ts, "?playerId=P1", bearer, http.StatusForbidden).Close()
+
+	stream := openStream(t, ts, "", bearer, http.StatusOK)
+	defer stream.Close()
+	do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{10})
+	do(h, "POST", "/v2/tournaments", key, TournamentRequest{1, 400})
+	do(h, "POST", "/v2/players/P2/fund", key, PointsRequest{20})
+
+	events := readEvents(t, stream, 3)
+	if events[0].Type != EventAnnounced || events[1].Type != EventFunded || events[2].PlayerId != "P2" || *events[2].Balance != 20 {
+		t.Error(events)
+	}
+}
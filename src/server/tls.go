@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"logging"
+)
+
+// tlsReloadInterval is how often the TLS files are checked for changes,
+// at most once per handshake.
+const tlsReloadInterval = time.Second
+
+var errNoClientCAs = errors.New("no certificates in the client CA file")
+
+// CertIdentity is the caller a client certificate stands for.
+type CertIdentity struct {
+	Role     string
+	PlayerId string // the account a RolePlayer certificate acts on
+}
+
+// certPrincipal returns the principal of the verified client certificate
+// of a connection, if it is mapped to an identity. The key id of such a
+// principal is "cert:" and the common name.
+func (au authenticator) certPrincipal(state *tls.ConnectionState) (Principal, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return Principal{}, false
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	id, ok := au.certs[name]
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{Role: id.Role, PlayerId: id.PlayerId, KeyId: "cert:" + name}, true
+}
+
+// tlsFiles serves the certificate, key and client CAs of the configured
+// files and loads them again when one of them changes, so renewed
+// certificates are picked up without a restart. A change that fails to
+// load is logged and the previous files stay in use.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+	interval                  time.Duration
+
+	mux     sync.Mutex
+	config  *tls.Config
+	mtimes  []time.Time
+	checked time.Time
+}
+
+func newTLSFiles(c Config) (*tlsFiles, error) {
+	f := &tlsFiles{certFile: c.TLSCert, keyFile: c.TLSKey, caFile: c.TLSClientCA, interval: tlsReloadInterval}
+	switch {
+	case c.TLSRequireClientCert:
+		f.clientAuth = tls.RequireAndVerifyClientCert
+	case c.TLSClientCA != "":
+		f.clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	var err error
+	if f.mtimes, err = f.modTimes(); err != nil {
+		return nil, err
+	}
+	if f.config, err = f.load(); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+	return f, nil
+}
+
+// listenerConfig is the configuration of the TLS listener, which asks f
+// for the current one on every handshake.
+func (f *tlsFiles) listenerConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: f.configForClient}
+}
+
+// grpcConfig is the listenerConfig of the gRPC listener, which only
+// speaks HTTP/2.
+func (f *tlsFiles) grpcConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config, err := f.configForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.NextProtos = []string{"h2"}
+		return config, nil
+	}}
+}
+
+func (f *tlsFiles) files() []string {
+	files := []string{f.certFile, f.keyFile}
+	if f.caFile != "" {
+		files = append(files, f.caFile)
+	}
+	return files
+}
+
+func (f *tlsFiles) modTimes() ([]time.Time, error) {
+	var res []time.Time
+	for _, name := range f.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, info.ModTime())
+	}
+	return res, nil
+}
+
+func (f *tlsFiles) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+		ClientAuth:   f.clientAuth,
+	}
+	if f.caFile != "" {
+		pem, err := ioutil.ReadFile(f.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errNoClientCAs
+		}
+	}
+	return config, nil
+}
+
+func (f *tlsFiles) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	now := time.Now()
+	if now.Sub(f.checked) < f.interval {
+		return f.config, nil
+	}
+	f.checked = now
+
+	mtimes, err := f.modTimes()
+	if err != nil {
+		logging.Error(context.Background(), "tls reload failed", err, nil)
+		return f.config, nil
+	}
+	changed := false
+	for i, t := range mtimes {
+		changed = changed || !t.Equal(f.mtimes[i])
+	}
+	if !changed {
+		return f.config, nil
+	}
+
+	config, err := f.load()
+	if err != nil {
+		// possibly caught halfway through an update, retry on the next check
+		logging.Error(context.Background(), "tls reload failed", err, nil)
+		return f.config, nil
+	}
+	f.config = config
+	f.mtimes = mtimes
+	return f.config, nil
+}
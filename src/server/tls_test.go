@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"rpc"
+)
+
+// testCert is a certificate and its key, signed by parent or self-signed
+// if parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key, der}
+}
+
+func (c *testCert) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPem(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	if err := ioutil.WriteFile(certFile, c.pem(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, c.keyPem(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem(), c.keyPem(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLS_ClientCertificates(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	client := newTestCert(t, "game-server-1", ca)
+	stranger := newTestCert(t, "game-server-2", newTestCert(t, "other CA", nil))
+
+	srv, _, cleanup := startServer(t, func(c *Config) {
+		c.TLSCert = path.Join(c.DataDir, "server.pem")
+		c.TLSKey = path.Join(c.DataDir, "server.key")
+		c.TLSClientCA = path.Join(c.DataDir, "ca.pem")
+		c.TLSRequireClientCert = true
+		c.ClientCerts = map[string]CertIdentity{"game-server-1": {Role: RoleGameServer}}
+		newTestCert(t, "localhost", ca).write(t, c.TLSCert, c.TLSKey)
+		if err := ioutil.WriteFile(c.TLSClientCA, ca.pem(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer cleanup()
+	defer srv.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientWith := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	_, port, _ := net.SplitHostPort(srv.HTTPAddr())
+	base := "https://localhost:" + port
+	body := []byte(`{"TournamentId":1,"Deposit":1000}`)
+
+	resp, err := clientWith(client.tlsCertificate(t)).Post(base+"/v2/tournaments", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Error("mapped client certificate", resp.StatusCode)
+	}
+
+	if _, err := clientWith().Get(base + "/openapi.json"); err == nil {
+		t.Error("accepted a connection without client certificate")
+	}
+	if _, err := clientWith(stranger.tlsCertificate(t)).Get(base + "/openapi.json"); err == nil {
+		t.Error("accepted a client certificate of another CA")
+	}
+
+	// gRPC is served with the same certificates
+	_, port, _ = net.SplitHostPort(srv.grpcLis.Addr().String())
+	call := func(creds credentials.TransportCredentials) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.Dial("localhost:"+port, append(rpc.DialOptions(), grpc.WithTransportCredentials(creds))...)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = rpc.NewBackAFriendClient(conn).ActiveTournament(ctx, &rpc.Empty{})
+		return err
+	}
+	if err := call(credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tlsCertificate(t)}})); err != nil {
+		t.Error("gRPC with a mapped client certificate", err)
+	}
+	if err := call(insecure.NewCredentials()); err == nil {
+		t.Error("gRPC served in clear text")
+	}
+}
+
+func TestTLS_ClientCertificateIdentity(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	au := authenticator{certs: map[string]CertIdentity{"ops": {Role: RoleOperator}}}
+	state := func(c *testCert) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.cert, ca.cert}}}
+	}
+
+	r, _ := http.NewRequest("GET", "/v2/audit", nil)
+	r.TLS = state(newTestCert(t, "ops", ca))
+	if p, err := au.authenticate(r); err != nil || p != (Principal{Role: RoleOperator, KeyId: "cert:ops"}) {
+		t.Error(p, err)
+	}
+
+	// an unverified or unmapped certificate does not authenticate
+	r.TLS = &tls.ConnectionState{PeerCertificates: r.TLS.VerifiedChains[0]}
+	if _, err := au.authenticate(r); err == nil {
+		t.Error("unverified certificate accepted")
+	}
+	r.TLS = state(newTestCert(t, "someone", ca))
+	if _, err := au.authenticate(r); err == nil {
+		t.Error("unmapped certificate accepted")
+	}
+}
+
+func TestTLS_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := DefaultConfig()
+	c.TLSCert, c.TLSKey = path.Join(dir, "server.pem"), path.Join(dir, "server.key")
+	first := newTestCert(t, "first", nil)
+	first.write(t, c.TLSCert, c.TLSKey)
+	f, err := newTLSFiles(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.interval = 0
+	served := func() string {
+		config, err := f.configForClient(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Subject.CommonName
+	}
+
+	newTestCert(t, "second", nil).write(t, c.TLSCert, c.TLSKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(c.TLSCert, later, later)
+	os.Chtimes(c.TLSKey, later, later)
+	if cn := served(); cn != "second" {
+		t.Error("changed certificate not loaded", cn)
+	}
+
+	// a broken update keeps the certificate in use
+	if err := ioutil.WriteFile(c.TLSKey, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(c.TLSKey, later, later)
+	if cn := served(); cn != "second" {
+		t.Error("broken update replaced the certificate", cn)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"api/apierr"
+)
+
+// PlayerTokenTTL is how long a player token is valid after it was issued.
+const PlayerTokenTTL = 24 * time.Hour
+
+var (
+	ErrInvalidToken = apierr.New(apierr.CodeUnauthenticated, "Invalid token")
+	ErrTokenExpired = apierr.New(apierr.CodeUnauthenticated, "Token expired")
+)
+
+// tokenHeader is the encoded JOSE header of every token: tokens are JWTs
+// signed with HMAC-SHA256.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenSigner issues and verifies player tokens.
+type TokenSigner struct {
+	key []byte
+}
+
+func NewTokenSigner(key []byte) *TokenSigner {
+	return &TokenSigner{key}
+}
+
+// LoadTokenSigner reads the signing key from path, creating a random one if
+// the file does not exist. Replacing the file invalidates all issued tokens.
+func LoadTokenSigner(path string) (*TokenSigner, error) {
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret, err := randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(secret)
+		if err := ioutil.WriteFile(path, key, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return NewTokenSigner(key), nil
+}
+
+func (s *TokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token binding requests to playerId and the time it
+// expires at.
+func (s *TokenSigner) Issue(playerId string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	claims, err := json.Marshal(tokenClaims{playerId, now.Unix(), expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	payload := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + s.sign(payload), expiresAt, nil
+}
+
+// Verify checks the signature and expiry of token and returns the player it
+// was issued for.
+func (s *TokenSigner) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return "", ErrInvalidToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		return "", ErrInvalidToken
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(js, &claims); err != nil || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", ErrTokenExpired
+	}
+	return claims.Subject, nil
+}
@@ -21,34 +21,34 @@ func (h announceTournament) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	tourId, ok := q["tournamentId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "tournamentId")
 		return
 	}
 
 	deposit, ok := q["deposit"]
 	if !ok {
-		http.Error(w, "deposit", http.StatusBadRequest)
+		badRequest(w, "deposit")
 		return
 	}
 
 	if len(tourId) > 1 {
-		http.Error(w, "deposit", http.StatusBadRequest)
+		badRequest(w, "tournamentId")
 		return
 	}
 
 	tid, err := strconv.Atoi(tourId[0])
 	if err != nil {
-		http.Error(w, "tourId", http.StatusBadRequest)
+		badRequest(w, "tournamentId")
 		return
 	}
 	d, err := strconv.Atoi(deposit[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, "deposit")
 		return
 	}
 
-	if err := h.a.AnnounceTournament(tid, d); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.a.AnnounceTournament(r.Context(), tid, d); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -67,30 +67,41 @@ func (h joinTournament) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	tourId, ok := q["tournamentId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "tournamentId")
 		return
 	}
 
 	playerId, ok := q["playerId"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "playerId")
 		return
 	}
 
 	if len(tourId) > 1 || len(playerId) > 1 {
-		w.WriteHeader(http.StatusBadRequest)
+		badRequest(w, "tournamentId", "playerId")
 		return
 	}
 
 	tid, err := strconv.Atoi(tourId[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, "tournamentId")
 		return
 	}
 
-	backers, ok := q["backerId"]
-	if err := h.a.JoinTournament(tid, playerId[0], backers); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	p := principalFrom(r)
+	if !p.canActAs(playerId[0]) {
+		writeError(w, ErrForbidden)
+		return
+	}
+
+	backers := q["backerId"]
+	if err := checkBackers(r.Context(), h.a, p, tid, playerId[0], backers); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.a.JoinTournament(r.Context(), tid, playerId[0], backers); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -105,9 +116,9 @@ func newResultTournament(a api.Api) http.Handler {
 }
 
 func (h resultTournament) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	winner, err := h.a.ResultTournament()
+	winner, err := h.a.ResultTournament(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
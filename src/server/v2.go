@@ -0,0 +1,520 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"api"
+	"api/apierr"
+	"api/db"
+	"logging"
+)
+
+// route is one endpoint of the v2 API. In pattern "{}" matches any single
+// path segment; the matched segments are passed to handle in order.
+type route struct {
+	method  string
+	pattern string
+	roles   []string
+	handle  func(w http.ResponseWriter, r *http.Request, params []string)
+}
+
+type paramsKey struct{}
+
+// routePlayers resolve the player a route acts on, for the per-player
+// rate limit of requests made with an API key.
+var routePlayers = map[string]func(r *http.Request) string{
+	"/v2/players/{}":                                   pathPlayer(0),
+	"/v2/players/{}/fund":                              pathPlayer(0),
+	"/v2/players/{}/take":                              pathPlayer(0),
+	"/v2/players/{}/tokens":                            pathPlayer(0),
+	"/v2/tournaments/{}/entries":                       bodyPlayer,
+	"/v2/tournaments/{}/backing-requests":              bodyPlayer,
+	"/v2/tournaments/{}/backing-requests/{}/{}/accept": pathPlayer(2),
+	"/v2/tournaments/{}/backing-requests/{}/accepted":  pathPlayer(1),
+}
+
+type playerOfKey struct{}
+
+// pathPlayer takes the player from the i-th placeholder of the path.
+func pathPlayer(i int) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		params, _ := r.Context().Value(paramsKey{}).([]string)
+		if i < len(params) {
+			return params[i]
+		}
+		return ""
+	}
+}
+
+// bodyPlayer takes the player from the PlayerId of the JSON body, which
+// is left unread for validation and the handler. Bodies longer than
+// maxBodySize name no player, validation rejects them.
+func bodyPlayer(r *http.Request) string {
+	head, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	var req struct{ PlayerId string }
+	json.Unmarshal(head, &req)
+	return req.PlayerId
+}
+
+// v2Router dispatches /v2 requests to routes, each behind its own
+// authentication and role check.
+type v2Router struct {
+	routes   []route
+	handlers []http.Handler
+}
+
+// v2 implements the resource-oriented JSON API. It calls the same Api as
+// the v1 handlers, which keep working unchanged.
+type v2 struct {
+	a         api.Api
+	tokens    *TokenSigner
+	events    *eventBroker
+	hooks     *webhooks
+	store     db.Storage // holds the audit log
+	backupDir string
+	backupExt string
+}
+
+func newV2Handler(a api.Api, auth authenticator, hooks *webhooks, backupDir string, backupExt string) http.Handler {
+	h := v2{a, auth.tokens, hooks.events, hooks, auth.keys, backupDir, backupExt}
+	all := []string{RoleOperator, RoleGameServer, RolePlayer}
+	routes := []route{
+		{"GET", "/v2/players/{}", all, h.getPlayer},
+		{"POST", "/v2/players/{}/fund", []string{RoleOperator}, h.fund},
+		{"POST", "/v2/players/{}/take", all, h.take},
+		{"POST", "/v2/players/{}/tokens", []string{RoleOperator}, h.issueToken},
+		{"POST", "/v2/tournaments", []string{RoleOperator, RoleGameServer}, h.createTournament},
+		{"POST", "/v2/tournaments/{}/entries", all, h.join},
+		{"POST", "/v2/tournaments/{}/backing-requests", all, h.requestBacking},
+		{"POST", "/v2/tournaments/{}/backing-requests/{}/{}/accept", all, h.acceptBacking},
+		{"GET", "/v2/tournaments/active", all, h.activeTournament},
+		{"GET", "/v2/tournaments/{}/backing-requests/{}/accepted", all, h.acceptedBackers},
+		{"POST", "/v2/tournaments/{}/result", []string{RoleOperator, RoleGameServer}, h.result},
+		{"POST", "/v2/seasons", []string{RoleOperator}, h.closeSeason},
+		{"GET", "/v2/seasons/{}/leaderboard", all, h.leaderboard},
+		{"POST", "/v2/reset", []string{RoleOperator}, h.reset},
+		{"POST", "/v2/backups", []string{RoleOperator}, h.backup},
+		{"POST", "/v2/backups/{}/restore", []string{RoleOperator}, h.restore},
+		{"GET", "/v2/export", []string{RoleOperator}, h.export},
+		{"POST", "/v2/import", []string{RoleOperator}, h.importRecords},
+		{"GET", "/v2/events", all, h.streamEvents},
+		{"POST", "/v2/webhooks", []string{RoleOperator}, h.createWebhook},
+		{"GET", "/v2/webhooks", []string{RoleOperator}, h.listWebhooks},
+		{"DELETE", "/v2/webhooks/{}", []string{RoleOperator}, h.deleteWebhook},
+		{"GET", "/v2/webhooks/{}/deliveries", []string{RoleOperator}, h.listDeliveries},
+		{"POST", "/v2/webhooks/{}/deliveries/{}/retry", []string{RoleOperator}, h.retryDelivery},
+		{"GET", "/v2/audit", []string{RoleOperator}, h.listAudit},
+		{"GET", "/v2/audit/verify", []string{RoleOperator}, h.verifyAudit},
+	}
+
+	router := v2Router{routes: routes}
+	for _, rt := range routes {
+		handle := rt.handle
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, _ := r.Context().Value(paramsKey{}).([]string)
+			handle(w, r, params)
+		})
+		router.handlers = append(router.handlers, instrument(rt.pattern, auth.require(inner, rt.roles...)))
+	}
+	return router
+}
+
+// matchPath returns the segments of p matched by the placeholders of
+// pattern.
+func matchPath(pattern string, p string) ([]string, bool) {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	if len(ps) != len(segs) {
+		return nil, false
+	}
+
+	params := []string{}
+	for i, s := range ps {
+		if s == "{}" {
+			if segs[i] == "" {
+				return nil, false
+			}
+			params = append(params, segs[i])
+		} else if s != segs[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (rt v2Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowed := []string{}
+	for i, route := range rt.routes {
+		params, ok := matchPath(route.pattern, r.URL.Path)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		ctx := context.WithValue(r.Context(), paramsKey{}, params)
+		if player, ok := routePlayers[route.pattern]; ok {
+			ctx = context.WithValue(ctx, playerOfKey{}, player)
+		}
+		rt.handlers[i].ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, ErrMethodNotAllowed)
+		return
+	}
+	writeError(w, ErrNoSuchResource)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, errInvalidBody)
+		return false
+	}
+	return true
+}
+
+// actAs rejects requests acting on an account the caller is not bound to.
+func actAs(w http.ResponseWriter, r *http.Request, playerId string) bool {
+	if !principalFrom(r).canActAs(playerId) {
+		writeError(w, ErrForbidden)
+		return false
+	}
+	return true
+}
+
+func tourIdParam(w http.ResponseWriter, s string) (int, bool) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		writeError(w, apierr.Invalid("Invalid tournament id"))
+		return 0, false
+	}
+	return id, true
+}
+
+type PointsRequest struct {
+	Points int
+}
+
+func (h v2) getPlayer(w http.ResponseWriter, r *http.Request, p []string) {
+	if !actAs(w, r, p[0]) {
+		return
+	}
+
+	seasonId, ok := intParam(r, "seasonId", 0)
+	if !ok {
+		writeError(w, apierr.Invalid("Invalid seasonId"))
+		return
+	}
+
+	balance, err := h.a.SeasonBalance(r.Context(), seasonId, p[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Balance{p[0], balance})
+}
+
+func (h v2) changePoints(w http.ResponseWriter, r *http.Request, playerId string, change func(context.Context, string, int) error) {
+	var req PointsRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Points <= 0 {
+		writeError(w, apierr.Invalid("Points must be positive"))
+		return
+	}
+
+	if err := change(r.Context(), playerId, req.Points); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the change is applied, failing now would make clients repeat it
+	balance, err := h.a.Balance(r.Context(), playerId)
+	if err != nil {
+		logging.Error(r.Context(), "balance not read after change", err, logging.Fields{"playerId": playerId})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, Balance{playerId, balance})
+}
+
+func (h v2) fund(w http.ResponseWriter, r *http.Request, p []string) {
+	h.changePoints(w, r, p[0], h.a.Fund)
+}
+
+func (h v2) take(w http.ResponseWriter, r *http.Request, p []string) {
+	if !actAs(w, r, p[0]) {
+		return
+	}
+	h.changePoints(w, r, p[0], h.a.Take)
+}
+
+func (h v2) issueToken(w http.ResponseWriter, r *http.Request, p []string) {
+	token, expiresAt, err := h.tokens.Issue(p[0], PlayerTokenTTL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, PlayerToken{p[0], token, expiresAt})
+}
+
+type TournamentRequest struct {
+	TournamentId int
+	Deposit      int
+}
+
+func (h v2) createTournament(w http.ResponseWriter, r *http.Request, p []string) {
+	var req TournamentRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Deposit <= 0 {
+		writeError(w, apierr.Invalid("Deposit must be positive"))
+		return
+	}
+
+	if err := h.a.AnnounceTournament(r.Context(), req.TournamentId, req.Deposit); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+type EntryRequest struct {
+	PlayerId string
+	Backers  []string
+}
+
+func (h v2) join(w http.ResponseWriter, r *http.Request, p []string) {
+	tid, ok := tourIdParam(w, p[0])
+	if !ok {
+		return
+	}
+
+	var req EntryRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.PlayerId == "" {
+		writeError(w, apierr.Invalid("PlayerId is required"))
+		return
+	}
+	if !actAs(w, r, req.PlayerId) {
+		return
+	}
+
+	if err := checkBackers(r.Context(), h.a, principalFrom(r), tid, req.PlayerId, req.Backers); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.a.JoinTournament(r.Context(), tid, req.PlayerId, req.Backers); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Backers == nil {
+		req.Backers = []string{}
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+type BackingRequest struct {
+	PlayerId string
+	BackerId string
+}
+
+func (h v2) requestBacking(w http.ResponseWriter, r *http.Request, p []string) {
+	tid, ok := tourIdParam(w, p[0])
+	if !ok {
+		return
+	}
+
+	var req BackingRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.PlayerId == "" || req.BackerId == "" {
+		writeError(w, apierr.Invalid("PlayerId and BackerId are required"))
+		return
+	}
+	if !actAs(w, r, req.PlayerId) {
+		return
+	}
+
+	if err := h.a.RequestBacking(r.Context(), tid, req.PlayerId, req.BackerId); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (h v2) acceptBacking(w http.ResponseWriter, r *http.Request, p []string) {
+	tid, ok := tourIdParam(w, p[0])
+	if !ok {
+		return
+	}
+	// only the backer can accept a request addressed to it
+	if !actAs(w, r, p[2]) {
+		return
+	}
+
+	if err := h.a.AcceptBacking(r.Context(), tid, p[1], p[2]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, BackingRequest{p[1], p[2]})
+}
+
+type ActiveTournament struct {
+	TournamentId int
+}
+
+func (h v2) activeTournament(w http.ResponseWriter, r *http.Request, p []string) {
+	id, err := h.a.ActiveTournament(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ActiveTournament{id})
+}
+
+func (h v2) acceptedBackers(w http.ResponseWriter, r *http.Request, p []string) {
+	tid, ok := tourIdParam(w, p[0])
+	if !ok {
+		return
+	}
+	if !actAs(w, r, p[1]) {
+		return
+	}
+
+	backers, err := h.a.AcceptedBackers(r.Context(), tid, p[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if backers == nil {
+		backers = []string{}
+	}
+	writeJSON(w, http.StatusOK, backers)
+}
+
+func (h v2) result(w http.ResponseWriter, r *http.Request, p []string) {
+	tid, ok := tourIdParam(w, p[0])
+	if !ok {
+		return
+	}
+
+	active, err := h.a.ActiveTournament(r.Context())
+	if err == nil && active != tid {
+		err = api.ErrTournamentNotRunning
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	winner, err := h.a.ResultTournament(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, winner)
+}
+
+type SeasonRequest struct {
+	CarryPercent int
+	CarryMax     int
+}
+
+func (h v2) closeSeason(w http.ResponseWriter, r *http.Request, p []string) {
+	var req SeasonRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.CarryPercent < 0 || req.CarryPercent > 100 || req.CarryMax < 0 {
+		writeError(w, apierr.Invalid("Invalid carry over"))
+		return
+	}
+
+	season, err := h.a.CloseSeason(r.Context(), api.CarryOver{Percent: req.CarryPercent, Max: req.CarryMax})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, season)
+}
+
+// leaderboard accepts "current" for the running season.
+func (h v2) leaderboard(w http.ResponseWriter, r *http.Request, p []string) {
+	seasonId := 0
+	if p[0] != "current" {
+		id, err := strconv.Atoi(p[0])
+		if err != nil || id < 1 {
+			writeError(w, apierr.Invalid("Invalid season id"))
+			return
+		}
+		seasonId = id
+	}
+
+	standings, err := h.a.Leaderboard(r.Context(), seasonId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, standings)
+}
+
+func (h v2) reset(w http.ResponseWriter, r *http.Request, p []string) {
+	if err := h.a.Reset(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h v2) backup(w http.ResponseWriter, r *http.Request, p []string) {
+	name := backupName(h.backupExt)
+	if err := h.a.Backup(r.Context(), path.Join(h.backupDir, name)); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, BackupFile{name})
+}
+
+func (h v2) restore(w http.ResponseWriter, r *http.Request, p []string) {
+	backupPath, err := findBackup(h.backupDir, p[0])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := h.a.Restore(r.Context(), backupPath); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h v2) export(w http.ResponseWriter, r *http.Request, p []string) {
+	exportHandler{h.a}.ServeHTTP(w, r)
+}
+
+func (h v2) importRecords(w http.ResponseWriter, r *http.Request, p []string) {
+	importHandler{h.a}.ServeHTTP(w, r)
+}
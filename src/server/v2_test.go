@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api"
+	"api/apierr"
+	"api/db"
+)
+
+func setupV2() (http.Handler, string, *TokenSigner, error) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	tokens := NewTokenSigner([]byte("secret"))
+	return newV2Handler(a, authenticator{s, tokens, nil, nil, nil, nil}, newWebhooks(s, newEventBroker()), "", ".db"), key, tokens, nil
+}
+
+func do(h http.Handler, method, url, key string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, url, &buf)
+	if key != "" {
+		r.Header.Set(ApiKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestV2_TournamentLifecycle(t *testing.T) {
+	h, key, _, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"P1", "P2"} {
+		w := do(h, "POST", "/v2/players/"+id+"/fund", key, PointsRequest{500})
+		if w.Code != http.StatusOK {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+
+	steps := []struct {
+		method, url string
+		body        interface{}
+		status      int
+	}{
+		{"POST", "/v2/tournaments", TournamentRequest{1, 400}, http.StatusCreated},
+		{"POST", "/v2/tournaments", TournamentRequest{2, 400}, http.StatusConflict},
+		{"POST", "/v2/tournaments/1/entries", EntryRequest{PlayerId: "P1"}, http.StatusCreated},
+		{"POST", "/v2/tournaments/1/entries", EntryRequest{PlayerId: "P2"}, http.StatusCreated},
+		{"POST", "/v2/tournaments/2/result", nil, http.StatusConflict},
+		{"POST", "/v2/tournaments/1/result", nil, http.StatusOK},
+	}
+	for _, s := range steps {
+		if w := do(h, s.method, s.url, key, s.body); w.Code != s.status {
+			t.Fatal(s.method, s.url, w.Code, w.Body.String())
+		}
+	}
+
+	w := do(h, "GET", "/v2/players/P1", key, nil)
+	var b Balance
+	if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || b.Balance != 900 {
+		t.Error(w.Code, b)
+	}
+}
+
+func TestV2_Routing(t *testing.T) {
+	h, key, tokens, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(h, "GET", "/v2/players/P1/fund", key, nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Error(w.Code, w.Header())
+	}
+	if w := do(h, "GET", "/v2/nothing", key, nil); w.Code != http.StatusNotFound {
+		t.Error(w.Code)
+	}
+	if w := do(h, "POST", "/v2/players/P1/fund", "", PointsRequest{1}); w.Code != http.StatusUnauthorized {
+		t.Error(w.Code)
+	}
+	if w := do(h, "POST", "/v2/players/P1/fund", key, "garbage"); w.Code != http.StatusBadRequest {
+		t.Error(w.Code)
+	}
+
+	token, _, err := tokens.Issue("P2", PlayerTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/v2/players/P1", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("player read another account", w.Code)
+	}
+}
+
+func TestV2_ErrorCodes(t *testing.T) {
+	h, key, _, err := setupV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method, url string
+		body        interface{}
+		status      int
+		code        apierr.Code
+	}{
+		{"POST", "/v2/players/P1/take", PointsRequest{10}, http.StatusNotFound, apierr.CodePlayerNotFound},
+		{"POST", "/v2/players/P1/fund", PointsRequest{10}, http.StatusOK, ""},
+		{"POST", "/v2/players/P1/take", PointsRequest{20}, http.StatusUnprocessableEntity, apierr.CodeInsufficientFunds},
+		{"POST", "/v2/tournaments/7/entries", EntryRequest{PlayerId: "P1"}, http.StatusNotFound, apierr.CodeTournamentNotFound},
+		{"GET", "/v2/seasons/9/leaderboard", nil, http.StatusNotFound, apierr.CodeSeasonNotFound},
+		{"GET", "/v2/players/P1/fund", nil, http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed},
+	}
+	for _, c := range cases {
+		w := do(h, c.method, c.url, key, c.body)
+		if w.Code != c.status {
+			t.Fatal(c.method, c.url, w.Code, w.Body.String())
+		}
+		if c.code == "" {
+			continue
+		}
+		var body ErrorBody
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(c.url, err)
+		}
+		if body.Code != c.code || body.Error == "" {
+			t.Error(c.method, c.url, body)
+		}
+	}
+}
+
+// failingBalance applies changes but fails to read balances.
+type failingBalance struct {
+	api.Api
+}
+
+func (failingBalance) Balance(ctx context.Context, playerId string) (int, error) {
+	return 0, errors.New("storage gone")
+}
+
+func TestV2_ChangeAppliedWithoutBalance(t *testing.T) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newV2Handler(failingBalance{a}, authenticator{s, NewTokenSigner([]byte("secret")), nil, nil, nil, nil}, newWebhooks(s, newEventBroker()), "", ".db")
+
+	// the fund is applied, a 5xx would make clients send it again
+	if w := do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{10}); w.Code != http.StatusNoContent {
+		t.Error(w.Code, w.Body.String())
+	}
+	if pts, err := s.PlayerPoints("P1"); err != nil || pts != 10 {
+		t.Error(pts, err)
+	}
+}
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api/apierr"
+	"api/db"
+	"logging"
+)
+
+// Headers of a webhook delivery. The signature is "sha256=" followed by
+// the hex HMAC-SHA256 of the timestamp, a dot and the body, keyed with the
+// secret of the webhook, see WebhookSignature.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// WebhookMaxAttempts is how often a delivery is attempted before it is
+// moved to the dead letters.
+const WebhookMaxAttempts = 8
+
+const (
+	webhookRetryWait    = 10 * time.Second
+	webhookMaxRetryWait = time.Hour
+	webhookTimeout      = 10 * time.Second
+	webhookPoll         = time.Second
+	webhookWorkers      = 4
+)
+
+var (
+	ErrWebhookNotFound  = apierr.New(apierr.CodeNotFound, "Webhook not found")
+	ErrDeliveryNotFound = apierr.New(apierr.CodeNotFound, "Delivery not found")
+)
+
+// webhookEvents are the event types webhooks can subscribe to.
+var webhookEvents = map[string]bool{
+	EventAnnounced:        true,
+	EventJoined:           true,
+	EventBackingRequested: true,
+	EventBackingAccepted:  true,
+	EventSettled:          true,
+	EventFunded:           true,
+	EventBalanceChanged:   true,
+}
+
+// WebhookSignature returns the signature of a delivery body sent at
+// timestamp, the unix time in seconds.
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp)
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhooks queues an event for every webhook subscribed to it and sends
+// the queued deliveries. The queue is kept in storage, so deliveries
+// survive a restart; failed ones are retried with exponential backoff.
+type webhooks struct {
+	store  db.Storage
+	events *eventBroker
+	client *http.Client
+
+	maxAttempts  int
+	retryWait    time.Duration
+	maxRetryWait time.Duration
+	poll         time.Duration // how often due deliveries are looked for
+
+	since   uint64 // id of the last event before the webhooks were created
+	wake    chan struct{}
+	running int32 // 1 while run runs, accessed atomically
+}
+
+func newWebhooks(store db.Storage, events *eventBroker) *webhooks {
+	return &webhooks{
+		store:        store,
+		events:       events,
+		client:       &http.Client{Timeout: webhookTimeout},
+		maxAttempts:  WebhookMaxAttempts,
+		retryWait:    webhookRetryWait,
+		maxRetryWait: webhookMaxRetryWait,
+		poll:         webhookPoll,
+		since:        events.last(),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// start runs the webhooks in the background until stop is closed. They
+// count as running as soon as start returns; jobs is done once they stop.
+func (wh *webhooks) start(stop <-chan struct{}, jobs *sync.WaitGroup) {
+	atomic.StoreInt32(&wh.running, 1)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		wh.run(stop)
+	}()
+}
+
+// run queues events and sends deliveries when they are due, until stop is
+// closed. It returns once the deliveries in flight are done and the events
+// published until then are queued.
+func (wh *webhooks) run(stop <-chan struct{}) {
+	atomic.StoreInt32(&wh.running, 1)
+	defer atomic.StoreInt32(&wh.running, 0)
+
+	queued := make(chan struct{})
+	go func() {
+		wh.queueEvents(stop)
+		close(queued)
+	}()
+	defer func() { <-queued }()
+
+	ticker := time.NewTicker(wh.poll)
+	defer ticker.Stop()
+	for {
+		wh.deliverDue(time.Now().UTC())
+		select {
+		case <-stop:
+			return
+		case <-wh.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// isRunning reports whether run queues and delivers events.
+func (wh *webhooks) isRunning() bool {
+	return atomic.LoadInt32(&wh.running) == 1
+}
+
+func (wh *webhooks) notify() {
+	select {
+	case wh.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueEvents queues every event published since the webhooks were
+// created until stop is closed. If it falls behind the broker it resumes
+// after the last event it queued.
+func (wh *webhooks) queueEvents(stop <-chan struct{}) {
+	lastId := wh.since
+	missed, events, cancel := wh.events.resume(lastId)
+	for {
+		for _, e := range missed {
+			wh.queue(e)
+			lastId = e.Id
+		}
+
+		select {
+		case <-stop:
+			cancel()
+			missed, _, cancel = wh.events.resume(lastId)
+			cancel()
+			for _, e := range missed {
+				wh.queue(e)
+			}
+			return
+		case e, ok := <-events:
+			if !ok {
+				missed, events, cancel = wh.events.resume(lastId)
+				continue
+			}
+			missed = []Event{e}
+		}
+	}
+}
+
+func (wh *webhooks) queue(e Event) {
+	hooks, err := wh.store.Webhooks()
+	if err != nil {
+		logging.Error(context.Background(), "webhook event not queued", err, logging.Fields{"event": e.Type})
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logging.Error(context.Background(), "webhook event not queued", err, logging.Fields{"event": e.Type})
+		return
+	}
+
+	now := time.Now().UTC()
+	queued := false
+	for _, hook := range hooks {
+		if !hook.Wants(e.Type) {
+			continue
+		}
+		id, err := randomHex(8)
+		if err != nil {
+			logging.Error(context.Background(), "webhook event not queued", err, logging.Fields{"event": e.Type})
+			return
+		}
+		d := db.Delivery{Id: id, WebhookId: hook.Id, EventType: e.Type, Payload: string(payload), State: db.DeliveryPending, NextAttempt: now, CreatedAt: now}
+		if err := wh.store.CreateDelivery(d); err != nil {
+			logging.Error(context.Background(), "webhook delivery not queued", err, logging.Fields{"event": e.Type, "webhookId": hook.Id})
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		wh.notify()
+	}
+}
+
+// deliverDue sends the pending deliveries due at now, a few at a time.
+func (wh *webhooks) deliverDue(now time.Time) {
+	pending, err := wh.store.Deliveries("", db.DeliveryPending)
+	if err != nil {
+		logging.Error(context.Background(), "webhook deliveries not read", err, nil)
+		return
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, webhookWorkers)
+	for _, d := range pending {
+		if d.NextAttempt.After(now) {
+			continue
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(d db.Delivery) {
+			defer wg.Done()
+			wh.attempt(d)
+			<-workers
+		}(*d)
+	}
+	wg.Wait()
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (wh *webhooks) backoff(attempts int) time.Duration {
+	d := wh.retryWait
+	for i := 1; i < attempts && d < wh.maxRetryWait; i++ {
+		d *= 2
+	}
+	if d > wh.maxRetryWait {
+		d = wh.maxRetryWait
+	}
+	return d
+}
+
+func (wh *webhooks) attempt(d db.Delivery) {
+	hook, err := wh.store.Webhook(d.WebhookId)
+	if err != nil {
+		// deleted while the delivery was in flight
+		return
+	}
+
+	d.Attempts++
+	d.LastStatus, err = wh.send(hook, d)
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		d.State = db.DeliveryDelivered
+		d.DeliveredAt = now
+		d.LastError = ""
+	case d.Attempts >= wh.maxAttempts:
+		d.State = db.DeliveryDead
+		d.LastError = err.Error()
+	default:
+		d.NextAttempt = now.Add(wh.backoff(d.Attempts))
+		d.LastError = err.Error()
+	}
+
+	if err := wh.store.UpdateDelivery(d); err != nil && err != db.ErrorNotFound {
+		logging.Error(context.Background(), "webhook delivery not updated", err, logging.Fields{"deliveryId": d.Id})
+	}
+}
+
+// send posts a delivery and returns the status of the response.
+func (wh *webhooks) send(hook *db.Webhook, d db.Delivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(hook.Secret, timestamp, body))
+	req.Header.Set(WebhookEventHeader, d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, d.Id)
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookRequest subscribes URL to events of the given types, all if
+// empty.
+type WebhookRequest struct {
+	URL    string
+	Events []string
+}
+
+// WebhookInfo is a webhook. Its secret is only returned when it is
+// created.
+type WebhookInfo struct {
+	Id        string
+	URL       string
+	Events    []string
+	Secret    string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func webhookInfo(hook *db.Webhook) WebhookInfo {
+	events := hook.Events
+	if events == nil {
+		events = []string{}
+	}
+	return WebhookInfo{Id: hook.Id, URL: hook.URL, Events: events, CreatedAt: hook.CreatedAt}
+}
+
+func (h v2) createWebhook(w http.ResponseWriter, r *http.Request, p []string) {
+	var req WebhookRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		badRequest(w, "URL")
+		return
+	}
+	for _, e := range req.Events {
+		if !webhookEvents[e] {
+			badRequest(w, "Events")
+			return
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	hook := db.Webhook{Id: id, URL: req.URL, Secret: secret, Events: req.Events, CreatedAt: time.Now().UTC()}
+	if err := h.hooks.store.CreateWebhook(hook); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info := webhookInfo(&hook)
+	info.Secret = secret
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (h v2) listWebhooks(w http.ResponseWriter, r *http.Request, p []string) {
+	hooks, err := h.hooks.store.Webhooks()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]WebhookInfo, 0, len(hooks))
+	for _, hook := range hooks {
+		res = append(res, webhookInfo(hook))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h v2) deleteWebhook(w http.ResponseWriter, r *http.Request, p []string) {
+	err := h.hooks.store.DeleteWebhook(p[0])
+	if err == db.ErrorNotFound {
+		err = ErrWebhookNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeliveries lists the deliveries of a webhook, optionally only those
+// in one state; state=dead lists the dead letters.
+func (h v2) listDeliveries(w http.ResponseWriter, r *http.Request, p []string) {
+	if _, err := h.hooks.store.Webhook(p[0]); err != nil {
+		if err == db.ErrorNotFound {
+			err = ErrWebhookNotFound
+		}
+		writeError(w, err)
+		return
+	}
+
+	deliveries, err := h.hooks.store.Deliveries(p[0], r.URL.Query().Get("state"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// retryDelivery queues a delivery again, typically a dead letter, with a
+// fresh set of attempts.
+func (h v2) retryDelivery(w http.ResponseWriter, r *http.Request, p []string) {
+	d, err := h.hooks.store.Delivery(p[1])
+	if err == db.ErrorNotFound || (err == nil && d.WebhookId != p[0]) {
+		err = ErrDeliveryNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	d.State = db.DeliveryPending
+	d.Attempts = 0
+	d.NextAttempt = time.Now().UTC()
+	if err := h.hooks.store.UpdateDelivery(*d); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.hooks.notify()
+	writeJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api"
+	"api/db"
+)
+
+func setupWebhooks(t *testing.T) (http.Handler, string, *webhooks, func()) {
+	s := db.CreateMemDb()
+	a, err := api.CreateApi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := IssueApiKey(s, RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := newEventBroker()
+	forwardEvents(a.Bus(), events)
+	hooks := newWebhooks(s, events)
+	hooks.maxAttempts = 3
+	hooks.retryWait = time.Millisecond
+	hooks.poll = time.Millisecond
+	stop := make(chan struct{})
+	go hooks.run(stop)
+
+	auth := authenticator{s, NewTokenSigner([]byte("secret")), newRateLimiter(RateLimits{}), nil, nil, nil}
+	return newHandler(a, auth, hooks, "", ".db"), key, hooks, func() { close(stop) }
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for", what)
+}
+
+func deliveries(t *testing.T, h http.Handler, key string, url string) []db.Delivery {
+	w := do(h, "GET", url, key, nil)
+	if w.Code != http.StatusOK {
+		t.Fatal(url, w.Code, w.Body.String())
+	}
+	var res []db.Delivery
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestWebhooks_SignedDelivery(t *testing.T) {
+	h, key, _, stop := setupWebhooks(t)
+	defer stop()
+
+	received := make(chan Event, 10)
+	var secret atomic.Value
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sig := WebhookSignature(secret.Load().(string), r.Header.Get(WebhookTimestampHeader), body)
+		if r.Header.Get(WebhookSignatureHeader) != sig {
+			t.Error("bad signature", r.Header)
+		}
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil || e.Type != r.Header.Get(WebhookEventHeader) {
+			t.Error(string(body), err)
+		}
+		received <- e
+	}))
+	defer receiver.Close()
+
+	w := do(h, "POST", "/v2/webhooks", key, WebhookRequest{URL: receiver.URL, Events: []string{EventFunded, EventSettled}})
+	if w.Code != http.StatusCreated {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	var hook WebhookInfo
+	if err := json.NewDecoder(w.Body).Decode(&hook); err != nil || hook.Secret == "" {
+		t.Fatal(hook, err)
+	}
+	secret.Store(hook.Secret)
+
+	do(h, "POST", "/v2/players/P1/fund", key, PointsRequest{500})
+	select {
+	case e := <-received:
+		if e.Type != EventFunded || e.PlayerId != "P1" || e.Points != 500 {
+			t.Error(e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing delivered")
+	}
+
+	waitFor(t, "delivered state", func() bool {
+		d := deliveries(t, h, key, "/v2/webhooks/"+hook.Id+"/deliveries?state=delivered")
+		return len(d) == 1 && d[0].Attempts == 1 && d[0].LastStatus == http.StatusOK
+	})
+
+	// the secret is only shown once; only subscribed events are queued
+	w = do(h, "GET", "/v2/webhooks", key, nil)
+	var hooks []WebhookInfo
+	if err := json.NewDecoder(w.Body).Decode(&hooks); err != nil || len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Error(hooks, err)
+	}
+	if all := deliveries(t, h, key, "/v2/webhooks/"+hook.Id+"/deliveries"); len(all) != 1 {
+		t.Error(all)
+	}
+
+	if w := do(h, "POST", "/v2/webhooks", key, WebhookRequest{URL: "ftp://partner"}); w.Code != http.StatusBadRequest {
+		t.Error(w.Code)
+	}
+	if w := do(h, "DELETE", "/v2/webhooks/"+hook.Id, key, nil); w.Code != http.StatusNoContent {
+		t.Error(w.Code)
+	}
+	if w := do(h, "GET", "/v2/webhooks/"+hook.Id+"/deliveries", key, nil); w.Code != http.StatusNotFound {
+		t.Error(w.Code)
+	}
+}
+
+func TestWebhooks_RetryAndDeadLetter(t *testing.T) {
+	h, key, _, stop := setupWebhooks(t)
+	defer stop()
+
+	var up int32
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	w := do(h, "POST", "/v2/webhooks", key, WebhookRequest{URL: receiver.URL})
+	var hook WebhookInfo
+	if err := json.NewDecoder(w.Body).Decode(&hook); err != nil {
+		t.Fatal(err)
+	}
+	do(h, "POST", "/v2/tournaments", key, TournamentRequest{1, 100})
+
+	url := "/v2/webhooks/" + hook.Id + "/deliveries?state=dead"
+	var dead []db.Delivery
+	waitFor(t, "dead letter", func() bool {
+		dead = deliveries(t, h, key, url)
+		return len(dead) == 1
+	})
+	if d := dead[0]; d.Attempts != 3 || d.LastStatus != http.StatusServiceUnavailable || d.EventType != EventAnnounced {
+		t.Error(d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Error("attempts", n)
+	}
+
+	// the partner is back, retry the dead letter by hand
+	atomic.StoreInt32(&up, 1)
+	if w := do(h, "POST", "/v2/webhooks/"+hook.Id+"/deliveries/"+dead[0].Id+"/retry", key, nil); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	waitFor(t, "redelivery", func() bool {
+		d := deliveries(t, h, key, "/v2/webhooks/"+hook.Id+"/deliveries?state=delivered")
+		return len(d) == 1
+	})
+
+	if w := do(h, "POST", "/v2/webhooks/"+hook.Id+"/deliveries/nope/retry", key, nil); w.Code != http.StatusNotFound {
+		t.Error(w.Code)
+	}
+}
+
+func TestWebhooks_QueueSurvivesRestart(t *testing.T) {
+	s := db.CreateMemDb()
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer receiver.Close()
+
+	// queued by a previous process
+	now := time.Now().UTC()
+	if err := s.CreateWebhook(db.Webhook{Id: "w1", URL: receiver.URL, Secret: "s", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateDelivery(db.Delivery{Id: "d1", WebhookId: "w1", EventType: EventSettled, Payload: "{}", State: db.DeliveryPending, NextAttempt: now, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	newWebhooks(s, newEventBroker()).deliverDue(time.Now().UTC())
+	if d, err := s.Delivery("d1"); err != nil || d.State != db.DeliveryDelivered || atomic.LoadInt32(&calls) != 1 {
+		t.Error(d, err)
+	}
+}
+
+func TestWebhooks_Backoff(t *testing.T) {
+	wh := &webhooks{retryWait: time.Second, maxRetryWait: 5 * time.Second}
+	for attempts, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := wh.backoff(attempts); d != want {
+			t.Error(attempts, d, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// sqlite-to-kv copies players and tournaments from a SQLite database into
+// a new key-value (bbolt) database usable with -storage=kv. The SQLite
+// database is only read; databases of older versions are upgraded in a
+// temporary copy.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"api/db"
+	"api/db/kvdb"
+)
+
+func migrate(sqlitePath, kvPath string) error {
+	if _, err := os.Stat(sqlitePath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(kvPath); err == nil {
+		return errors.New(kvPath + " already exists")
+	}
+
+	tmp, err := ioutil.TempDir("", "sqlite-to-kv")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	snapshot := filepath.Join(tmp, "snapshot.db")
+	if err := db.Snapshot(sqlitePath, snapshot); err != nil {
+		return err
+	}
+
+	src := &db.Db{}
+	if err := src.Create(snapshot); err != nil {
+		return err
+	}
+	defer src.Stop()
+
+	dst := &kvdb.KvDb{}
+	if err := dst.Create(kvPath); err != nil {
+		return err
+	}
+	defer dst.Stop()
+
+	return db.Copy(dst, src)
+}
+
+func main() {
+	sqlitePath := flag.String("sqlite", "db/back-a-friend.db", "source SQLite database")
+	kvPath := flag.String("kv", "db/back-a-friend.kv", "destination key-value database, must not exist")
+	flag.Parse()
+
+	if err := migrate(*sqlitePath, *kvPath); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"api/db/kvdb"
+)
+
+func TestMigrate_ExistingDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite-to-kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a database in the schema of the shipped db/back-a-friend.db
+	legacy, err := ioutil.ReadFile("../../db/back-a-friend.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlitePath := filepath.Join(dir, "back-a-friend.db")
+	if err := ioutil.WriteFile(sqlitePath, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := sql.Open("sqlite3", sqlitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Exec("insert into Players (PlayerId, Points) values ('P1', 300), ('P2', 700)")
+	if err == nil {
+		_, err = s.Exec("insert into Tournaments (TourId, Deposit, Players) values (1, 500, 'P1,P2')")
+	}
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(sqlitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kvPath := filepath.Join(dir, "back-a-friend.kv")
+	if err := migrate(sqlitePath, kvPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, err := ioutil.ReadFile(sqlitePath); err != nil || !bytes.Equal(before, after) {
+		t.Error("source database was modified", err)
+	}
+
+	kv := &kvdb.KvDb{}
+	if err := kv.Create(kvPath); err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Stop()
+	if pts, err := kv.PlayerPoints("P2"); err != nil || pts != 700 {
+		t.Error(pts, err)
+	}
+	if info, err := kv.TournamentInfo(1); err != nil || len(info.Entries) != 2 || info.SettledAt.IsZero() {
+		t.Error(info, err)
+	}
+
+	if err := migrate(sqlitePath, kvPath); err == nil {
+		t.Error("overwrote an existing key-value database")
+	}
+}